@@ -0,0 +1,63 @@
+// Package smamqp fires the triggers asked by the messages of AMQP queues,
+// e.g. of RabbitMQ, with a common.Consumer:
+//
+//	deliveries, _ := ch.Consume("triggers", "", false, false, false, false, nil)
+//	err := smamqp.Consume(ctx, deliveries, sm.NewConsumer(db, &Order{}), nil)
+package smamqp
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	common "sm"
+)
+
+// ErrClosed is returned by Consume when its deliveries channel is closed,
+// e.g. on a lost connection.
+var ErrClosed = errors.New("smamqp: deliveries closed")
+
+// RetryDelay is the delay before a message failing transiently is requeued.
+var RetryDelay = time.Second
+
+// Consume hands deliveries, consumed without auto-ack, to c until ctx is
+// done. Messages without an Id are deduplicated by their AMQP message-id, if
+// any. Handled messages are acknowledged, messages failing for good, see
+// common.IsPermanent, rejected without requeue, to be dead-lettered if the
+// queue has an exchange for them, and the others requeued after RetryDelay.
+// Errors are reported to onError, which may be nil.
+func Consume(ctx context.Context, deliveries <-chan amqp.Delivery, c *common.Consumer, onError func(amqp.Delivery, error)) error {
+	for {
+		var d amqp.Delivery
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return nil
+		case d, ok = <-deliveries:
+			if !ok {
+				return ErrClosed
+			}
+		}
+		err := c.HandleData(ctx, d.Body, d.MessageId)
+		if err != nil && onError != nil {
+			onError(d, err)
+		}
+		switch {
+		case err == nil:
+			err = d.Ack(false)
+		case common.IsPermanent(err):
+			err = d.Nack(false, false)
+		default:
+			select {
+			case <-ctx.Done():
+			case <-time.After(RetryDelay):
+			}
+			err = d.Nack(false, true)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
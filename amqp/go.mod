@@ -0,0 +1,18 @@
+module sm/amqp
+
+go 1.20
+
+require (
+	github.com/rabbitmq/amqp091-go v1.10.0
+	sm v0.0.0
+)
+
+require (
+	github.com/jinzhu/inflection v1.0.0 // indirect
+	github.com/jinzhu/now v1.1.2 // indirect
+	golang.org/x/text v0.3.7 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+	gorm.io/gorm v1.22.3 // indirect
+)
+
+replace sm => ../
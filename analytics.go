@@ -0,0 +1,194 @@
+package common
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// StateSpan is a stay of an object in a state, from the log entry entering
+// it to the one leaving it. The stay in the initial state before the first
+// transition is not in the log, so has no span.
+type StateSpan struct {
+	ObjectStruct string
+	ObjectId     uint
+	// ObjectKey is that of the log entries, which tells apart the objects
+	// whose key is not an unsigned integer, with a zero ObjectId.
+	ObjectKey string
+	Region    string
+	State     string
+	EnteredAt time.Time
+	// ExitedAt is zero while the object is still in State.
+	ExitedAt time.Time
+}
+
+// Duration returns the length of s, up to now if it is not over.
+func (s StateSpan) Duration(now time.Time) time.Duration {
+	if s.ExitedAt.IsZero() {
+		return now.Sub(s.EnteredAt)
+	}
+	return s.ExitedAt.Sub(s.EnteredAt)
+}
+
+// StateSpansOf returns the spans of entries, sorted by ID. Entries not
+// changing the state, e.g. internal or ignored transitions, are skipped.
+func StateSpansOf(entries []StateMachineLog) []StateSpan {
+	var spans []StateSpan
+	type object struct {
+		objectStruct string
+		id           uint
+		key          string
+		region       string
+	}
+	open := map[object]int{}
+	for _, entry := range entries {
+		if entry.Source == entry.Dest {
+			continue
+		}
+		// As in sameObject, the key only tells apart the objects without ID.
+		key := object{entry.ObjectStruct, entry.ObjectId, "", entry.Region}
+		if entry.ObjectId == 0 {
+			key.key = entry.ObjectKey
+		}
+		if i, ok := open[key]; ok {
+			spans[i].ExitedAt = entry.CreatedAt
+		}
+		open[key] = len(spans)
+		spans = append(spans, StateSpan{
+			ObjectStruct: entry.ObjectStruct,
+			ObjectId:     entry.ObjectId,
+			ObjectKey:    entry.ObjectKey,
+			Region:       entry.Region,
+			State:        entry.Dest,
+			EnteredAt:    entry.CreatedAt,
+		})
+	}
+	return spans
+}
+
+// StateSpans returns the spans of the bound object, in order.
+func (sm *StateMachine) StateSpans(tx *gorm.DB) ([]StateSpan, error) {
+	query, err := whereObject(tx, sm.stater)
+	if err != nil {
+		return nil, err
+	}
+	var entries []StateMachineLog
+	if err := scopeTenant(query).Where("source <> dest").Order("id").Find(&entries).Error; err != nil {
+		return nil, fmt.Errorf("read log of %s: %w", StructName(sm.stater), err)
+	}
+	return StateSpansOf(entries), nil
+}
+
+// DurationStats aggregates the spans of a state.
+type DurationStats struct {
+	Count    int
+	Total    time.Duration
+	Min, Max time.Duration
+}
+
+func (s *DurationStats) Average() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Count)
+}
+
+func (s *DurationStats) add(d time.Duration) {
+	if s.Count == 0 || d < s.Min {
+		s.Min = d
+	}
+	if d > s.Max {
+		s.Max = d
+	}
+	s.Count++
+	s.Total += d
+}
+
+// StateDurationStats returns, by state, the stats of the spans of the objects
+// of model's type entered in [since, until), unbounded if zero, and over:
+//
+//	stats, err := StateDurationStats(db, &Order{}, time.Time{}, time.Time{})
+//	fmt.Println(stats["REVIEW"].Average())
+//
+// The states of the regions are keyed "region:state".
+func StateDurationStats(tx *gorm.DB, model Stater, since, until time.Time) (map[string]*DurationStats, error) {
+	query := scopeTenant(tx.Model(&StateMachineLog{})).Where("object_struct = ? AND source <> dest", StructName(model))
+	if !since.IsZero() {
+		query = query.Where("created_at >= ?", since)
+	}
+	rows, err := query.Order(objectPartition + ", region, id").Rows()
+	if err != nil {
+		return nil, fmt.Errorf("read log of %s: %w", StructName(model), err)
+	}
+	defer rows.Close()
+
+	stats := map[string]*DurationStats{}
+	var last *StateMachineLog
+	for rows.Next() {
+		entry := &StateMachineLog{}
+		if err := tx.ScanRows(rows, entry); err != nil {
+			return nil, err
+		}
+		if last != nil && sameObject(last, entry) && last.Region == entry.Region &&
+			(until.IsZero() || last.CreatedAt.Before(until)) {
+			key := last.Dest
+			if last.Region != "" {
+				key = last.Region + ":" + key
+			}
+			if stats[key] == nil {
+				stats[key] = &DurationStats{}
+			}
+			stats[key].add(entry.CreatedAt.Sub(last.CreatedAt))
+		}
+		last = entry
+	}
+	return stats, rows.Err()
+}
+
+// sameObject reports whether the log entries a and b, of the same model, are
+// of the same object, see objectPartition.
+func sameObject(a, b *StateMachineLog) bool {
+	return a.ObjectId == b.ObjectId && (a.ObjectId != 0 || a.ObjectKey == b.ObjectKey)
+}
+
+// StateDurationView is the name of the view created by
+// CreateStateDurationView.
+const StateDurationView = "state_machine_state_durations"
+
+// StateDurationViewSQL returns the statement creating StateDurationView for
+// the gorm dialect (mysql, postgres or sqlite): a row per span, with its
+// object_struct, object_id, region, state, entered_at, exited_at, the
+// seconds between them, NULL while open, tenant_id and object_key, e.g. to
+// average in SQL:
+//
+//	SELECT state, AVG(seconds) FROM state_machine_state_durations
+//	WHERE object_struct = 'Order' GROUP BY state
+func StateDurationViewSQL(dialect string) (string, error) {
+	create, seconds := "CREATE OR REPLACE VIEW ", ""
+	switch dialect {
+	case "mysql":
+		seconds = "TIMESTAMPDIFF(SECOND, entered_at, exited_at)"
+	case "postgres":
+		seconds = "EXTRACT(EPOCH FROM exited_at - entered_at)"
+	case "sqlite":
+		create = "CREATE VIEW IF NOT EXISTS "
+		seconds = "(julianday(exited_at) - julianday(entered_at)) * 86400"
+	default:
+		return "", fmt.Errorf("no state duration view for %s", dialect)
+	}
+	return create + StateDurationView + " AS " +
+		"SELECT object_struct, object_id, region, state, entered_at, exited_at, " + seconds + " AS seconds, tenant_id, object_key FROM (" +
+		"SELECT object_struct, object_id, region, dest AS state, created_at AS entered_at, tenant_id, object_key, " +
+		"LEAD(created_at) OVER (PARTITION BY " + objectPartition + ", region ORDER BY id) AS exited_at " +
+		"FROM state_machine_logs WHERE source <> dest AND deleted_at IS NULL) spans", nil
+}
+
+// CreateStateDurationView creates or replaces StateDurationView.
+func CreateStateDurationView(tx *gorm.DB) error {
+	statement, err := StateDurationViewSQL(tx.Dialector.Name())
+	if err != nil {
+		return err
+	}
+	return tx.Exec(statement).Error
+}
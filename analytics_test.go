@@ -0,0 +1,149 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// at returns the instant minutes after epoch.
+func at(minutes int) time.Time {
+	return epoch.Add(time.Duration(minutes) * time.Minute)
+}
+
+// parcelStruct is the ObjectStruct of the log entries of restoredParcel.
+var parcelStruct = StructName(&restoredParcel{})
+
+// logEntry returns the log entry of a transition of a parcel, keyed by
+// ObjectId if id is not 0, by ObjectKey otherwise.
+func logEntry(id uint, key, source, dest string, minutes int) StateMachineLog {
+	return StateMachineLog{
+		Model:        gorm.Model{CreatedAt: at(minutes)},
+		ObjectStruct: parcelStruct,
+		ObjectId:     id,
+		ObjectKey:    key,
+		Source:       source,
+		Dest:         dest,
+	}
+}
+
+func TestStateSpansOf(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []StateMachineLog
+		want    []StateSpan
+	}{
+		{
+			name: "consecutive entries",
+			entries: []StateMachineLog{
+				logEntry(1, "1", "INITIALIZED", "PAID", 0),
+				logEntry(1, "1", "PAID", "SHIPPED", 10),
+			},
+			want: []StateSpan{
+				{ObjectStruct: parcelStruct, ObjectId: 1, ObjectKey: "1", State: "PAID", EnteredAt: at(0), ExitedAt: at(10)},
+				{ObjectStruct: parcelStruct, ObjectId: 1, ObjectKey: "1", State: "SHIPPED", EnteredAt: at(10)},
+			},
+		},
+		{
+			name: "entries not changing the state",
+			entries: []StateMachineLog{
+				logEntry(1, "1", "INITIALIZED", "PAID", 0),
+				logEntry(1, "1", "PAID", "PAID", 5),
+				logEntry(1, "1", "PAID", "SHIPPED", 10),
+			},
+			want: []StateSpan{
+				{ObjectStruct: parcelStruct, ObjectId: 1, ObjectKey: "1", State: "PAID", EnteredAt: at(0), ExitedAt: at(10)},
+				{ObjectStruct: parcelStruct, ObjectId: 1, ObjectKey: "1", State: "SHIPPED", EnteredAt: at(10)},
+			},
+		},
+		{
+			name: "objects keyed by string",
+			entries: []StateMachineLog{
+				logEntry(0, "a1", "INITIALIZED", "PAID", 0),
+				logEntry(0, "b2", "INITIALIZED", "PAID", 5),
+				logEntry(0, "a1", "PAID", "SHIPPED", 10),
+			},
+			want: []StateSpan{
+				{ObjectStruct: parcelStruct, ObjectKey: "a1", State: "PAID", EnteredAt: at(0), ExitedAt: at(10)},
+				{ObjectStruct: parcelStruct, ObjectKey: "b2", State: "PAID", EnteredAt: at(5)},
+				{ObjectStruct: parcelStruct, ObjectKey: "a1", State: "SHIPPED", EnteredAt: at(10)},
+			},
+		},
+		{
+			name: "regions",
+			entries: []StateMachineLog{
+				logEntry(1, "1", "INITIALIZED", "PAID", 0),
+				{Model: gorm.Model{CreatedAt: at(5)}, ObjectStruct: parcelStruct, ObjectId: 1, ObjectKey: "1", Region: "review", Source: "PENDING", Dest: "APPROVED"},
+				logEntry(1, "1", "PAID", "SHIPPED", 10),
+			},
+			want: []StateSpan{
+				{ObjectStruct: parcelStruct, ObjectId: 1, ObjectKey: "1", State: "PAID", EnteredAt: at(0), ExitedAt: at(10)},
+				{ObjectStruct: parcelStruct, ObjectId: 1, ObjectKey: "1", Region: "review", State: "APPROVED", EnteredAt: at(5)},
+				{ObjectStruct: parcelStruct, ObjectId: 1, ObjectKey: "1", State: "SHIPPED", EnteredAt: at(10)},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := StateSpansOf(tt.entries); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("StateSpansOf = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStateDurationStats(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []StateMachineLog
+		want    map[string]DurationStats
+	}{
+		{
+			name: "objects keyed by ID",
+			entries: []StateMachineLog{
+				logEntry(1, "1", "INITIALIZED", "PAID", 0),
+				logEntry(2, "2", "INITIALIZED", "PAID", 5),
+				logEntry(1, "1", "PAID", "SHIPPED", 10),
+				logEntry(2, "2", "PAID", "SHIPPED", 35),
+			},
+			want: map[string]DurationStats{
+				"PAID": {Count: 2, Total: 40 * time.Minute, Min: 10 * time.Minute, Max: 30 * time.Minute},
+			},
+		},
+		{
+			name: "objects keyed by string",
+			entries: []StateMachineLog{
+				logEntry(0, "a1", "INITIALIZED", "PAID", 0),
+				logEntry(0, "b2", "INITIALIZED", "PAID", 5),
+				logEntry(0, "a1", "PAID", "SHIPPED", 10),
+				logEntry(0, "b2", "PAID", "SHIPPED", 35),
+			},
+			want: map[string]DurationStats{
+				"PAID": {Count: 2, Total: 40 * time.Minute, Min: 10 * time.Minute, Max: 30 * time.Minute},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			if err := db.Create(tt.entries).Error; err != nil {
+				t.Fatal(err)
+			}
+			stats, err := StateDurationStats(db, &restoredParcel{}, time.Time{}, time.Time{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]DurationStats{}
+			for state, s := range stats {
+				got[state] = *s
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("StateDurationStats = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// ArgsMarshaler serializes the arguments of a trigger for its StateMachineLog.
+type ArgsMarshaler func(trigger string, args []interface{}) ([]byte, error)
+
+// JSONArgs serializes the arguments as a JSON array.
+func JSONArgs(_ string, args []interface{}) ([]byte, error) {
+	return json.Marshal(args)
+}
+
+var (
+	argsMarshalerMu sync.RWMutex
+	argsMarshaler   ArgsMarshaler
+)
+
+// MarshalArgs records the arguments of the transitions in the Args of their
+// StateMachineLog, serialized by marshaler, e.g. JSONArgs, for reprocessing
+// or debugging; nil, the default, records none. A transition fails if its
+// arguments cannot be serialized.
+func MarshalArgs(marshaler ArgsMarshaler) {
+	argsMarshalerMu.Lock()
+	defer argsMarshalerMu.Unlock()
+	argsMarshaler = marshaler
+}
+
+func marshalArgs(trigger string, args []interface{}) (string, error) {
+	argsMarshalerMu.RLock()
+	marshaler := argsMarshaler
+	argsMarshalerMu.RUnlock()
+	if marshaler == nil || len(args) == 0 {
+		return "", nil
+	}
+	data, err := marshaler(trigger, args)
+	if err != nil {
+		return "", fmt.Errorf("serialize arguments of %s: %w", trigger, err)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,178 @@
+package common
+
+import (
+	"context"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// Future is the pending result of an asynchronous transition.
+type Future struct {
+	done   chan struct{}
+	result *TransitionResult
+	err    error
+}
+
+func newFuture() *Future {
+	return &Future{done: make(chan struct{})}
+}
+
+func failedFuture(err error) *Future {
+	f := newFuture()
+	f.resolve(err)
+	return f
+}
+
+func (f *Future) resolve(err error) {
+	f.err = err
+	close(f.done)
+}
+
+func (f *Future) resolveResult(result *TransitionResult, err error) {
+	f.result = result
+	f.resolve(err)
+}
+
+// Done is closed once the transition has finished.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Err returns the result of the transition; it is only meaningful once Done
+// is closed.
+func (f *Future) Err() error {
+	select {
+	case <-f.done:
+		return f.err
+	default:
+		return nil
+	}
+}
+
+// Result returns what the transition did once Done is closed, nil before or
+// if it never started.
+func (f *Future) Result() *TransitionResult {
+	select {
+	case <-f.done:
+		return f.result
+	default:
+		return nil
+	}
+}
+
+// Wait blocks until the transition has finished or ctx is done.
+func (f *Future) Wait(ctx context.Context) error {
+	select {
+	case <-f.done:
+		return f.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type asyncJob struct {
+	ctx        context.Context
+	sm         *StateMachine
+	trigger    string
+	operatorId uint
+	args       []interface{}
+	future     *Future
+}
+
+// Pool runs transitions on a fixed number of workers, each in its own
+// transaction of db.
+type Pool struct {
+	db     *gorm.DB
+	jobs   chan asyncJob
+	wg     sync.WaitGroup
+	mu     sync.RWMutex
+	closed bool
+}
+
+func NewPool(db *gorm.DB, workers int) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	p := &Pool{db: db, jobs: make(chan asyncJob, workers)}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *Pool) work() {
+	defer p.wg.Done()
+	for job := range p.jobs {
+		if err := job.ctx.Err(); err != nil {
+			job.future.resolve(err)
+			continue
+		}
+		var result *TransitionResult
+		err := p.db.WithContext(job.ctx).Transaction(func(tx *gorm.DB) (err error) {
+			result, err = job.sm.FireCtx(job.ctx, tx, job.trigger, job.operatorId, job.args...)
+			return err
+		})
+		job.future.resolveResult(result, err)
+	}
+}
+
+// Do schedules trigger on obj. obj must not be used until the Future is done.
+func (p *Pool) Do(ctx context.Context, obj Stater, trigger string, operatorId uint, args ...interface{}) *Future {
+	sm, err := machineOf(obj)
+	if err != nil {
+		return failedFuture(err)
+	}
+	return p.submit(ctx, sm, trigger, operatorId, args)
+}
+
+func (p *Pool) submit(ctx context.Context, sm *StateMachine, trigger string, operatorId uint, args []interface{}) *Future {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return failedFuture(ErrPoolClosed)
+	}
+	job := asyncJob{ctx: ctx, sm: sm, trigger: trigger, operatorId: operatorId, args: args, future: newFuture()}
+	select {
+	case p.jobs <- job:
+		return job.future
+	case <-ctx.Done():
+		return failedFuture(ctx.Err())
+	}
+}
+
+// Close stops accepting transitions and waits for the scheduled ones.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.jobs)
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+var (
+	defaultPoolMu sync.RWMutex
+	defaultPool   *Pool
+)
+
+// SetAsyncPool sets the pool used by DoAsync.
+func SetAsyncPool(pool *Pool) {
+	defaultPoolMu.Lock()
+	defer defaultPoolMu.Unlock()
+	defaultPool = pool
+}
+
+// DoAsync schedules the transition on the pool set with SetAsyncPool. The
+// object must not be used until the Future is done.
+func (sm *StateMachine) DoAsync(ctx context.Context, trigger string, userInfoId uint, args ...interface{}) *Future {
+	defaultPoolMu.RLock()
+	pool := defaultPool
+	defaultPoolMu.RUnlock()
+	if pool == nil {
+		return failedFuture(ErrNoPool)
+	}
+	return pool.submit(ctx, sm, trigger, userInfoId, args)
+}
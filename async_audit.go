@@ -0,0 +1,169 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Overflow policies of an AsyncSink whose buffer is full.
+const (
+	// OverflowBlock waits for room in the buffer.
+	OverflowBlock = iota
+	// OverflowDrop discards the entries, counted by Dropped.
+	OverflowDrop
+	// OverflowSync inserts the entries in the transaction of the transition.
+	OverflowSync
+)
+
+type AsyncSinkConfig struct {
+	// Buffer is the number of entries waiting to be written, 1024 if 0.
+	Buffer int
+	// BatchSize is the maximum number of entries inserted at once, 100 if 0.
+	BatchSize int
+	// FlushInterval is the maximum delay of an entry, time.Second if 0.
+	FlushInterval time.Duration
+	// Overflow is the policy when the buffer is full, OverflowBlock if 0.
+	Overflow int
+	// OnError is called with the errors of the inserts, logged if nil.
+	OnError func(error)
+}
+
+// AsyncSink is an AuditSink inserting the entries in the state_machine_logs
+// table in batches, from a background worker using its own connection rather
+// than the transactions of the transitions. Entries are thus written even if
+// their transaction is rolled back, may be lost if the process crashes, and
+// have no ID when hooks see them.
+//
+//	sink := common.NewAsyncSink(db, common.AsyncSinkConfig{})
+//	common.SetAuditSink(sink)
+//	defer sink.Close(context.Background())
+type AsyncSink struct {
+	db      *gorm.DB
+	config  AsyncSinkConfig
+	entries chan StateMachineLog
+	done    chan struct{}
+	mu      sync.RWMutex
+	closed  bool
+	dropped uint64
+}
+
+// NewAsyncSink returns the sink writing to db and starts its worker.
+func NewAsyncSink(db *gorm.DB, config AsyncSinkConfig) *AsyncSink {
+	if config.Buffer <= 0 {
+		config.Buffer = 1024
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 100
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = time.Second
+	}
+	s := &AsyncSink{
+		db:      db,
+		config:  config,
+		entries: make(chan StateMachineLog, config.Buffer),
+		done:    make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Write queues the entries, or inserts them in tx once s is closed.
+func (s *AsyncSink) Write(ctx context.Context, tx *gorm.DB, entries []*StateMachineLog) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return GormSink{}.Write(ctx, tx, entries)
+	}
+	for i, entry := range entries {
+		if entry.CreatedAt.IsZero() {
+			entry.CreatedAt = time.Now()
+			entry.UpdatedAt = entry.CreatedAt
+		}
+		switch s.config.Overflow {
+		case OverflowDrop:
+			select {
+			case s.entries <- *entry:
+			default:
+				atomic.AddUint64(&s.dropped, 1)
+			}
+		case OverflowSync:
+			select {
+			case s.entries <- *entry:
+			default:
+				return GormSink{}.Write(ctx, tx, entries[i:])
+			}
+		default:
+			select {
+			case s.entries <- *entry:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	return nil
+}
+
+// Dropped returns the number of entries discarded by OverflowDrop.
+func (s *AsyncSink) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close stops accepting entries, later written synchronously, and waits until
+// the queued ones are written or ctx is done.
+func (s *AsyncSink) Close(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.entries)
+	}
+	s.mu.Unlock()
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *AsyncSink) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.config.FlushInterval)
+	defer ticker.Stop()
+	batch := make([]StateMachineLog, 0, s.config.BatchSize)
+	for {
+		select {
+		case entry, ok := <-s.entries:
+			if !ok {
+				s.flush(batch)
+				return
+			}
+			if batch = append(batch, entry); len(batch) >= s.config.BatchSize {
+				s.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			s.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+func (s *AsyncSink) flush(batch []StateMachineLog) {
+	if len(batch) == 0 {
+		return
+	}
+	err := s.db.CreateInBatches(batch, s.config.BatchSize).Error
+	switch {
+	case err == nil:
+	case s.config.OnError != nil:
+		s.config.OnError(fmt.Errorf("log %d transitions: %w", len(batch), err))
+	default:
+		currentLogger().Error("log transitions", "count", len(batch), "error", err)
+	}
+}
@@ -0,0 +1,44 @@
+package common
+
+import (
+	"reflect"
+	"sync/atomic"
+
+	"gorm.io/gorm"
+)
+
+var autoTransaction int32
+
+// AutoTransaction makes Do, BatchDo, Revert and ForceState run in their own
+// transaction, a savepoint of tx if it is one already, so that the state
+// UPDATE, the callbacks and the log commit or roll back together whatever tx
+// the callers pass. When they fail, the top-level fields of the objects, the
+// states included, are also restored to their values before the transition.
+// Middlewares run outside of the transaction.
+func AutoTransaction(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&autoTransaction, v)
+}
+
+// atomically runs fn as described by AutoTransaction, if enabled.
+func atomically(tx *gorm.DB, staters []Stater, fn func(tx *gorm.DB) error) error {
+	if atomic.LoadInt32(&autoTransaction) == 0 {
+		return fn(tx)
+	}
+	saved := make([]reflect.Value, len(staters))
+	for i, stater := range staters {
+		v := reflect.Indirect(reflect.ValueOf(stater))
+		saved[i] = reflect.New(v.Type()).Elem()
+		saved[i].Set(v)
+	}
+	if err := tx.Transaction(fn); err != nil {
+		for i, stater := range staters {
+			reflect.Indirect(reflect.ValueOf(stater)).Set(saved[i])
+		}
+		return err
+	}
+	return nil
+}
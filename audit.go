@@ -0,0 +1,134 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AuditSink records the StateMachineLog entries of the transitions, in the
+// transaction tx they were performed in. Entries a sink does not store in the
+// state_machine_logs table are missed by the features reading it: Revert,
+// Replay, and the history of the dashboard.
+type AuditSink interface {
+	Write(ctx context.Context, tx *gorm.DB, entries []*StateMachineLog) error
+}
+
+// AuditFunc is an AuditSink func.
+type AuditFunc func(ctx context.Context, tx *gorm.DB, entries []*StateMachineLog) error
+
+func (f AuditFunc) Write(ctx context.Context, tx *gorm.DB, entries []*StateMachineLog) error {
+	return f(ctx, tx, entries)
+}
+
+// GormSink, the default, inserts the entries in the state_machine_logs table,
+// setting their IDs.
+type GormSink struct{}
+
+func (GormSink) Write(_ context.Context, tx *gorm.DB, entries []*StateMachineLog) error {
+	return tx.CreateInBatches(entries, 100).Error
+}
+
+// NopSink discards the entries.
+type NopSink struct{}
+
+func (NopSink) Write(context.Context, *gorm.DB, []*StateMachineLog) error {
+	return nil
+}
+
+// FileSink writes the entries to a file as JSON lines, timestamped but
+// without IDs. Lines are written when the transitions are, and stay if their
+// transaction is rolled back.
+type FileSink struct {
+	mu  sync.Mutex
+	w   io.Writer
+	enc *json.Encoder
+}
+
+func NewFileSink(w io.Writer) *FileSink {
+	return &FileSink{w: w, enc: json.NewEncoder(w)}
+}
+
+// OpenFileSink returns the FileSink appending to the file at path, created
+// if needed.
+func OpenFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return NewFileSink(f), nil
+}
+
+func (s *FileSink) Write(_ context.Context, _ *gorm.DB, entries []*StateMachineLog) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, entry := range entries {
+		if entry.CreatedAt.IsZero() {
+			entry.CreatedAt = time.Now()
+			entry.UpdatedAt = entry.CreatedAt
+		}
+		if err := s.enc.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the writer of s, if it is an io.Closer.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// SkipAudit returns sink not recording the entries of triggers, given by name,
+// applying to every model, or as "Model:trigger".
+func SkipAudit(sink AuditSink, triggers ...string) AuditSink {
+	skipped := make(map[string]bool, len(triggers))
+	for _, trigger := range triggers {
+		skipped[trigger] = true
+	}
+	return AuditFunc(func(ctx context.Context, tx *gorm.DB, entries []*StateMachineLog) error {
+		kept := make([]*StateMachineLog, 0, len(entries))
+		for _, entry := range entries {
+			if !skipped[entry.Trigger] && !skipped[entry.ObjectStruct+":"+entry.Trigger] {
+				kept = append(kept, entry)
+			}
+		}
+		if len(kept) == 0 {
+			return nil
+		}
+		return sink.Write(ctx, tx, kept)
+	})
+}
+
+var (
+	auditSinkMu sync.RWMutex
+	auditSink   AuditSink
+)
+
+// SetAuditSink sets the sink recording the log of every transition; nil
+// restores that of the Store, the GormSink by default.
+func SetAuditSink(sink AuditSink) {
+	auditSinkMu.Lock()
+	defer auditSinkMu.Unlock()
+	auditSink = sink
+}
+
+func currentAuditSink() AuditSink {
+	auditSinkMu.RLock()
+	sink := auditSink
+	auditSinkMu.RUnlock()
+	if sink == nil {
+		return currentStore()
+	}
+	return sink
+}
@@ -0,0 +1,281 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type batchItem struct {
+	ctx    context.Context
+	sm     *StateMachine
+	r      *region
+	config *TriggerConfig
+	event  *TransitionEvent
+	branch string
+}
+
+// BatchDo fires trigger on every object, all of the same model, as a whole:
+// nothing is changed unless every object accepts the trigger. States are
+// stored with one UPDATE per destination and the logs with a bulk insert.
+// Callbacks and global hooks still run per object, middlewares do not.
+// Objects in an ignored state of the trigger are only logged. tx should be a
+// transaction; nil runs the batch without persistence, see WithNoPersist.
+func BatchDo(tx *gorm.DB, objects []Stater, trigger string, userInfoId uint, args ...interface{}) error {
+	if len(objects) == 0 {
+		return nil
+	}
+	ctx := contextOf(tx)
+	if tx == nil {
+		ctx = WithNoPersist(ctx)
+		tx = MemoryDB().WithContext(ctx)
+	}
+	ctx, published := publishing(ctx)
+	run := func(tx *gorm.DB) error {
+		return batchDo(ctx, tx, objects, trigger, userInfoId, args...)
+	}
+	var err error
+	if persisting(ctx) {
+		err = atomically(tx, objects, run)
+	} else {
+		err = run(tx)
+	}
+	published(err)
+	return err
+}
+
+func batchDo(ctx context.Context, tx *gorm.DB, objects []Stater, trigger string, userInfoId uint, args ...interface{}) (err error) {
+	modelType := reflect.TypeOf(objects[0])
+	serializedArgs, err := marshalArgs(trigger, args)
+	if err != nil {
+		return err
+	}
+
+	items := make([]*batchItem, 0, len(objects))
+	var ignored []*StateMachineLog
+	for _, obj := range objects {
+		if reflect.TypeOf(obj) != modelType {
+			return fmt.Errorf("BatchDo mixes %s and %s", StructName(objects[0]), StructName(obj))
+		}
+		sm, err := machineOf(obj)
+		if err != nil {
+			return err
+		}
+		if inTransition(ctx, obj) {
+			return fmt.Errorf("%w: %s on %s", ErrNestedTransition, trigger, StructName(obj))
+		}
+		definition, err := sm.Definition()
+		if err != nil {
+			return err
+		}
+		trigger := definition.Canonical(trigger)
+		r, err := sm.regionOf(definition, trigger)
+		if err != nil {
+			return err
+		}
+		if persisting(ctx) {
+			if err := checkDeleted(tx, obj); err != nil {
+				return err
+			}
+			if err := lockRow(ctx, tx, definition, obj); err != nil {
+				return err
+			}
+		}
+		config, err := sm.check(ctx, tx, definition, r, trigger, args...)
+		if errors.Is(err, ErrAlreadyInState) {
+			id, key, err := objectKeys(obj)
+			if err != nil {
+				return err
+			}
+			ignored = append(ignored, &StateMachineLog{
+				ObjectId:     id,
+				ObjectKey:    key,
+				ObjectStruct: StructName(obj),
+				Region:       r.name,
+				Trigger:      trigger,
+				Source:       r.state(obj),
+				Dest:         r.state(obj),
+				OperatorId:   userInfoId,
+				Reason:       ReasonFromContext(ctx),
+				Metadata:     LogMetadataFromContext(ctx),
+				Args:         serializedArgs,
+				TraceId:      traceIdOf(ctx),
+				TenantId:     TenantFromContext(ctx),
+			})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		objCtx := withTransition(ctx, obj)
+		dest, branch, err := sm.resolveDest(objCtx, tx, r, trigger, config, args...)
+		if err != nil {
+			return err
+		}
+		items = append(items, &batchItem{ctx: objCtx, sm: sm, r: r, config: config, branch: branch, event: &TransitionEvent{
+			Object:     obj,
+			Region:     r.name,
+			Trigger:    trigger,
+			Source:     r.state(obj),
+			Dest:       dest,
+			OperatorId: userInfoId,
+			Args:       args,
+		}})
+	}
+
+	for _, item := range items {
+		if err := runHooks(item.ctx, tx, &beforeHooks, item.event); err != nil {
+			return err
+		}
+		if !item.config.Internal {
+			if err := runCallbacks(item.ctx, tx, item.r.definition.onExit[item.event.Source], args...); err != nil {
+				return err
+			}
+		}
+		if item.config.Before != nil {
+			if err := item.config.Before(item.ctx, tx, args...); err != nil {
+				return err
+			}
+		}
+	}
+
+	// From the update on, the objects are restored if the batch fails.
+	restores := make([]func(), 0, len(items))
+	for _, item := range items {
+		restores = append(restores, item.r.saveState(item.sm.stater))
+	}
+	defer func() {
+		if err != nil {
+			for _, restore := range restores {
+				restore()
+			}
+		}
+	}()
+
+	// Objects are updated together by column, source and dest, provided they
+	// are all still in the source, see updateState. Nothing is stored without
+	// persistence.
+	persist := persisting(ctx)
+	groups := map[[3]string][]uint{}
+	values := map[[3]string][2]interface{}{}
+	var order [][3]string
+	_, keyErr := objectId(objects[0])
+	_, gormStore := currentStore().(GormStore)
+	perObject := versionFieldOf(objects[0]) != nil || keyErr != nil || !gormStore
+	for _, item := range items {
+		if item.config.Internal || !persist {
+			continue
+		}
+		if perObject {
+			// Each object needs its own version in the WHERE clause, the keys
+			// that are not unsigned cannot be grouped, and other stores only
+			// update objects one by one.
+			if err := updateState(tx, item.sm.stater, item.r, item.event.Source, item.event.Dest); err != nil {
+				return err
+			}
+			continue
+		}
+		id, err := objectId(item.sm.stater)
+		if err != nil {
+			return err
+		}
+		key := [3]string{item.r.column(item.sm.stater), item.event.Source, item.event.Dest}
+		if _, ok := groups[key]; !ok {
+			from, err := item.r.value(item.sm.stater, item.event.Source)
+			if err != nil {
+				return err
+			}
+			to, err := item.r.value(item.sm.stater, item.event.Dest)
+			if err != nil {
+				return err
+			}
+			order = append(order, key)
+			values[key] = [2]interface{}{from, to}
+		}
+		groups[key] = append(groups[key], id)
+	}
+	if len(order) > 0 {
+		stmt := &gorm.Statement{DB: tx}
+		if err := stmt.Parse(objects[0]); err != nil {
+			return err
+		}
+		for _, key := range order {
+			column := stmt.Schema.LookUpField(key[0]).DBName
+			from, to := values[key][0], values[key][1]
+			result := tx.Model(reflect.New(modelType.Elem()).Interface()).Omit(clause.Associations).
+				Where(groups[key]).Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: column}, Value: from}).
+				Update(column, to)
+			if result.Error != nil {
+				return fmt.Errorf("update state of %s: %w", StructName(objects[0]), result.Error)
+			}
+			if result.RowsAffected != int64(len(groups[key])) && !reflect.DeepEqual(from, to) {
+				return fmt.Errorf("%w: %d of %d %s left %s since they were read", ErrConcurrentModification,
+					int64(len(groups[key]))-result.RowsAffected, len(groups[key]), StructName(objects[0]), key[1])
+			}
+		}
+	}
+
+	entries := make([]*StateMachineLog, 0, len(items)+len(ignored))
+	entries = append(entries, ignored...)
+	for _, item := range items {
+		if !item.config.Internal {
+			if err := item.r.setState(item.sm.stater, item.event.Dest); err != nil {
+				return err
+			}
+			if err := runCallbacks(item.ctx, tx, item.r.definition.onEnter[item.event.Dest], args...); err != nil {
+				return err
+			}
+		}
+		if item.config.After != nil {
+			if err := item.config.After(item.ctx, tx, args...); err != nil {
+				return err
+			}
+		}
+		id, key, err := objectKeys(item.sm.stater)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, &StateMachineLog{
+			ObjectId:     id,
+			ObjectKey:    key,
+			ObjectStruct: StructName(item.sm.stater),
+			Region:       item.r.name,
+			Trigger:      item.event.Trigger,
+			Source:       item.event.Source,
+			Dest:         item.event.Dest,
+			OperatorId:   userInfoId,
+			Branch:       item.branch,
+			Reason:       ReasonFromContext(ctx),
+			Metadata:     LogMetadataFromContext(ctx),
+			Args:         serializedArgs,
+			TraceId:      traceIdOf(ctx),
+			TenantId:     TenantFromContext(ctx),
+		})
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	if persist {
+		for _, entry := range entries {
+			completeOperator(ctx, entry)
+		}
+		if err := chainEntries(tx, entries); err != nil {
+			return err
+		}
+		if err := currentAuditSink().Write(ctx, tx, entries); err != nil {
+			return fmt.Errorf("log transitions of %s: %w", StructName(objects[0]), err)
+		}
+	}
+
+	for i, item := range items {
+		item.event.LogId = entries[len(ignored)+i].ID
+		if err := runHooks(item.ctx, tx, &afterHooks, item.event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package common
+
+import (
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+var batchDefinition = NewDefinition("Invoice").
+	State("INITIALIZED", "PAID", "SHIPPED").
+	Trigger("pay").From("INITIALIZED").To("PAID").IgnoreIfIn("PAID").
+	Trigger("ship").From("INITIALIZED", "PAID").To("SHIPPED").
+	MustBuild()
+
+type batchInvoice struct {
+	ID uint
+	StateMachine
+}
+
+func (*batchInvoice) Define() *Definition {
+	return batchDefinition
+}
+
+func TestBatchDo(t *testing.T) {
+	tests := []struct {
+		name    string
+		states  []string
+		behind  map[int]string
+		trigger string
+		err     error
+		want    []string
+		stored  []string
+		logs    int
+		ignored int
+	}{
+		{
+			name:    "updates every object",
+			states:  []string{"INITIALIZED", "INITIALIZED", "INITIALIZED"},
+			trigger: "pay",
+			want:    []string{"PAID", "PAID", "PAID"},
+			logs:    3,
+		},
+		{
+			name:    "groups the objects by source",
+			states:  []string{"INITIALIZED", "PAID", "INITIALIZED"},
+			trigger: "ship",
+			want:    []string{"SHIPPED", "SHIPPED", "SHIPPED"},
+			logs:    3,
+		},
+		{
+			name:    "logs the objects ignoring the trigger",
+			states:  []string{"INITIALIZED", "PAID"},
+			trigger: "pay",
+			want:    []string{"PAID", "PAID"},
+			logs:    2,
+			ignored: 1,
+		},
+		{
+			name:    "changes nothing if an object refuses the trigger",
+			states:  []string{"INITIALIZED", "SHIPPED"},
+			trigger: "pay",
+			err:     ErrInvalidSourceState,
+			want:    []string{"INITIALIZED", "SHIPPED"},
+		},
+		{
+			name:    "changes nothing if an object changed since it was read",
+			states:  []string{"INITIALIZED", "INITIALIZED"},
+			behind:  map[int]string{1: "PAID"},
+			trigger: "pay",
+			err:     ErrConcurrentModification,
+			want:    []string{"INITIALIZED", "INITIALIZED"},
+			stored:  []string{"INITIALIZED", "PAID"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t, &batchInvoice{})
+			invoices := make([]*batchInvoice, len(tt.states))
+			objects := make([]Stater, len(tt.states))
+			for i, state := range tt.states {
+				invoices[i] = &batchInvoice{}
+				invoices[i].SetState(state)
+				if err := db.Create(invoices[i]).Error; err != nil {
+					t.Fatal(err)
+				}
+				objects[i] = invoices[i]
+			}
+			for i, state := range tt.behind {
+				if err := db.Model(&batchInvoice{}).Where("id = ?", invoices[i].ID).Update("state", state).Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := db.Transaction(func(tx *gorm.DB) error {
+				return BatchDo(tx, objects, tt.trigger, 1)
+			})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("BatchDo(%s): %v, want %v", tt.trigger, err, tt.err)
+			}
+			stored := tt.stored
+			if stored == nil {
+				stored = tt.want
+			}
+			for i, invoice := range invoices {
+				var got batchInvoice
+				if err := db.First(&got, invoice.ID).Error; err != nil {
+					t.Fatal(err)
+				}
+				if invoice.GetState() != tt.want[i] || got.State != stored[i] {
+					t.Errorf("invoice %d is %s, stored %s, want %s, stored %s", invoice.ID, invoice.GetState(), got.State, tt.want[i], stored[i])
+				}
+			}
+			var logs []StateMachineLog
+			if err := db.Find(&logs).Error; err != nil {
+				t.Fatal(err)
+			}
+			ignored := 0
+			for _, log := range logs {
+				if log.Source == log.Dest {
+					ignored++
+				}
+			}
+			if len(logs) != tt.logs || ignored != tt.ignored {
+				t.Errorf("%d logs, %d of ignored triggers, want %d and %d", len(logs), ignored, tt.logs, tt.ignored)
+			}
+		})
+	}
+}
@@ -0,0 +1,441 @@
+package common
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/text/message"
+)
+
+// DefinitionBuilder builds a Definition fluently:
+//
+//	NewDefinition("Order").
+//		State("INITIALIZED", "PAID").
+//		Trigger("pay").From("INITIALIZED").To("PAID").Before(checkBalance).
+//		MustBuild()
+//
+// From, To, Before, After and Condition apply to the most recent Trigger.
+type DefinitionBuilder struct {
+	definition *Definition
+	trigger    *TriggerConfig
+	errs       []string
+}
+
+func NewDefinition(name string) *DefinitionBuilder {
+	return &DefinitionBuilder{
+		definition: &Definition{
+			name:     name,
+			triggers: map[string]*TriggerConfig{},
+		},
+	}
+}
+
+func (b *DefinitionBuilder) errorf(format string, args ...interface{}) *DefinitionBuilder {
+	b.errs = append(b.errs, fmt.Sprintf(format, args...))
+	return b
+}
+
+func (b *DefinitionBuilder) current(method string) (*TriggerConfig, bool) {
+	if b.trigger == nil {
+		b.errorf("%s called before Trigger", method)
+		return nil, false
+	}
+	return b.trigger, true
+}
+
+func (b *DefinitionBuilder) State(states ...string) *DefinitionBuilder {
+	for _, state := range states {
+		if b.definition.HasState(state) {
+			b.errorf("state %s declared twice", state)
+			continue
+		}
+		b.definition.states = append(b.definition.states, state)
+	}
+	return b
+}
+
+// Initial declares the state given to new models, DefaultInitialState
+// otherwise.
+func (b *DefinitionBuilder) Initial(state string) *DefinitionBuilder {
+	b.definition.initial = state
+	return b
+}
+
+// SubStates nests children in parent: a trigger whose source is parent may
+// fire from any of them.
+func (b *DefinitionBuilder) SubStates(parent string, children ...string) *DefinitionBuilder {
+	if b.definition.parents == nil {
+		b.definition.parents = map[string]string{}
+	}
+	for _, child := range children {
+		if p, ok := b.definition.parents[child]; ok {
+			b.errorf("state %s already belongs to %s", child, p)
+			continue
+		}
+		b.definition.parents[child] = parent
+	}
+	return b
+}
+
+// Final marks states from which no trigger may fire.
+func (b *DefinitionBuilder) Final(states ...string) *DefinitionBuilder {
+	b.definition.final = append(b.definition.final, states...)
+	return b
+}
+
+// Tag attaches tags to state, e.g. "billable", see Definition.StatesWithTag.
+func (b *DefinitionBuilder) Tag(state string, tags ...string) *DefinitionBuilder {
+	if b.definition.tags == nil {
+		b.definition.tags = map[string][]string{}
+	}
+	b.definition.tags[state] = append(b.definition.tags[state], tags...)
+	return b
+}
+
+// Group names a group of states, see StateMachine.InGroup and
+// ScopeStateGroup. A group holds the sub-states of its members.
+func (b *DefinitionBuilder) Group(name string, states ...string) *DefinitionBuilder {
+	if b.definition.groups == nil {
+		b.definition.groups = map[string][]string{}
+	}
+	b.definition.groups[name] = append(b.definition.groups[name], states...)
+	return b
+}
+
+// OnEnter registers fn to run whenever a transition enters state, after the
+// state is stored and before the trigger's After callback.
+func (b *DefinitionBuilder) OnEnter(state string, fn CallbackFunc) *DefinitionBuilder {
+	if b.definition.onEnter == nil {
+		b.definition.onEnter = map[string][]CallbackFunc{}
+	}
+	b.definition.onEnter[state] = append(b.definition.onEnter[state], fn)
+	return b
+}
+
+// OnExit registers fn to run whenever a transition leaves state, before the
+// trigger's Before callback.
+func (b *DefinitionBuilder) OnExit(state string, fn CallbackFunc) *DefinitionBuilder {
+	if b.definition.onExit == nil {
+		b.definition.onExit = map[string][]CallbackFunc{}
+	}
+	b.definition.onExit[state] = append(b.definition.onExit[state], fn)
+	return b
+}
+
+// Region adds a parallel region: an independent machine, usually built with
+// its own NewDefinition, whose state is stored in the string field of the
+// model named field. Trigger names must be unique across regions.
+func (b *DefinitionBuilder) Region(name, field string, definition *Definition) *DefinitionBuilder {
+	switch {
+	case name == "" || field == "":
+		return b.errorf("region needs a name and a field")
+	case definition == nil:
+		return b.errorf("region %s has no definition", name)
+	case len(definition.regions) > 0:
+		return b.errorf("region %s has regions itself", name)
+	}
+	if _, ok := b.definition.region(name); ok {
+		return b.errorf("region %s declared twice", name)
+	}
+	b.definition.regions = append(b.definition.regions, &region{name: name, field: field, definition: definition})
+	return b
+}
+
+// Use registers middlewares applied to the transitions of this definition
+// only, see Middleware.
+func (b *DefinitionBuilder) Use(mw ...Middleware) *DefinitionBuilder {
+	b.definition.middlewares = append(b.definition.middlewares, mw...)
+	return b
+}
+
+// Printer sets the printer translating the states and triggers of this
+// definition, over the one set by SetPrinter.
+func (b *DefinitionBuilder) Printer(p *message.Printer) *DefinitionBuilder {
+	b.definition.printer = p
+	return b
+}
+
+func (b *DefinitionBuilder) Trigger(name string) *DefinitionBuilder {
+	if _, ok := b.definition.triggers[name]; ok {
+		b.trigger = nil
+		return b.errorf("trigger %s declared twice", name)
+	}
+	b.trigger = &TriggerConfig{}
+	b.definition.triggers[name] = b.trigger
+	b.definition.order = append(b.definition.order, name)
+	return b
+}
+
+func (b *DefinitionBuilder) From(states ...string) *DefinitionBuilder {
+	if tc, ok := b.current("From"); ok {
+		tc.Source = append(tc.Source, states...)
+	}
+	return b
+}
+
+// ToFunc resolves the destination at runtime, see TriggerConfig.DestFunc.
+func (b *DefinitionBuilder) ToFunc(fn DestFunc) *DefinitionBuilder {
+	if tc, ok := b.current("ToFunc"); ok {
+		tc.DestFunc = fn
+	}
+	return b
+}
+
+// Branch adds a conditional destination, see TriggerConfig.Branches. To sets
+// the default.
+func (b *DefinitionBuilder) Branch(name, dest string, guard ConditionFunc) *DefinitionBuilder {
+	if tc, ok := b.current("Branch"); ok {
+		tc.Branches = append(tc.Branches, Branch{Name: name, Guard: guard, Dest: dest})
+	}
+	return b
+}
+
+// Except excludes states from an AnyState source.
+func (b *DefinitionBuilder) Except(states ...string) *DefinitionBuilder {
+	if tc, ok := b.current("Except"); ok {
+		tc.Except = append(tc.Except, states...)
+	}
+	return b
+}
+
+func (b *DefinitionBuilder) To(state string) *DefinitionBuilder {
+	if tc, ok := b.current("To"); ok {
+		tc.Dest = state
+	}
+	return b
+}
+
+// Internal marks the trigger as an internal transition, see TriggerConfig.Internal.
+func (b *DefinitionBuilder) Internal() *DefinitionBuilder {
+	if tc, ok := b.current("Internal"); ok {
+		tc.Internal = true
+	}
+	return b
+}
+
+// Reentrant allows the trigger to re-enter its current state, see TriggerConfig.Reentrant.
+func (b *DefinitionBuilder) Reentrant() *DefinitionBuilder {
+	if tc, ok := b.current("Reentrant"); ok {
+		tc.Reentrant = true
+	}
+	return b
+}
+
+// Deferrable defers the trigger until it can fire, see TriggerConfig.Deferrable.
+func (b *DefinitionBuilder) Deferrable() *DefinitionBuilder {
+	if tc, ok := b.current("Deferrable"); ok {
+		tc.Deferrable = true
+	}
+	return b
+}
+
+// Priority sets the priority of the trigger, see TriggerConfig.Priority.
+func (b *DefinitionBuilder) Priority(priority int) *DefinitionBuilder {
+	if tc, ok := b.current("Priority"); ok {
+		tc.Priority = priority
+	}
+	return b
+}
+
+// IgnoreIfIn makes the current trigger a logged no-op in states.
+func (b *DefinitionBuilder) IgnoreIfIn(states ...string) *DefinitionBuilder {
+	if tc, ok := b.current("IgnoreIfIn"); ok {
+		tc.IgnoreIfIn = append(tc.IgnoreIfIn, states...)
+	}
+	return b
+}
+
+// Alias lets the current trigger also be fired under its former names.
+func (b *DefinitionBuilder) Alias(aliases ...string) *DefinitionBuilder {
+	if tc, ok := b.current("Alias"); ok {
+		tc.Aliases = append(tc.Aliases, aliases...)
+	}
+	return b
+}
+
+func (b *DefinitionBuilder) Metadata(metadata TriggerMetadata) *DefinitionBuilder {
+	if tc, ok := b.current("Metadata"); ok {
+		tc.Metadata = metadata
+	}
+	return b
+}
+
+func (b *DefinitionBuilder) Before(fn CallbackFunc) *DefinitionBuilder {
+	if tc, ok := b.current("Before"); ok {
+		tc.Before = fn
+	}
+	return b
+}
+
+func (b *DefinitionBuilder) After(fn CallbackFunc) *DefinitionBuilder {
+	if tc, ok := b.current("After"); ok {
+		tc.After = fn
+	}
+	return b
+}
+
+func (b *DefinitionBuilder) Condition(fn ConditionFunc) *DefinitionBuilder {
+	if tc, ok := b.current("Condition"); ok {
+		tc.Condition = fn
+	}
+	return b
+}
+
+func (b *DefinitionBuilder) Guard(fn GuardFunc) *DefinitionBuilder {
+	if tc, ok := b.current("Guard"); ok {
+		tc.Guard = fn
+	}
+	return b
+}
+
+func (b *DefinitionBuilder) Build() (*Definition, error) {
+	errs := append([]string(nil), b.errs...)
+	for _, trigger := range b.definition.order {
+		tc := b.definition.triggers[trigger]
+		if len(tc.Source) == 0 {
+			errs = append(errs, fmt.Sprintf("trigger %s has no source", trigger))
+		}
+		for _, src := range tc.Source {
+			if src != AnyState && !b.definition.HasState(src) {
+				errs = append(errs, fmt.Sprintf("trigger %s: unknown source state %s", trigger, src))
+			}
+			if b.definition.IsFinal(src) {
+				errs = append(errs, fmt.Sprintf("trigger %s: source %s is final", trigger, src))
+			}
+		}
+		for _, except := range tc.Except {
+			if !b.definition.HasState(except) {
+				errs = append(errs, fmt.Sprintf("trigger %s: unknown excepted state %s", trigger, except))
+			}
+		}
+		for _, ignored := range tc.IgnoreIfIn {
+			if !b.definition.HasState(ignored) {
+				errs = append(errs, fmt.Sprintf("trigger %s: unknown ignored state %s", trigger, ignored))
+			}
+		}
+		for _, branch := range tc.Branches {
+			if branch.Dest != HistoryState && !b.definition.HasState(branch.Dest) {
+				errs = append(errs, fmt.Sprintf("trigger %s: unknown branch dest state %s", trigger, branch.Dest))
+			}
+		}
+		if tc.Internal {
+			if tc.Dest != "" || tc.DestFunc != nil || len(tc.Branches) > 0 {
+				errs = append(errs, fmt.Sprintf("internal trigger %s has a dest", trigger))
+			}
+		} else if tc.DestFunc != nil {
+			if tc.Dest != "" || len(tc.Branches) > 0 {
+				errs = append(errs, fmt.Sprintf("trigger %s has both ToFunc and another dest", trigger))
+			}
+		} else if tc.Dest == "" {
+			if len(tc.Branches) == 0 {
+				errs = append(errs, fmt.Sprintf("trigger %s has no dest", trigger))
+			}
+		} else if tc.Dest != HistoryState && !b.definition.HasState(tc.Dest) {
+			errs = append(errs, fmt.Sprintf("trigger %s: unknown dest state %s", trigger, tc.Dest))
+		}
+	}
+	if b.definition.initial != "" && !b.definition.HasState(b.definition.initial) {
+		errs = append(errs, fmt.Sprintf("unknown initial state %s", b.definition.initial))
+	}
+	for _, state := range b.definition.final {
+		if !b.definition.HasState(state) {
+			errs = append(errs, fmt.Sprintf("unknown final state %s", state))
+		}
+	}
+	children := make([]string, 0, len(b.definition.parents))
+	for child := range b.definition.parents {
+		children = append(children, child)
+	}
+	sort.Strings(children)
+	for _, child := range children {
+		if !b.definition.HasState(child) {
+			errs = append(errs, fmt.Sprintf("unknown sub-state %s", child))
+		}
+		if parent := b.definition.parents[child]; !b.definition.HasState(parent) {
+			errs = append(errs, fmt.Sprintf("unknown parent state %s", parent))
+		}
+		seen := map[string]bool{child: true}
+		for parent, ok := b.definition.parents[child]; ok; parent, ok = b.definition.parents[parent] {
+			if seen[parent] {
+				errs = append(errs, fmt.Sprintf("state %s is nested in itself", child))
+				break
+			}
+			seen[parent] = true
+		}
+	}
+	var hookErrs []string
+	for _, hooks := range []map[string][]CallbackFunc{b.definition.onEnter, b.definition.onExit} {
+		for state := range hooks {
+			if !b.definition.HasState(state) {
+				hookErrs = append(hookErrs, fmt.Sprintf("hook on unknown state %s", state))
+			}
+		}
+	}
+	sort.Strings(hookErrs)
+	errs = append(errs, hookErrs...)
+	var stateErrs []string
+	for state := range b.definition.tags {
+		if !b.definition.HasState(state) {
+			stateErrs = append(stateErrs, fmt.Sprintf("tags on unknown state %s", state))
+		}
+	}
+	for group, states := range b.definition.groups {
+		for _, state := range states {
+			if !b.definition.HasState(state) {
+				stateErrs = append(stateErrs, fmt.Sprintf("group %s: unknown state %s", group, state))
+			}
+		}
+	}
+	sort.Strings(stateErrs)
+	errs = append(errs, stateErrs...)
+	for _, sla := range b.definition.slas {
+		if !b.definition.HasState(sla.State) {
+			errs = append(errs, fmt.Sprintf("SLA on unknown state %s", sla.State))
+		}
+		if sla.Within <= 0 {
+			errs = append(errs, fmt.Sprintf("SLA of %s has no duration", sla.State))
+		}
+		if sla.Escalate != "" {
+			if tc, ok := b.definition.triggers[sla.Escalate]; !ok || !tc.hasSource(b.definition.Ancestry(sla.State)...) {
+				errs = append(errs, fmt.Sprintf("SLA of %s: trigger %s cannot escalate from it", sla.State, sla.Escalate))
+			}
+		}
+	}
+	names := map[string]string{}
+	for _, r := range b.definition.allRegions() {
+		for _, trigger := range r.definition.order {
+			names[trigger] = trigger
+		}
+	}
+	for _, r := range b.definition.allRegions() {
+		for _, trigger := range r.definition.order {
+			for _, alias := range r.definition.triggers[trigger].Aliases {
+				if other, ok := names[alias]; ok {
+					errs = append(errs, fmt.Sprintf("alias %s of trigger %s is already a name of trigger %s", alias, trigger, other))
+					continue
+				}
+				names[alias] = trigger
+			}
+		}
+	}
+	for _, r := range b.definition.regions {
+		for _, trigger := range r.definition.order {
+			if owner, _ := b.definition.regionOf(trigger); owner != r {
+				errs = append(errs, fmt.Sprintf("trigger %s of region %s declared twice", trigger, r.name))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%w %s: %s", ErrInvalidDefinition, b.definition.name, strings.Join(errs, "; "))
+	}
+	return b.definition, nil
+}
+
+func (b *DefinitionBuilder) MustBuild() *Definition {
+	definition, err := b.Build()
+	if err != nil {
+		panic(err)
+	}
+	return definition
+}
@@ -0,0 +1,193 @@
+package common
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type subscription struct {
+	fn func(TransitionEvent)
+}
+
+var (
+	subscribersMu sync.RWMutex
+	subscribers   []*subscription
+	busHook       sync.Once
+)
+
+// Subscribe calls fn with every successful transition of every machine, once
+// Do, BatchDo, Revert or ForceState returned without error, e.g. to
+// invalidate caches or update projections. Transitions fired from callbacks
+// are published with the outermost one. fn is called synchronously, in the
+// order of subscription, and should hand slow work to a goroutine; events of
+// a transaction that is rolled back later are published all the same.
+//
+// The returned func removes the subscription.
+func Subscribe(fn func(TransitionEvent)) (unsubscribe func()) {
+	busHook.Do(func() {
+		OnAnyTransition(collectEvent)
+	})
+	s := &subscription{fn: fn}
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+	subscribers = append(subscribers, s)
+	return func() {
+		subscribersMu.Lock()
+		defer subscribersMu.Unlock()
+		for i, other := range subscribers {
+			if other == s {
+				subscribers = append(subscribers[:i:i], subscribers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+type publishKey struct{}
+
+type pendingEvents struct {
+	events []TransitionEvent
+}
+
+// publishing returns ctx collecting the events of the transitions run with
+// it, and the func publishing them unless the transitions failed. Within a
+// transition already collecting, events are left to the outermost one.
+func publishing(ctx context.Context) (context.Context, func(err error)) {
+	subscribersMu.RLock()
+	none := len(subscribers) == 0
+	subscribersMu.RUnlock()
+	if _, collecting := ctx.Value(publishKey{}).(*pendingEvents); none || collecting {
+		return ctx, func(error) {}
+	}
+	p := &pendingEvents{}
+	return context.WithValue(ctx, publishKey{}, p), func(err error) {
+		if err == nil {
+			publish(p.events)
+		}
+	}
+}
+
+func collectEvent(ctx context.Context, _ *gorm.DB, event *TransitionEvent) error {
+	if p, ok := ctx.Value(publishKey{}).(*pendingEvents); ok {
+		p.events = append(p.events, *event)
+	} else {
+		publish([]TransitionEvent{*event})
+	}
+	return nil
+}
+
+func publish(events []TransitionEvent) {
+	if len(events) == 0 {
+		return
+	}
+	subscribersMu.RLock()
+	registered := subscribers
+	subscribersMu.RUnlock()
+	for _, event := range events {
+		for _, s := range registered {
+			s.fn(event)
+		}
+	}
+}
+
+// Publisher sends transition events to a broker or another process.
+type Publisher interface {
+	Publish(ctx context.Context, event TransitionEvent) error
+}
+
+// PublishQueueSize is the number of events waiting to be published by the
+// worker of a PublishTo.
+const PublishQueueSize = 1024
+
+// PublishTo subscribes publisher to every successful transition, see
+// Subscribe. The events are published in order by a worker, so that Do does
+// not wait for the broker; those of transactions rolled back later are
+// published all the same, see PublishThroughOutbox for the committed ones
+// only. Events received while PublishQueueSize are waiting are dropped with
+// ErrPublishQueueFull. The errors are reported to onError, or logged if nil.
+//
+// The returned func removes the subscription and waits until the queued
+// events are published.
+func PublishTo(publisher Publisher, onError func(error)) (unsubscribe func()) {
+	report := func(event TransitionEvent, err error) {
+		if onError != nil {
+			onError(err)
+			return
+		}
+		currentLogger().Error("publish transition", "object", StructName(event.Object), "trigger", event.Trigger, "error", err)
+	}
+	var (
+		mu     sync.RWMutex
+		closed bool
+		events = make(chan TransitionEvent, PublishQueueSize)
+		done   = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		for event := range events {
+			if err := publisher.Publish(context.Background(), event); err != nil {
+				report(event, err)
+			}
+		}
+	}()
+	remove := Subscribe(func(event TransitionEvent) {
+		mu.RLock()
+		defer mu.RUnlock()
+		if closed {
+			return
+		}
+		select {
+		case events <- event:
+		default:
+			report(event, ErrPublishQueueFull)
+		}
+	})
+	return func() {
+		remove()
+		mu.Lock()
+		if !closed {
+			closed = true
+			close(events)
+		}
+		mu.Unlock()
+		<-done
+	}
+}
+
+// EventPayload is the serialized form of a TransitionEvent, as posted to
+// webhooks and published to brokers.
+type EventPayload struct {
+	ObjectId     uint      `json:"object_id"`
+	ObjectKey    string    `json:"object_key,omitempty"`
+	ObjectStruct string    `json:"object_struct"`
+	Region       string    `json:"region,omitempty"`
+	Trigger      string    `json:"trigger"`
+	Source       string    `json:"source"`
+	Dest         string    `json:"dest"`
+	OperatorId   uint      `json:"operator_id"`
+	LogId        uint      `json:"log_id"`
+	Timestamp    time.Time `json:"timestamp"`
+}
+
+// NewEventPayload returns the payload of event, timestamped now.
+func NewEventPayload(event TransitionEvent) (*EventPayload, error) {
+	id, key, err := objectKeys(event.Object)
+	if err != nil {
+		return nil, err
+	}
+	return &EventPayload{
+		ObjectId:     id,
+		ObjectKey:    key,
+		ObjectStruct: StructName(event.Object),
+		Region:       event.Region,
+		Trigger:      event.Trigger,
+		Source:       event.Source,
+		Dest:         event.Dest,
+		OperatorId:   event.OperatorId,
+		LogId:        event.LogId,
+		Timestamp:    time.Now().UTC(),
+	}, nil
+}
@@ -0,0 +1,65 @@
+package common
+
+import (
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+)
+
+// updateState stores dest, already set on stater, in the column of region r
+// with the Store, provided the row is still in source: of two concurrent
+// transitions of an object, the second fails with ErrConcurrentModification
+// instead of overwriting the first. The version of stater is also checked and
+// bumped, if it has one. Nothing is stored without persistence, see
+// WithNoPersist.
+func updateState(tx *gorm.DB, stater Stater, r *region, source, dest string) error {
+	if !persisting(contextOf(tx)) {
+		return nil
+	}
+	from, err := r.value(stater, source)
+	if err != nil {
+		return err
+	}
+	to, err := r.value(stater, dest)
+	if err != nil {
+		return err
+	}
+	update := StateUpdate{
+		Object: stater,
+		Region: r.name,
+		Field:  r.column(stater),
+		Source: source,
+		Dest:   dest,
+		From:   from,
+		To:     to,
+	}
+	f := versionFieldOf(stater)
+	if f != nil {
+		update.VersionField, update.Version = f.name, f.of(stater).Interface()
+	}
+	if err := currentStore().UpdateState(contextOf(tx), tx, update); err != nil {
+		return err
+	}
+	if f != nil {
+		f.increment(stater)
+	}
+	return nil
+}
+
+// conflictOf returns the error of a state UPDATE of stater finding no row.
+func conflictOf(tx *gorm.DB, stater Stater) error {
+	key, _ := primaryKey(stater)
+	f := fieldOf(stater, "DeletedAt")
+	if deletedAt, ok := valueOf(f).(gorm.DeletedAt); ok && deletedAt.Valid && !tx.Statement.Unscoped {
+		return fmt.Errorf("%w: %s %s", ErrObjectDeleted, StructName(stater), key)
+	}
+	return fmt.Errorf("%w: %s %s changed since it was read", ErrConcurrentModification, StructName(stater), key)
+}
+
+func valueOf(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	return v.Interface()
+}
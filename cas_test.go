@@ -0,0 +1,94 @@
+package common
+
+import (
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+type casOrder struct {
+	ID uint
+	StateMachine
+	Note string
+}
+
+func (*casOrder) Define() *Definition {
+	return keyedDefinition
+}
+
+func TestUpdateStateComparesSource(t *testing.T) {
+	tests := []struct {
+		name string
+		// behind changes the row of the order after it was read.
+		behind func(db *gorm.DB, o *casOrder) error
+		err    error
+		state  string
+		stored string
+	}{
+		{name: "state read", state: "PAID", stored: "PAID"},
+		{
+			name: "state changed since read",
+			behind: func(db *gorm.DB, o *casOrder) error {
+				return db.Model(&casOrder{}).Where("id = ?", o.ID).Update("state", "SHIPPED").Error
+			},
+			err:    ErrConcurrentModification,
+			state:  "INITIALIZED",
+			stored: "SHIPPED",
+		},
+		{
+			name: "other column changed since read",
+			behind: func(db *gorm.DB, o *casOrder) error {
+				return db.Model(&casOrder{}).Where("id = ?", o.ID).Update("note", "gift").Error
+			},
+			state:  "PAID",
+			stored: "PAID",
+		},
+		{
+			name: "row deleted since read",
+			behind: func(db *gorm.DB, o *casOrder) error {
+				return db.Delete(&casOrder{}, o.ID).Error
+			},
+			err:   ErrConcurrentModification,
+			state: "INITIALIZED",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t, &casOrder{})
+			o := &casOrder{}
+			if err := db.Create(o).Error; err != nil {
+				t.Fatal(err)
+			}
+			if tt.behind != nil {
+				if err := tt.behind(db, o); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := o.Do(db, "pay", 1); !errors.Is(err, tt.err) {
+				t.Fatalf("Do(pay): %v, want %v", err, tt.err)
+			}
+			if o.GetState() != tt.state {
+				t.Errorf("order is %s, want %s", o.GetState(), tt.state)
+			}
+			var stored casOrder
+			if err := db.Limit(1).Find(&stored).Error; err != nil {
+				t.Fatal(err)
+			}
+			if stored.State != tt.stored {
+				t.Errorf("stored order is %q, want %q", stored.State, tt.stored)
+			}
+			var logs int64
+			if err := db.Model(&StateMachineLog{}).Count(&logs).Error; err != nil {
+				t.Fatal(err)
+			}
+			want := int64(1)
+			if tt.err != nil {
+				want = 0
+			}
+			if logs != want {
+				t.Errorf("%d log entries, want %d", logs, want)
+			}
+		})
+	}
+}
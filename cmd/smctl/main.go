@@ -0,0 +1,218 @@
+// Command smctl checks and inspects definition files, JSON, YAML or .sm
+// transition tables, e.g. the sources of smgen, in CI pipelines:
+//
+//	smctl validate order.yaml
+//	smctl lint order.yaml
+//	smctl graph --format mermaid order.yaml
+//	smctl paths --from INITIALIZED --to SHIPPED order.yaml
+//
+// Callbacks, conditions and guards are only referenced by name, they are not
+// run.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	common "sm"
+)
+
+const usage = `usage: smctl <command> [flags] definition...
+
+commands:
+  validate  report errors of the definitions, failing on any
+  lint      report errors and warnings, failing on any
+  graph     print a definition as a diagram
+  paths     list the trigger sequences leading from a state to another
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	os.Exit(run(os.Args[1], os.Args[2:], os.Stdout, os.Stderr))
+}
+
+func run(command string, args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("smctl "+command, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	var format, from, to, region *string
+	var max *int
+	switch command {
+	case "validate", "lint":
+	case "graph":
+		format = flags.String("format", "dot", "dot, mermaid or plantuml")
+	case "paths":
+		from = flags.String("from", "", "source state, defaults to the initial state")
+		to = flags.String("to", "", "destination state, required")
+		region = flags.String("region", "", "parallel region of the states")
+		max = flags.Int("max", 20, "maximum number of paths listed")
+	case "help", "-h", "--help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	default:
+		fmt.Fprintf(stderr, "smctl: unknown command %q\n%s", command, usage)
+		return 2
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		fmt.Fprintf(stderr, "smctl %s: no definition given\n", command)
+		return 2
+	}
+
+	status := 0
+	for _, path := range flags.Args() {
+		definition, err := common.LoadDefinition(path)
+		if err != nil {
+			fmt.Fprintln(stderr, "smctl:", err)
+			status = 1
+			continue
+		}
+		switch command {
+		case "validate", "lint":
+			if !report(stdout, path, definition.Validate(), command == "lint") {
+				status = 1
+			}
+		case "graph":
+			diagram, err := graph(definition, *format)
+			if err != nil {
+				fmt.Fprintln(stderr, "smctl:", err)
+				return 2
+			}
+			fmt.Fprint(stdout, diagram)
+		case "paths":
+			if *to == "" {
+				fmt.Fprintln(stderr, "smctl paths: --to is required")
+				return 2
+			}
+			if err := listPaths(stdout, definition, *region, *from, *to, *max); err != nil {
+				fmt.Fprintf(stderr, "smctl: %s: %v\n", path, err)
+				status = 1
+			}
+		}
+	}
+	return status
+}
+
+// report prints the issues of a definition and reports whether it passes:
+// without errors, and without warnings when strict.
+func report(w io.Writer, path string, r *common.ValidationReport, strict bool) bool {
+	for _, issue := range r.Errors {
+		fmt.Fprintf(w, "%s: error: %s\n", path, issue)
+	}
+	if strict {
+		for _, issue := range r.Warnings {
+			fmt.Fprintf(w, "%s: warning: %s\n", path, issue)
+		}
+		return r.OK() && len(r.Warnings) == 0
+	}
+	return r.OK()
+}
+
+func graph(definition *common.Definition, format string) (string, error) {
+	switch strings.ToLower(format) {
+	case "dot":
+		return definition.ToDOT(), nil
+	case "mermaid":
+		return definition.ToMermaid(), nil
+	case "plantuml":
+		return definition.ToPlantUML(), nil
+	}
+	return "", fmt.Errorf("unknown graph format %q", format)
+}
+
+func listPaths(w io.Writer, definition *common.Definition, region, from, to string, max int) error {
+	d := definition
+	if region != "" {
+		var ok bool
+		if d, ok = definition.Region(region); !ok {
+			return fmt.Errorf("no region %s", region)
+		}
+	}
+	if from == "" {
+		from = d.InitialState()
+	}
+	for _, state := range []string{from, to} {
+		if len(d.States()) > 0 && !d.HasState(state) {
+			return fmt.Errorf("%w: %s", common.ErrUnknownState, state)
+		}
+	}
+
+	found := paths(definition.Transitions(), region, from, to, max)
+	if len(found) == 0 {
+		return fmt.Errorf("%s can not be reached from %s", to, from)
+	}
+	for _, path := range found {
+		steps := make([]string, len(path))
+		for i, t := range path {
+			steps[i] = t.Trigger
+			if t.Branch != "" {
+				steps[i] += "(" + t.Branch + ")"
+			}
+		}
+		fmt.Fprintf(w, "%s: %s\n", strings.Join(steps, " -> "), pathStates(path))
+	}
+	return nil
+}
+
+// paths returns up to max simple paths from one state to another, shortest
+// first, ignoring conditions and runtime destinations.
+func paths(transitions []common.TransitionInfo, region, from, to string, max int) [][]common.TransitionInfo {
+	outgoing := map[string][]common.TransitionInfo{}
+	for _, t := range transitions {
+		if t.Region == region && !t.Internal && t.Dest != "" && t.Dest != common.HistoryState {
+			outgoing[t.Source] = append(outgoing[t.Source], t)
+		}
+	}
+	type partial struct {
+		state string
+		path  []common.TransitionInfo
+	}
+	var found [][]common.TransitionInfo
+	queue := []partial{{state: from}}
+	for len(queue) > 0 && len(found) < max {
+		p := queue[0]
+		queue = queue[1:]
+		for _, t := range outgoing[p.state] {
+			if visited(p.path, from, t.Dest) {
+				continue
+			}
+			path := append(append([]common.TransitionInfo{}, p.path...), t)
+			if t.Dest == to {
+				found = append(found, path)
+				if len(found) == max {
+					break
+				}
+				continue
+			}
+			queue = append(queue, partial{state: t.Dest, path: path})
+		}
+	}
+	return found
+}
+
+func visited(path []common.TransitionInfo, from, state string) bool {
+	if state == from {
+		return true
+	}
+	for _, t := range path {
+		if t.Dest == state {
+			return true
+		}
+	}
+	return false
+}
+
+func pathStates(path []common.TransitionInfo) string {
+	states := []string{path[0].Source}
+	for _, t := range path {
+		states = append(states, t.Dest)
+	}
+	return strings.Join(states, " -> ")
+}
@@ -0,0 +1,326 @@
+// Command smgen generates the typed states and triggers of a model from its
+// definition file, JSON, YAML or .sm transition table:
+//
+//	//go:generate go run sm/cmd/smgen -type Order order.yaml
+//
+// writes order_sm.go, declaring the constants OrderStatePaid and
+// OrderTriggerPay, the States and TriggerConfigs methods of *Order and one
+// method per trigger, e.g. order.Pay(tx, operatorId).
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+
+	common "sm"
+)
+
+func main() {
+	typeName := flag.String("type", "", "name of the model type, required")
+	pkg := flag.String("package", os.Getenv("GOPACKAGE"), "package of the generated file")
+	out := flag.String("o", "", "output file, defaults to <input>_sm.go")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: smgen -type Name [-package pkg] [-o file] definition.(yaml|json|sm)")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *typeName == "" || *pkg == "" || flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	in := flag.Arg(0)
+	if *out == "" {
+		*out = strings.TrimSuffix(in, filepath.Ext(in)) + "_sm.go"
+	}
+
+	spec, err := common.LoadDefinitionSpec(in)
+	if err != nil {
+		fatal(err)
+	}
+	src, err := generate(spec, *typeName, *pkg, filepath.Base(in))
+	if err != nil {
+		fatal(fmt.Errorf("%s: %w", in, err))
+	}
+	if err := os.WriteFile(*out, src, 0o644); err != nil {
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "smgen:", err)
+	os.Exit(1)
+}
+
+func generate(spec *common.DefinitionSpec, typeName, pkg, source string) ([]byte, error) {
+	if len(spec.SubStates) > 0 || len(spec.OnEnter) > 0 || len(spec.OnExit) > 0 || len(spec.Regions) > 0 {
+		return nil, fmt.Errorf("substates, on_enter, on_exit and regions can not be declared by methods, bind the definition with RegisterDefinition instead")
+	}
+	definition, err := spec.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	g := &generator{typeName: typeName, recv: strings.ToLower(typeName[:1]), states: map[string]string{}}
+	states := definition.States()
+	if len(states) == 0 {
+		states = usedStates(definition)
+	}
+	for _, state := range states {
+		g.states[state] = typeName + "State" + camel(state)
+	}
+	if err := g.checkNames(spec); err != nil {
+		return nil, err
+	}
+
+	g.printf("// Code generated by smgen from %s; DO NOT EDIT.\n\n", source)
+	g.printf("package %s\n\n", pkg)
+	g.printf("import (\n\t\"gorm.io/gorm\"\n\n\tcommon \"sm\"\n)\n\n")
+
+	g.printf("const (\n")
+	for _, state := range states {
+		g.printf("%s = %q\n", g.states[state], state)
+	}
+	g.printf(")\n\n")
+	g.printf("const (\n")
+	for _, t := range spec.Triggers {
+		g.printf("%sTrigger%s = %q\n", typeName, camel(t.Name), t.Name)
+	}
+	g.printf(")\n\n")
+
+	if len(spec.States) > 0 {
+		g.method("States() []string", "return %s", g.stateList(spec.States))
+	}
+	if spec.Initial != "" {
+		g.method("InitialState() string", "return %s", g.state(spec.Initial))
+	}
+	if len(spec.Final) > 0 {
+		g.method("FinalStates() []string", "return %s", g.stateList(spec.Final))
+	}
+	if len(spec.Tags) > 0 {
+		g.method("StateTags() map[string][]string", "return %s", g.stateMap(spec.Tags, true))
+	}
+	if len(spec.Groups) > 0 {
+		g.method("StateGroups() map[string][]string", "return %s", g.stateMap(spec.Groups, false))
+	}
+
+	g.printf("func (%s *%s) TriggerConfigs() map[string]*common.TriggerConfig {\n", g.recv, typeName)
+	g.printf("return map[string]*common.TriggerConfig{\n")
+	for _, t := range spec.Triggers {
+		tc, _ := definition.TriggerConfig(t.Name)
+		g.printf("%sTrigger%s: {\n", typeName, camel(t.Name))
+		g.printf("Source: %s,\n", g.stateList(tc.Source))
+		if len(tc.Except) > 0 {
+			g.printf("Except: %s,\n", g.stateList(tc.Except))
+		}
+		if tc.Dest != "" {
+			g.printf("Dest: %s,\n", g.state(tc.Dest))
+		}
+		if len(t.Branches) > 0 {
+			g.printf("Branches: []common.Branch{\n")
+			for _, b := range t.Branches {
+				g.printf("{Name: %q, Guard: common.NamedCondition(%q), Dest: %s},\n", b.Name, b.Guard, g.state(b.Dest))
+			}
+			g.printf("},\n")
+		}
+		g.named("Before", "NamedCallback", t.Before)
+		g.named("After", "NamedCallback", t.After)
+		g.named("Condition", "NamedCondition", t.Condition)
+		g.named("Guard", "NamedGuard", t.Guard)
+		g.flag("Internal", tc.Internal)
+		g.flag("Reentrant", tc.Reentrant)
+		g.flag("Deferrable", tc.Deferrable)
+		if tc.Priority != 0 {
+			g.printf("Priority: %d,\n", tc.Priority)
+		}
+		if md := tc.Metadata; !reflect.DeepEqual(md, common.TriggerMetadata{}) {
+			g.printf("Metadata: common.TriggerMetadata{\n")
+			g.field("DisplayName", md.DisplayName)
+			g.field("Description", md.Description)
+			g.field("Icon", md.Icon)
+			g.field("ConfirmMessage", md.ConfirmMessage)
+			if len(md.Extra) > 0 {
+				g.printf("Extra: %#v,\n", md.Extra)
+			}
+			g.printf("},\n")
+		}
+		if len(tc.IgnoreIfIn) > 0 {
+			g.printf("IgnoreIfIn: %s,\n", g.stateList(tc.IgnoreIfIn))
+		}
+		if len(tc.Aliases) > 0 {
+			g.printf("Aliases: %#v,\n", tc.Aliases)
+		}
+		g.printf("},\n")
+	}
+	g.printf("}\n}\n\n")
+
+	for _, t := range spec.Triggers {
+		name := camel(t.Name)
+		g.printf("// %s fires the %s trigger.\n", name, t.Name)
+		g.printf("func (%s *%s) %s(tx *gorm.DB, userInfoId uint, args ...interface{}) error {\n", g.recv, typeName, name)
+		g.printf("return %s.Do(tx, %sTrigger%s, userInfoId, args...)\n}\n\n", g.recv, typeName, name)
+	}
+
+	src, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated code: %w", err)
+	}
+	return src, nil
+}
+
+// usedStates returns the states of a definition not declaring them, in order
+// of appearance.
+func usedStates(definition *common.Definition) []string {
+	states := []string{definition.InitialState()}
+	seen := map[string]bool{definition.InitialState(): true}
+	for _, t := range definition.Transitions() {
+		for _, state := range []string{t.Source, t.Dest} {
+			if state != "" && !seen[state] {
+				seen[state] = true
+				states = append(states, state)
+			}
+		}
+	}
+	return states
+}
+
+type generator struct {
+	buf      bytes.Buffer
+	typeName string
+	recv     string
+	states   map[string]string
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+func (g *generator) method(signature, format string, args ...interface{}) {
+	g.printf("func (%s *%s) %s {\n", g.recv, g.typeName, signature)
+	g.printf(format, args...)
+	g.printf("\n}\n\n")
+}
+
+func (g *generator) named(field, lookup, name string) {
+	if name != "" {
+		g.printf("%s: common.%s(%q),\n", field, lookup, name)
+	}
+}
+
+func (g *generator) field(field, value string) {
+	if value != "" {
+		g.printf("%s: %q,\n", field, value)
+	}
+}
+
+func (g *generator) flag(field string, set bool) {
+	if set {
+		g.printf("%s: true,\n", field)
+	}
+}
+
+// state returns the constant of state, or the expression of the states
+// without one.
+func (g *generator) state(state string) string {
+	switch state {
+	case common.AnyState:
+		return "common.AnyState"
+	case common.HistoryState:
+		return "common.HistoryState"
+	}
+	if constant, ok := g.states[state]; ok {
+		return constant
+	}
+	return fmt.Sprintf("%q", state)
+}
+
+func (g *generator) stateList(states []string) string {
+	constants := make([]string, len(states))
+	for i, state := range states {
+		constants[i] = g.state(state)
+	}
+	return "[]string{" + strings.Join(constants, ", ") + "}"
+}
+
+// stateMap prints m sorted by key; keys are states when byState, values
+// otherwise.
+func (g *generator) stateMap(m map[string]common.StateList, byState bool) string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString("map[string][]string{\n")
+	for _, key := range keys {
+		if byState {
+			fmt.Fprintf(&b, "%s: %#v,\n", g.state(key), []string(m[key]))
+		} else {
+			fmt.Fprintf(&b, "%q: %s,\n", key, g.stateList(m[key]))
+		}
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// checkNames rejects names that would not compile, and trigger methods that
+// would shadow the methods of the embedded StateMachine.
+func (g *generator) checkNames(spec *common.DefinitionSpec) error {
+	taken := map[string]string{}
+	for _, t := range []reflect.Type{reflect.TypeOf(&common.StateMachine{}), reflect.TypeOf(&common.TypedStateMachine[common.Stater]{})} {
+		for i := 0; i < t.NumMethod(); i++ {
+			taken[t.Method(i).Name] = "a method of StateMachine"
+		}
+	}
+	for _, name := range []string{"States", "InitialState", "FinalStates", "StateTags", "StateGroups", "TriggerConfigs"} {
+		taken[name] = "a generated method"
+	}
+	for state, constant := range g.states {
+		if camel(state) == "" {
+			return fmt.Errorf("state %q has no usable name", state)
+		}
+		if other, ok := taken[constant]; ok {
+			return fmt.Errorf("state %s: %s is already %s", state, constant, other)
+		}
+		taken[constant] = "the constant of state " + state
+	}
+	for _, t := range spec.Triggers {
+		name := camel(t.Name)
+		if name == "" {
+			return fmt.Errorf("trigger %q has no usable name", t.Name)
+		}
+		if other, ok := taken[name]; ok {
+			return fmt.Errorf("trigger %s: method %s is already %s", t.Name, name, other)
+		}
+		taken[name] = "the method of trigger " + t.Name
+	}
+	return nil
+}
+
+// camel turns a state or trigger name, e.g. IN_REVIEW or mark-paid, into an
+// exported Go identifier: InReview, MarkPaid.
+func camel(name string) string {
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var b strings.Builder
+	for _, word := range words {
+		if strings.ToUpper(word) == word {
+			word = strings.ToLower(word)
+		}
+		runes := []rune(word)
+		runes[0] = unicode.ToUpper(runes[0])
+		b.WriteString(string(runes))
+	}
+	if s := b.String(); s != "" && unicode.IsLetter([]rune(s)[0]) {
+		return s
+	}
+	return ""
+}
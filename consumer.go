@@ -0,0 +1,176 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TriggerMessage asks for a trigger of an object, as received from a message
+// queue. Its JSON form is the default body of the messages of a Consumer.
+type TriggerMessage struct {
+	// Id identifies the message for deduplication; messages without one are
+	// handled every time they are received.
+	Id           string          `json:"id,omitempty"`
+	ObjectStruct string          `json:"object_struct"`
+	ObjectId     uint            `json:"object_id"`
+	Trigger      string          `json:"trigger"`
+	OperatorId   uint            `json:"operator_id"`
+	Args         json.RawMessage `json:"args,omitempty"`
+}
+
+// StateMachineProcessedMessage records the Id of a TriggerMessage handled by
+// a Consumer, in the transaction of its trigger.
+type StateMachineProcessedMessage struct {
+	ID        uint   `gorm:"primarykey"`
+	MessageId string `gorm:"not null; uniqueIndex; size:255"`
+	CreatedAt time.Time
+}
+
+func AutoMigrateStateMachineProcessedMessage(tx *gorm.DB) {
+	if err := tx.AutoMigrate(&StateMachineProcessedMessage{}); err != nil {
+		panic(err)
+	}
+}
+
+// Consumer fires the triggers asked by the messages of a queue, for the
+// adapters of sm/kafka, sm/nats and sm/amqp or any other broker client. Each
+// message is handled in a transaction locking its object and recording its
+// Id, so that redelivered messages fire their trigger once.
+type Consumer struct {
+	db     *gorm.DB
+	mu     sync.RWMutex
+	models map[string]reflect.Type
+	// Decode reads a message body, as JSON TriggerMessage by default.
+	Decode func(data []byte) (*TriggerMessage, error)
+	// Args decodes the args of a message, which are ignored by default.
+	Args func(msg *TriggerMessage) ([]interface{}, error)
+}
+
+// NewConsumer returns a consumer of the messages for the objects of the types
+// of models, which may be zero values. Migrate StateMachineProcessedMessage
+// to deduplicate them.
+func NewConsumer(db *gorm.DB, models ...Stater) *Consumer {
+	c := &Consumer{
+		db:     db,
+		models: map[string]reflect.Type{},
+		Decode: func(data []byte) (*TriggerMessage, error) {
+			var msg TriggerMessage
+			if err := json.Unmarshal(data, &msg); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+			}
+			return &msg, nil
+		},
+	}
+	for _, model := range models {
+		c.Register(model)
+	}
+	return c
+}
+
+// Register handles the messages for the objects of the type of model, under
+// its struct name.
+func (c *Consumer) Register(model Stater) {
+	t := reflect.TypeOf(model)
+	if t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("%T is not a pointer to a model", model))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.models[StructName(model)] = t.Elem()
+}
+
+// HandleData decodes and handles a message body; id is the Id of messages
+// without one, e.g. the ID assigned by the broker.
+func (c *Consumer) HandleData(ctx context.Context, data []byte, id string) error {
+	msg, err := c.Decode(data)
+	if err != nil {
+		return err
+	}
+	if msg.Id == "" {
+		msg.Id = id
+	}
+	return c.Handle(ctx, msg)
+}
+
+// Handle fires the trigger of msg, unless a message with its Id was already
+// handled. A trigger ignored in the current state of its object, see
+// TransitionResult.AlreadyInState, is a success.
+func (c *Consumer) Handle(ctx context.Context, msg *TriggerMessage) error {
+	c.mu.RLock()
+	t, ok := c.models[msg.ObjectStruct]
+	c.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: unknown model %q", ErrInvalidMessage, msg.ObjectStruct)
+	}
+	var args []interface{}
+	if c.Args != nil {
+		var err error
+		if args, err = c.Args(msg); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+		}
+	}
+
+	return c.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if msg.Id != "" {
+			var processed int64
+			if err := tx.Model(&StateMachineProcessedMessage{}).Where("message_id = ?", msg.Id).Count(&processed).Error; err != nil {
+				return fmt.Errorf("read processed message %s: %w", msg.Id, err)
+			}
+			if processed > 0 {
+				return nil
+			}
+		}
+		obj, ok := reflect.New(t).Interface().(Stater)
+		if !ok {
+			return fmt.Errorf("%s is not a Stater", t.Name())
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(obj, msg.ObjectId).Error; err != nil {
+			return fmt.Errorf("load %s %d: %w", msg.ObjectStruct, msg.ObjectId, err)
+		}
+		sm, err := machineOf(obj)
+		if err != nil {
+			return err
+		}
+		if err := sm.DoCtx(ctx, tx, msg.Trigger, msg.OperatorId, args...); err != nil {
+			return err
+		}
+		if msg.Id == "" {
+			return nil
+		}
+		if err := tx.Create(&StateMachineProcessedMessage{MessageId: msg.Id}).Error; err != nil {
+			return fmt.Errorf("record processed message %s: %w", msg.Id, err)
+		}
+		return nil
+	})
+}
+
+// IsPermanent reports whether handling a message failed for good, e.g. for an
+// unknown object or a trigger its state refuses, so that it should be dropped
+// or dead-lettered rather than redelivered.
+func IsPermanent(err error) bool {
+	for _, permanent := range []error{
+		ErrInvalidMessage,
+		gorm.ErrRecordNotFound,
+		ErrTriggerNotFound,
+		ErrInvalidSourceState,
+		ErrGuardRejected,
+		ErrSelfTransition,
+		ErrFinalState,
+		ErrUnknownState,
+		ErrArgumentType,
+		ErrObjectDeleted,
+	} {
+		if errors.Is(err, permanent) {
+			return true
+		}
+	}
+	return false
+}
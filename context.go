@@ -0,0 +1,116 @@
+package common
+
+import (
+	"context"
+	"sync"
+)
+
+type objectKey struct{}
+
+type transitionKey struct{}
+
+type resultKey struct{}
+
+// running is the chain of objects whose transitions are in progress.
+type running struct {
+	stater Stater
+	outer  *running
+}
+
+func withObject(ctx context.Context, stater Stater) context.Context {
+	return context.WithValue(ctx, objectKey{}, stater)
+}
+
+// ObjectFromContext returns the object whose transition is running, from the
+// context handed to its callbacks.
+func ObjectFromContext(ctx context.Context) (Stater, bool) {
+	stater, ok := ctx.Value(objectKey{}).(Stater)
+	return stater, ok
+}
+
+func withResult(ctx context.Context, result *TransitionResult) context.Context {
+	return context.WithValue(ctx, resultKey{}, result)
+}
+
+// ResultFromContext returns the result of the transition running, from the
+// context handed to its middlewares. It is complete once the next
+// TransitionFunc returned.
+func ResultFromContext(ctx context.Context) (*TransitionResult, bool) {
+	result, ok := ctx.Value(resultKey{}).(*TransitionResult)
+	return result, ok
+}
+
+// withTransition marks the transition of stater as running in ctx.
+func withTransition(ctx context.Context, stater Stater) context.Context {
+	outer, _ := ctx.Value(transitionKey{}).(*running)
+	return context.WithValue(withObject(ctx, stater), transitionKey{}, &running{stater: stater, outer: outer})
+}
+
+// inTransition reports whether a transition of stater is running in ctx, that
+// is whether ctx, or the tx handed to a callback, belongs to it.
+func inTransition(ctx context.Context, stater Stater) bool {
+	r, _ := ctx.Value(transitionKey{}).(*running)
+	for ; r != nil; r = r.outer {
+		if r.stater == stater {
+			return true
+		}
+	}
+	return false
+}
+
+type reasonKey struct{}
+
+// WithReason returns ctx recording reason in the StateMachineLog of the
+// transitions run with it by DoCtx or BatchDo, e.g. why an order was
+// cancelled.
+func WithReason(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, reasonKey{}, reason)
+}
+
+// ReasonFromContext returns the reason set by WithReason.
+func ReasonFromContext(ctx context.Context) string {
+	reason, _ := ctx.Value(reasonKey{}).(string)
+	return reason
+}
+
+type noPersistKey struct{}
+
+// WithNoPersist returns ctx running the transitions fired with it in memory
+// only, e.g. of a transient object whose state the caller stores later: the
+// callbacks run and the state changes, but neither the state nor the log is
+// stored, and no lock is taken. Deferrable triggers fail as they would without
+// being deferrable. Do, FireCtx and BatchDo, given a nil tx, run so with
+// MemoryDB.
+func WithNoPersist(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noPersistKey{}, true)
+}
+
+// persisting reports whether the transitions of ctx are stored.
+func persisting(ctx context.Context) bool {
+	noPersist, _ := ctx.Value(noPersistKey{}).(bool)
+	return !noPersist
+}
+
+var (
+	traceIdMu        sync.RWMutex
+	traceIdExtractor func(ctx context.Context) string
+)
+
+// ExtractTraceId sets the func returning the correlation or trace ID of the
+// context of a transition, stored in the TraceId of its StateMachineLog; nil,
+// the default, stores none.
+func ExtractTraceId(extractor func(ctx context.Context) string) {
+	traceIdMu.Lock()
+	defer traceIdMu.Unlock()
+	traceIdExtractor = extractor
+}
+
+func traceIdOf(ctx context.Context) string {
+	traceIdMu.RLock()
+	extractor := traceIdExtractor
+	traceIdMu.RUnlock()
+	if extractor == nil {
+		return ""
+	}
+	return extractor(ctx)
+}
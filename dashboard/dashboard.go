@@ -0,0 +1,302 @@
+// Package smdashboard is an admin dashboard of state machine models, served
+// without any external asset: the objects of each model by state, its
+// transition diagram, and the log of each object, whose state privileged
+// users may force.
+//
+//	d := smdashboard.New(db, &Order{}, &Invoice{})
+//	d.CanForce = func(r *http.Request) bool { return isAdmin(r) }
+//	mux.Handle("/admin/sm/", http.StripPrefix("/admin/sm", d))
+//
+// The dashboard does not authenticate its users nor protect its forms against
+// cross-site requests; mount it behind the middlewares doing so.
+package smdashboard
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+
+	common "sm"
+	smhttp "sm/http"
+)
+
+// PageSize is the number of objects listed per page.
+const PageSize = 50
+
+// machine is the part of the StateMachine API the dashboard uses, promoted to
+// the models embedding it.
+type machine interface {
+	common.Stater
+	AvailableTriggersCtx(ctx context.Context) []*common.AvailableTrigger
+	ForceState(tx *gorm.DB, state string, userInfoId uint, reason string) error
+}
+
+type Dashboard struct {
+	db     *gorm.DB
+	names  []string
+	models map[string]reflect.Type
+	// CanForce reports whether the user of a request may force states; nobody
+	// may by default.
+	CanForce func(r *http.Request) bool
+	// Operator returns the user forcing a state, by default the one stored in
+	// the request context by smhttp.WithOperator.
+	Operator func(r *http.Request) (uint, error)
+}
+
+// New returns the dashboard of the types of models, which may be zero values.
+func New(db *gorm.DB, models ...common.Stater) *Dashboard {
+	d := &Dashboard{
+		db:       db,
+		models:   map[string]reflect.Type{},
+		CanForce: func(*http.Request) bool { return false },
+		Operator: func(r *http.Request) (uint, error) {
+			if operatorId, ok := smhttp.OperatorFromContext(r.Context()); ok {
+				return operatorId, nil
+			}
+			return 0, smhttp.ErrNoOperator
+		},
+	}
+	for _, model := range models {
+		t := reflect.TypeOf(model)
+		if t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+			panic(fmt.Sprintf("smdashboard: %T is not a pointer to a model", model))
+		}
+		if _, ok := model.(machine); !ok {
+			panic(fmt.Sprintf("smdashboard: %T does not embed StateMachine", model))
+		}
+		name := common.StructName(model)
+		d.names = append(d.names, name)
+		d.models[name] = t.Elem()
+	}
+	sort.Strings(d.names)
+	return d
+}
+
+func (d *Dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] == "" {
+		parts = nil
+	}
+	var model reflect.Type
+	if len(parts) > 0 {
+		var ok bool
+		if model, ok = d.models[parts[0]]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	var id uint64
+	if len(parts) > 1 {
+		var err error
+		if id, err = strconv.ParseUint(parts[1], 10, 64); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	switch {
+	case len(parts) == 0 && r.Method == http.MethodGet:
+		d.index(w, r)
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		d.list(w, r, model)
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		d.object(w, r, model, uint(id))
+	case len(parts) == 3 && parts[2] == "force" && r.Method == http.MethodPost:
+		d.force(w, r, model, uint(id))
+	case len(parts) <= 3:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+type stateCount struct {
+	State           string
+	TranslatedState string
+	Count           int64
+}
+
+type modelSummary struct {
+	Name   string
+	Total  int64
+	States []stateCount
+}
+
+func (d *Dashboard) summary(ctx context.Context, model reflect.Type) (*modelSummary, *common.Definition, error) {
+	zero := reflect.New(model).Interface().(machine)
+	definition, err := common.DefinitionOf(zero)
+	if err != nil {
+		return nil, nil, err
+	}
+	counts, err := common.CountByState(d.db.WithContext(ctx), zero)
+	if err != nil {
+		return nil, nil, err
+	}
+	s := &modelSummary{Name: common.StructName(zero)}
+	states := definition.States()
+	for state := range counts {
+		if !definition.HasState(state) {
+			states = append(states, state)
+		}
+	}
+	for _, state := range states {
+		s.States = append(s.States, stateCount{State: state, TranslatedState: definition.Translate(ctx, common.KindState, state), Count: counts[state]})
+		s.Total += counts[state]
+	}
+	return s, definition, nil
+}
+
+func (d *Dashboard) index(w http.ResponseWriter, r *http.Request) {
+	var summaries []*modelSummary
+	for _, name := range d.names {
+		s, _, err := d.summary(r.Context(), d.models[name])
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		summaries = append(summaries, s)
+	}
+	render(w, "index", map[string]interface{}{"Models": summaries})
+}
+
+type objectRow struct {
+	ID              uint
+	State           string
+	TranslatedState string
+}
+
+func (d *Dashboard) list(w http.ResponseWriter, r *http.Request, model reflect.Type) {
+	s, definition, err := d.summary(r.Context(), model)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	state := r.URL.Query().Get("state")
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+
+	objs := reflect.New(reflect.SliceOf(reflect.PtrTo(model)))
+	query := d.db.WithContext(r.Context()).Model(objs.Interface())
+	if state != "" {
+		query = query.Scopes(common.ScopeState(state))
+	}
+	if err := query.Order("id DESC").Limit(PageSize + 1).Offset((page - 1) * PageSize).Find(objs.Interface()).Error; err != nil {
+		serverError(w, err)
+		return
+	}
+	var rows []objectRow
+	for i := 0; i < objs.Elem().Len() && i < PageSize; i++ {
+		obj := objs.Elem().Index(i).Interface().(machine)
+		id, _ := common.ObjectIdOf(obj)
+		rows = append(rows, objectRow{ID: id, State: obj.GetState(), TranslatedState: definition.Translate(r.Context(), common.KindState, obj.GetState())})
+	}
+	render(w, "list", map[string]interface{}{
+		"Model":   s,
+		"Diagram": diagram(definition),
+		"State":   state,
+		"Objects": rows,
+		"Page":    page,
+		"Prev":    pageURL(state, page-1),
+		"Next":    pageURL(state, page+1),
+		"HasNext": objs.Elem().Len() > PageSize,
+	})
+}
+
+func pageURL(state string, page int) string {
+	q := url.Values{"page": {strconv.Itoa(page)}}
+	if state != "" {
+		q.Set("state", state)
+	}
+	return "?" + q.Encode()
+}
+
+func (d *Dashboard) load(ctx context.Context, model reflect.Type, id uint) (machine, error) {
+	obj := reflect.New(model).Interface().(machine)
+	if err := d.db.WithContext(ctx).First(obj, id).Error; err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (d *Dashboard) object(w http.ResponseWriter, r *http.Request, model reflect.Type, id uint) {
+	obj, err := d.load(r.Context(), model, id)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.NotFound(w, r)
+		} else {
+			serverError(w, err)
+		}
+		return
+	}
+	definition, err := common.DefinitionOf(obj)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	var history []common.StateMachineLog
+	if err := d.db.WithContext(r.Context()).Where(
+		"object_id = ? AND object_struct = ?", id, common.StructName(obj),
+	).Order("id DESC").Find(&history).Error; err != nil {
+		serverError(w, err)
+		return
+	}
+	render(w, "object", map[string]interface{}{
+		"Model":    common.StructName(obj),
+		"ID":       id,
+		"State":    obj.GetState(),
+		"Triggers": obj.AvailableTriggersCtx(r.Context()),
+		"History":  history,
+		"CanForce": d.CanForce(r),
+		"States":   definition.States(),
+		"Message":  r.URL.Query().Get("message"),
+		"Diagram":  diagram(definition),
+	})
+}
+
+func (d *Dashboard) force(w http.ResponseWriter, r *http.Request, model reflect.Type, id uint) {
+	if !d.CanForce(r) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	operatorId, err := d.Operator(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	obj, err := d.load(r.Context(), model, id)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.NotFound(w, r)
+		} else {
+			serverError(w, err)
+		}
+		return
+	}
+	state, reason := r.PostFormValue("state"), strings.TrimSpace(r.PostFormValue("reason"))
+	message := ""
+	if reason == "" {
+		message = "A reason is required to force a state."
+	} else if err := obj.ForceState(d.db.WithContext(r.Context()), state, operatorId, reason); err != nil {
+		message = err.Error()
+	}
+	// Back to the page of the object, relative to the URL of the form.
+	location := "../" + strconv.FormatUint(uint64(id), 10)
+	if message != "" {
+		location += "?" + url.Values{"message": {message}}.Encode()
+	}
+	w.Header().Set("Location", location)
+	w.WriteHeader(http.StatusSeeOther)
+}
+
+func serverError(w http.ResponseWriter, err error) {
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
@@ -0,0 +1,149 @@
+package smdashboard
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"sort"
+	"strings"
+
+	common "sm"
+)
+
+const (
+	nodeWidth  = 150
+	nodeHeight = 34
+	colGap     = 90
+	rowGap     = 40
+	margin     = 20
+)
+
+type edge struct {
+	source, dest string
+	triggers     []string
+}
+
+// diagram draws the main region of definition as inline SVG: states in
+// columns by their distance from the initial state, one arrow per pair of
+// states labeled with the triggers leading from one to the other.
+func diagram(definition *common.Definition) template.HTML {
+	states := definition.States()
+	if len(states) == 0 {
+		return ""
+	}
+	var edges []*edge
+	byPair := map[[2]string]*edge{}
+	internal := map[string][]string{}
+	for _, t := range definition.Transitions() {
+		if t.Region != "" {
+			continue
+		}
+		if t.Dest == "" {
+			internal[t.Source] = append(internal[t.Source], t.Trigger)
+			continue
+		}
+		e, ok := byPair[[2]string{t.Source, t.Dest}]
+		if !ok {
+			e = &edge{source: t.Source, dest: t.Dest}
+			byPair[[2]string{t.Source, t.Dest}] = e
+			edges = append(edges, e)
+		}
+		if len(e.triggers) == 0 || e.triggers[len(e.triggers)-1] != t.Trigger {
+			e.triggers = append(e.triggers, t.Trigger)
+		}
+	}
+
+	// Columns by breadth-first distance from the initial state; states it
+	// does not reach go last.
+	depth := map[string]int{}
+	queue := []string{definition.InitialState()}
+	depth[queue[0]] = 0
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		for _, e := range edges {
+			if _, seen := depth[e.dest]; e.source == state && !seen {
+				depth[e.dest] = depth[state] + 1
+				queue = append(queue, e.dest)
+			}
+		}
+	}
+	maxDepth := 0
+	for _, d := range depth {
+		if d > maxDepth {
+			maxDepth = d
+		}
+	}
+	var columns [][]string
+	for _, state := range states {
+		d, ok := depth[state]
+		if !ok {
+			d = maxDepth + 1
+		}
+		for len(columns) <= d {
+			columns = append(columns, nil)
+		}
+		columns[d] = append(columns[d], state)
+	}
+	pos := map[string][2]int{}
+	rows := 0
+	for col, column := range columns {
+		for row, state := range column {
+			pos[state] = [2]int{margin + col*(nodeWidth+colGap), margin + row*(nodeHeight+rowGap)}
+		}
+		if len(column) > rows {
+			rows = len(column)
+		}
+	}
+	width := 2*margin + len(columns)*(nodeWidth+colGap) - colGap
+	height := 2*margin + rows*(nodeHeight+rowGap) - rowGap + 40
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" class="diagram" width="%d" height="%d" viewBox="0 0 %d %d">`, width, height, width, height)
+	b.WriteString(`<defs><marker id="arrow" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="7" markerHeight="7" orient="auto-start-reverse"><path d="M0,0L10,5L0,10z"/></marker></defs>`)
+	sort.SliceStable(edges, func(i, j int) bool { return pos[edges[i].source][0] < pos[edges[j].source][0] })
+	for _, e := range edges {
+		from, to := pos[e.source], pos[e.dest]
+		label := html.EscapeString(strings.Join(e.triggers, ", "))
+		switch {
+		case e.source == e.dest:
+			x, y := from[0]+nodeWidth/2, from[1]+nodeHeight
+			fmt.Fprintf(&b, `<path d="M%d,%d C%d,%d %d,%d %d,%d" marker-end="url(#arrow)"/>`, x-15, y, x-30, y+30, x+30, y+30, x+15, y)
+			fmt.Fprintf(&b, `<text x="%d" y="%d">%s</text>`, x, y+34, label)
+		case to[0] > from[0]:
+			x1, y1 := from[0]+nodeWidth, from[1]+nodeHeight/2
+			x2, y2 := to[0], to[1]+nodeHeight/2
+			fmt.Fprintf(&b, `<path d="M%d,%d L%d,%d" marker-end="url(#arrow)"/>`, x1, y1, x2, y2)
+			fmt.Fprintf(&b, `<text x="%d" y="%d">%s</text>`, (x1+x2)/2, (y1+y2)/2-4, label)
+		default:
+			// Backward and same-column edges bend below the states.
+			x1, y1 := from[0]+nodeWidth/2, from[1]+nodeHeight
+			x2, y2 := to[0]+nodeWidth/2, to[1]+nodeHeight
+			below := y1
+			if y2 > below {
+				below = y2
+			}
+			below += rowGap
+			fmt.Fprintf(&b, `<path d="M%d,%d C%d,%d %d,%d %d,%d" marker-end="url(#arrow)"/>`, x1, y1, x1, below, x2, below, x2, y2)
+			fmt.Fprintf(&b, `<text x="%d" y="%d">%s</text>`, (x1+x2)/2, below-4, label)
+		}
+	}
+	for _, state := range states {
+		p := pos[state]
+		class := "state"
+		if state == definition.InitialState() {
+			class += " initial"
+		}
+		if definition.IsFinal(state) {
+			class += " final"
+		}
+		fmt.Fprintf(&b, `<g class="%s"><rect x="%d" y="%d" width="%d" height="%d" rx="8"/>`, class, p[0], p[1], nodeWidth, nodeHeight)
+		fmt.Fprintf(&b, `<text x="%d" y="%d">%s</text>`, p[0]+nodeWidth/2, p[1]+nodeHeight/2+4, html.EscapeString(state))
+		if triggers := internal[state]; len(triggers) > 0 {
+			fmt.Fprintf(&b, `<title>internal: %s</title>`, html.EscapeString(strings.Join(triggers, ", ")))
+		}
+		b.WriteString(`</g>`)
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
@@ -0,0 +1,101 @@
+package smdashboard
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+)
+
+var templates = template.Must(template.New("layout").Parse(`{{define "head"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.}} · state machines</title>
+<style>
+body { font: 14px/1.4 system-ui, sans-serif; margin: 2em; color: #222; }
+a { color: #0b5cad; text-decoration: none; }
+a:hover { text-decoration: underline; }
+table { border-collapse: collapse; margin: 1em 0; }
+th, td { border-bottom: 1px solid #ddd; padding: .3em .8em; text-align: left; }
+th { background: #f4f4f4; }
+.count { text-align: right; }
+.message { background: #fde8e8; border: 1px solid #e0a0a0; padding: .5em 1em; }
+.diagram { display: block; margin: 1em 0; overflow: visible; }
+.diagram path { fill: none; stroke: #666; }
+.diagram marker path { fill: #666; stroke: none; }
+.diagram text { font-size: 11px; text-anchor: middle; fill: #444; }
+.diagram .state rect { fill: #eef4fb; stroke: #0b5cad; }
+.diagram .state.initial rect { stroke-width: 2.5; }
+.diagram .state.final rect { fill: #eee; stroke: #555; stroke-dasharray: 4 2; }
+.diagram .state text { font-size: 12px; fill: #000; }
+form label { display: block; margin: .4em 0; }
+</style>
+</head>
+<body>
+{{end}}
+
+{{define "index"}}{{template "head" "Models"}}
+<h1>State machines</h1>
+{{range .Models}}
+<h2><a href="{{.Name}}">{{.Name}}</a> <small>{{.Total}}</small></h2>
+<table>
+<tr><th>State</th><th class="count">Objects</th></tr>
+{{$model := .Name}}{{range .States}}<tr><td><a href="{{$model}}?state={{.State}}">{{.TranslatedState}}</a> <small>{{.State}}</small></td><td class="count">{{.Count}}</td></tr>
+{{end}}</table>
+{{else}}<p>No model is registered.</p>
+{{end}}</body></html>{{end}}
+
+{{define "list"}}{{template "head" .Model.Name}}
+<p><a href="./">All models</a></p>
+<h1>{{.Model.Name}}</h1>
+{{.Diagram}}
+<table>
+<tr><th>State</th><th class="count">Objects</th></tr>
+<tr><td><a href="{{.Model.Name}}">all</a></td><td class="count">{{.Model.Total}}</td></tr>
+{{$model := .Model.Name}}{{range .Model.States}}<tr><td><a href="{{$model}}?state={{.State}}">{{.TranslatedState}}</a> <small>{{.State}}</small></td><td class="count">{{.Count}}</td></tr>
+{{end}}</table>
+<h2>{{if .State}}Objects in {{.State}}{{else}}Objects{{end}}</h2>
+<table>
+<tr><th>ID</th><th>State</th></tr>
+{{range .Objects}}<tr><td><a href="{{$model}}/{{.ID}}">{{.ID}}</a></td><td>{{.TranslatedState}} <small>{{.State}}</small></td></tr>
+{{else}}<tr><td colspan="2">None.</td></tr>
+{{end}}</table>
+<p>{{if gt .Page 1}}<a href="{{$model}}{{.Prev}}">Previous</a>{{end}} {{if .HasNext}}<a href="{{$model}}{{.Next}}">Next</a>{{end}}</p>
+</body></html>{{end}}
+
+{{define "object"}}{{template "head" .Model}}
+<p><a href="../{{.Model}}">{{.Model}}</a></p>
+<h1>{{.Model}} {{.ID}}: {{.State}}</h1>
+{{if .Message}}<p class="message">{{.Message}}</p>{{end}}
+<h2>Available triggers</h2>
+<ul>
+{{range .Triggers}}<li>{{.TranslatedTrigger}} <small>{{.Trigger}}</small></li>
+{{else}}<li>None.</li>
+{{end}}</ul>
+<h2>History</h2>
+<table>
+<tr><th>At</th><th>Region</th><th>Trigger</th><th>Source</th><th>Dest</th><th>Branch</th><th>Operator</th><th>Reason</th></tr>
+{{range .History}}<tr><td>{{.CreatedAt.Format "2006-01-02 15:04:05"}}</td><td>{{.Region}}</td><td>{{.Trigger}}</td><td>{{.Source}}</td><td>{{.Dest}}</td><td>{{.Branch}}</td><td>{{if .OperatorRef}}{{.OperatorRef}}{{else}}{{.OperatorId}}{{end}}</td><td>{{.Reason}}</td></tr>
+{{else}}<tr><td colspan="8">No transition.</td></tr>
+{{end}}</table>
+{{if .CanForce}}
+<h2>Force state</h2>
+<form method="post" action="{{.ID}}/force">
+<label>State <select name="state">{{$state := .State}}{{range .States}}<option{{if eq . $state}} selected{{end}}>{{.}}</option>{{end}}</select></label>
+<label>Reason <input name="reason" size="60" required></label>
+<button type="submit">Force</button>
+</form>
+{{end}}
+{{.Diagram}}
+</body></html>{{end}}
+`))
+
+func render(w http.ResponseWriter, name string, data interface{}) {
+	var b bytes.Buffer
+	if err := templates.ExecuteTemplate(&b, name, data); err != nil {
+		serverError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(b.Bytes())
+}
@@ -0,0 +1,107 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// StateMachinePendingTrigger is a deferred trigger waiting for its object to
+// reach one of its source states.
+type StateMachinePendingTrigger struct {
+	gorm.Model
+	ObjectId     uint   `gorm:"not null; index"`
+	ObjectStruct string `gorm:"not null; index; varchar(64)"`
+	Region       string `gorm:"varchar(64)"`
+	Trigger      string `gorm:"not null; varchar(64)"`
+	OperatorId   uint   `gorm:"not null"`
+}
+
+func AutoMigrateStateMachinePendingTrigger(tx *gorm.DB) {
+	if err := tx.AutoMigrate(&StateMachinePendingTrigger{}); err != nil {
+		panic(err)
+	}
+}
+
+func (d *Definition) hasDeferrable() bool {
+	for _, r := range d.allRegions() {
+		for _, config := range r.definition.triggers {
+			if config.Deferrable {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// deferTrigger stores trigger to be fired once the object can accept it.
+// Arguments can not be stored, so only triggers fired without any are deferred.
+func (sm *StateMachine) deferTrigger(tx *gorm.DB, r *region, trigger string, userInfoId uint, args []interface{}) error {
+	if len(args) > 0 {
+		return fmt.Errorf("trigger %s can not be deferred with arguments", trigger)
+	}
+	id, err := objectId(sm.stater)
+	if err != nil {
+		return err
+	}
+	if err := tx.Create(&StateMachinePendingTrigger{
+		ObjectId:     id,
+		ObjectStruct: StructName(sm.stater),
+		Region:       r.name,
+		Trigger:      trigger,
+		OperatorId:   userInfoId,
+	}).Error; err != nil {
+		return fmt.Errorf("defer trigger %s of %s: %w", trigger, StructName(sm.stater), err)
+	}
+	return nil
+}
+
+// firePending fires the oldest deferred trigger the object now accepts, once
+// the transition that made it acceptable succeeded, which in turn fires the
+// next one. A pending trigger is dropped in the transaction of its own
+// transition, and kept if it fails: its error is logged, not returned to the
+// transition that succeeded. Triggers refused by a final state, or ignored in
+// the state reached, are dropped without firing.
+func (sm *StateMachine) firePending(ctx context.Context, tx *gorm.DB, definition *Definition) {
+	if !definition.hasDeferrable() || !persisting(ctx) {
+		return
+	}
+	id, err := objectId(sm.stater)
+	if err != nil {
+		return
+	}
+	var pending []StateMachinePendingTrigger
+	if err := tx.WithContext(ctx).Where(
+		"object_id = ? AND object_struct = ?", id, StructName(sm.stater),
+	).Order("id").Find(&pending).Error; err != nil {
+		currentLogger().Error("read deferred triggers", "object", StructName(sm.stater), "id", id, "error", err)
+		return
+	}
+	for _, p := range pending {
+		r, ok := definition.regionOf(p.Trigger)
+		if !ok {
+			continue
+		}
+		if _, err := sm.check(ctx, tx, definition, r, p.Trigger); err != nil {
+			if errors.Is(err, ErrFinalState) || errors.Is(err, ErrAlreadyInState) {
+				if err := tx.WithContext(ctx).Delete(&p).Error; err != nil {
+					currentLogger().Error("drop deferred trigger", "object", StructName(sm.stater), "id", id, "trigger", p.Trigger, "error", err)
+				}
+			}
+			continue
+		}
+		err := tx.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Delete(&p).Error; err != nil {
+				return fmt.Errorf("drop deferred trigger %s of %s: %w", p.Trigger, StructName(sm.stater), err)
+			}
+			_, err := sm.fire(ctx, tx, definition, p.Trigger, p.OperatorId)
+			return err
+		})
+		if err == nil {
+			return
+		}
+		currentLogger().Error("fire deferred trigger", "object", StructName(sm.stater), "id", id, "trigger", p.Trigger, "error", err)
+	}
+}
@@ -0,0 +1,86 @@
+package common
+
+import (
+	"context"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+var carrierDown bool
+
+var deferredDefinition = NewDefinition("Crate").
+	State("INITIALIZED", "PAID", "SHIPPED", "CLOSED").
+	Final("CLOSED").
+	Trigger("pay").From("INITIALIZED").To("PAID").
+	Trigger("express").From("INITIALIZED").To("SHIPPED").
+	Trigger("close").From("INITIALIZED").To("CLOSED").
+	Trigger("ship").From("PAID").To("SHIPPED").Deferrable().IgnoreIfIn("SHIPPED").
+	Before(func(context.Context, *gorm.DB, ...interface{}) error {
+		if carrierDown {
+			return errCarrier
+		}
+		return nil
+	}).
+	MustBuild()
+
+type deferredCrate struct {
+	ID uint
+	StateMachine
+}
+
+func (*deferredCrate) Define() *Definition {
+	return deferredDefinition
+}
+
+func TestDeferredTriggers(t *testing.T) {
+	tests := []struct {
+		name    string
+		then    string
+		down    bool
+		state   string
+		pending int64
+	}{
+		{name: "fired once its source is reached", then: "pay", state: "SHIPPED"},
+		{name: "kept when it fails", then: "pay", down: true, state: "PAID", pending: 1},
+		{name: "dropped in a final state", then: "close", state: "CLOSED"},
+		{name: "dropped when ignored in the state reached", then: "express", state: "SHIPPED"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t, &deferredCrate{})
+			AutoMigrateStateMachinePendingTrigger(db)
+			carrierDown = tt.down
+			defer func() { carrierDown = false }()
+
+			c := &deferredCrate{}
+			if err := db.Create(c).Error; err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Do(db, "ship", 7); err != nil {
+				t.Fatalf("Do(ship) from INITIALIZED: %v, want it deferred", err)
+			}
+			if c.GetState() != "INITIALIZED" {
+				t.Fatalf("deferred ship moved the crate to %s", c.GetState())
+			}
+			if err := c.Do(db, tt.then, 1); err != nil {
+				t.Fatalf("Do(%s): %v", tt.then, err)
+			}
+
+			var stored deferredCrate
+			if err := db.First(&stored, c.ID).Error; err != nil {
+				t.Fatal(err)
+			}
+			if c.GetState() != tt.state || stored.State != tt.state {
+				t.Errorf("crate is %s, stored %s, want %s", c.GetState(), stored.State, tt.state)
+			}
+			var pending int64
+			if err := db.Model(&StateMachinePendingTrigger{}).Count(&pending).Error; err != nil {
+				t.Fatal(err)
+			}
+			if pending != tt.pending {
+				t.Errorf("%d pending triggers, want %d", pending, tt.pending)
+			}
+		})
+	}
+}
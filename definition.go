@@ -0,0 +1,468 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"golang.org/x/text/message"
+	"gorm.io/gorm"
+)
+
+// Definition is the compiled transition table of a state machine.
+type Definition struct {
+	name     string
+	states   []string
+	initial  string
+	final    []string
+	parents  map[string]string
+	triggers map[string]*TriggerConfig
+	order    []string
+	onEnter  map[string][]CallbackFunc
+	onExit   map[string][]CallbackFunc
+	tags     map[string][]string
+	groups   map[string][]string
+	slas     []SLA
+
+	middlewares []Middleware
+	regions     []*region
+	printer     *message.Printer
+	// funcTriggers are the triggers of a compiled definition declaring funcs,
+	// which bind takes from each instance.
+	funcTriggers []string
+}
+
+// Definer is implemented by staters whose machine is described by a Definition,
+// usually created with NewDefinition.
+type Definer interface {
+	Define() *Definition
+}
+
+// InitialStater is implemented by staters choosing the state of their new
+// rows, overriding the initial state of their Definition.
+type InitialStater interface {
+	InitialState() string
+}
+
+// FinalStater is implemented by staters declaring final states without a
+// Definition.
+type FinalStater interface {
+	FinalStates() []string
+}
+
+// StateLister is implemented by staters declaring their states directly.
+type StateLister interface {
+	States() []string
+}
+
+// StateGrouper is implemented by staters naming groups of states without a
+// Definition, e.g. {"OPEN": {"INITIALIZED", "PAID", "PACKING"}}.
+type StateGrouper interface {
+	StateGroups() map[string][]string
+}
+
+// StateTagger is implemented by staters tagging their states without a
+// Definition, e.g. {"PAID": {"billable", "customer_visible"}}.
+type StateTagger interface {
+	StateTags() map[string][]string
+}
+
+func (d *Definition) Name() string {
+	return d.name
+}
+
+func (d *Definition) States() []string {
+	return append([]string(nil), d.states...)
+}
+
+// InitialState is the state given to new models, DefaultInitialState unless
+// declared otherwise.
+func (d *Definition) InitialState() string {
+	if d.initial != "" {
+		return d.initial
+	}
+	return DefaultInitialState
+}
+
+// Parent returns the state containing state, if any.
+func (d *Definition) Parent(state string) (string, bool) {
+	parent, ok := d.parents[state]
+	return parent, ok
+}
+
+// Ancestry returns state followed by the states containing it, innermost
+// first.
+func (d *Definition) Ancestry(state string) []string {
+	ancestry := []string{state}
+	for parent, ok := d.parents[state]; ok; parent, ok = d.parents[parent] {
+		ancestry = append(ancestry, parent)
+	}
+	return ancestry
+}
+
+// Tags returns the tags of state, including those inherited from the states
+// containing it.
+func (d *Definition) Tags(state string) []string {
+	var tags []string
+	for _, s := range d.Ancestry(state) {
+		tags = append(tags, d.tags[s]...)
+	}
+	return tags
+}
+
+func (d *Definition) HasTag(state, tag string) bool {
+	for _, t := range d.Tags(state) {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// StatesWithTag returns the declared states having tag, in declaration order,
+// or sorted when the machine does not list its states.
+func (d *Definition) StatesWithTag(tag string) []string {
+	candidates := d.states
+	if len(candidates) == 0 {
+		for state := range d.tags {
+			candidates = append(candidates, state)
+		}
+		sort.Strings(candidates)
+	}
+	var states []string
+	for _, state := range candidates {
+		if d.HasTag(state, tag) {
+			states = append(states, state)
+		}
+	}
+	return states
+}
+
+// Groups returns the names of the state groups, sorted.
+func (d *Definition) Groups() []string {
+	names := make([]string, 0, len(d.groups))
+	for name := range d.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Group returns the states of group, with the sub-states of its members.
+func (d *Definition) Group(group string) ([]string, bool) {
+	members, ok := d.groups[group]
+	if !ok {
+		return nil, false
+	}
+	var states []string
+	seen := map[string]bool{}
+	add := func(state string) {
+		if !seen[state] {
+			seen[state] = true
+			states = append(states, state)
+		}
+	}
+	for _, member := range members {
+		add(member)
+	}
+	children := make([]string, 0, len(d.parents))
+	for child := range d.parents {
+		children = append(children, child)
+	}
+	sort.Strings(children)
+	for _, child := range children {
+		for _, s := range d.Ancestry(child)[1:] {
+			if seen[s] {
+				add(child)
+				break
+			}
+		}
+	}
+	return states, true
+}
+
+// InGroup reports whether state, or a state containing it, belongs to group.
+func (d *Definition) InGroup(state, group string) bool {
+	for _, s := range d.Ancestry(state) {
+		for _, member := range d.groups[group] {
+			if s == member {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (d *Definition) FinalStates() []string {
+	return append([]string(nil), d.final...)
+}
+
+// IsFinal reports whether no trigger may fire from state.
+func (d *Definition) IsFinal(state string) bool {
+	for _, s := range d.final {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+func initialStateOf(stater Stater, definition *Definition) string {
+	if initial, ok := stater.(InitialStater); ok {
+		if state := initial.InitialState(); state != "" {
+			return state
+		}
+	}
+	if definition != nil {
+		return definition.InitialState()
+	}
+	return DefaultInitialState
+}
+
+// Triggers returns the trigger names in declaration order.
+func (d *Definition) Triggers() []string {
+	return append([]string(nil), d.order...)
+}
+
+// prioritized returns the trigger names by decreasing priority.
+func (d *Definition) prioritized() []string {
+	triggers := d.Triggers()
+	sort.SliceStable(triggers, func(i, j int) bool {
+		return d.triggers[triggers[i]].Priority > d.triggers[triggers[j]].Priority
+	})
+	return triggers
+}
+
+// Canonical returns the name of the trigger known as trigger, which may be
+// one of its aliases, or trigger itself if there is none.
+func (d *Definition) Canonical(trigger string) string {
+	for _, r := range d.allRegions() {
+		if _, ok := r.definition.triggers[trigger]; ok {
+			return trigger
+		}
+	}
+	for _, r := range d.allRegions() {
+		for _, name := range r.definition.order {
+			for _, alias := range r.definition.triggers[name].Aliases {
+				if alias == trigger {
+					return name
+				}
+			}
+		}
+	}
+	return trigger
+}
+
+func (d *Definition) TriggerConfig(trigger string) (*TriggerConfig, bool) {
+	config, ok := d.triggers[trigger]
+	return config, ok
+}
+
+func (d *Definition) TriggerConfigs() map[string]*TriggerConfig {
+	configs := make(map[string]*TriggerConfig, len(d.triggers))
+	for trigger, config := range d.triggers {
+		configs[trigger] = config
+	}
+	return configs
+}
+
+func (d *Definition) HasState(state string) bool {
+	for _, s := range d.states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+func runCallbacks(ctx context.Context, tx *gorm.DB, callbacks []CallbackFunc, args ...interface{}) error {
+	for _, callback := range callbacks {
+		if err := callback(ctx, tx, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DefinitionOf returns the definition shared by the models of the type of
+// model, which may be a zero value.
+func DefinitionOf(model Stater) (*Definition, error) {
+	stater, ok := newStater(model)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a state machine model", model)
+	}
+	return definitionOf(stater)
+}
+
+// TransitionInfo is one edge of a definition, see Transitions.
+type TransitionInfo struct {
+	Region  string
+	Trigger string
+	Source  string
+	// Dest is empty for internal triggers and for triggers choosing it with a
+	// DestFunc.
+	Dest     string
+	Branch   string
+	Internal bool
+}
+
+// Transitions lists the edges of the machine, with any-state sources and
+// sub-states expanded, final states excluded. It is meant for introspection
+// and ignores conditions.
+func (d *Definition) Transitions() []TransitionInfo {
+	var transitions []TransitionInfo
+	for _, r := range d.allRegions() {
+		def := r.definition
+		states := def.diagramStates()
+		for _, trigger := range def.order {
+			tc := def.triggers[trigger]
+			for _, state := range states {
+				if def.IsFinal(state) || !tc.hasSource(def.Ancestry(state)...) || tc.isRejectedSelfTransition(state) {
+					continue
+				}
+				edge := TransitionInfo{Region: r.name, Trigger: trigger, Source: state, Internal: tc.Internal}
+				if tc.Internal || tc.DestFunc != nil {
+					transitions = append(transitions, edge)
+					continue
+				}
+				for i, branch := range tc.Branches {
+					edge.Dest, edge.Branch = branch.Dest, branch.name(i)
+					transitions = append(transitions, edge)
+				}
+				if tc.Dest != "" {
+					edge.Dest, edge.Branch = tc.Dest, ""
+					if len(tc.Branches) > 0 {
+						edge.Branch = DefaultBranch
+					}
+					transitions = append(transitions, edge)
+				}
+			}
+		}
+	}
+	return transitions
+}
+
+// compiled caches, per model type, the definitions compiled from
+// TriggerConfiger and MapTriggerer staters without their funcs, which may be
+// method values of the instance: see bind.
+var compiled sync.Map
+
+// definitionOf returns the definition of stater. The states and sources
+// declared by TriggerConfigs or Triggers are read once per model type, and
+// must not depend on the instance.
+func definitionOf(stater Stater) (*Definition, error) {
+	if definer, ok := stater.(Definer); ok {
+		if definition := definer.Define(); definition != nil {
+			return definition, nil
+		}
+		return nil, fmt.Errorf("%s returned a nil definition", StructName(stater))
+	}
+	if definition, ok := registeredDefinition(stater); ok {
+		return definition, nil
+	}
+
+	t := reflect.TypeOf(stater)
+	if cached, ok := compiled.Load(t); ok {
+		return cached.(*Definition).bind(stater)
+	}
+	definition, err := compileDefinition(stater)
+	if err != nil {
+		return nil, err
+	}
+	static := make(map[string]*TriggerConfig, len(definition.triggers))
+	for _, trigger := range definition.order {
+		tc := definition.triggers[trigger]
+		if tc.hasFuncs() {
+			definition.funcTriggers = append(definition.funcTriggers, trigger)
+		}
+		stripped := *tc
+		stripped.bindFuncs(&TriggerConfig{})
+		static[trigger] = &stripped
+	}
+	definition.triggers = static
+	cached, _ := compiled.LoadOrStore(t, definition)
+	return cached.(*Definition).bind(stater)
+}
+
+// bind returns the cached definition d with the funcs of the triggers of
+// stater. The funcs declared by TriggerConfigs or Triggers may be method
+// values of the instance, so those are read again from it: only the triggers
+// declaring funcs are copied, the rest of d is shared. A type declaring no
+// funcs is bound without calling them.
+func (d *Definition) bind(stater Stater) (*Definition, error) {
+	if len(d.funcTriggers) == 0 {
+		return d, nil
+	}
+	bound := *d
+	bound.triggers = make(map[string]*TriggerConfig, len(d.triggers))
+	for trigger, static := range d.triggers {
+		bound.triggers[trigger] = static
+	}
+	switch s := stater.(type) {
+	case TriggerConfiger:
+		configs := s.TriggerConfigs()
+		for _, trigger := range d.funcTriggers {
+			if declared := configs[trigger]; declared != nil {
+				tc := *d.triggers[trigger]
+				tc.bindFuncs(declared)
+				bound.triggers[trigger] = &tc
+			}
+		}
+	case MapTriggerer:
+		triggers := s.Triggers()
+		for _, trigger := range d.funcTriggers {
+			tc := *d.triggers[trigger]
+			for _, key := range mapFuncKeys {
+				if value := triggers[trigger][key]; value != nil {
+					if err := tc.setFromMap(key, value); err != nil {
+						return nil, fmt.Errorf("trigger %s: %w", trigger, err)
+					}
+				}
+			}
+			bound.triggers[trigger] = &tc
+		}
+	}
+	return &bound, nil
+}
+
+func compileDefinition(stater Stater) (*Definition, error) {
+	var configs map[string]*TriggerConfig
+	switch s := stater.(type) {
+	case TriggerConfiger:
+		configs = s.TriggerConfigs()
+	case MapTriggerer:
+		var err error
+		if configs, err = TriggerConfigsFromMap(s.Triggers()); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%s does not declare any triggers", StructName(stater))
+	}
+
+	definition := &Definition{
+		name:     StructName(stater),
+		triggers: configs,
+	}
+	if lister, ok := stater.(StateLister); ok {
+		definition.states = lister.States()
+	}
+	if final, ok := stater.(FinalStater); ok {
+		definition.final = final.FinalStates()
+	}
+	if tagger, ok := stater.(StateTagger); ok {
+		definition.tags = tagger.StateTags()
+	}
+	if grouper, ok := stater.(StateGrouper); ok {
+		definition.groups = grouper.StateGroups()
+	}
+	if declarer, ok := stater.(SLADeclarer); ok {
+		definition.slas = declarer.SLAs()
+	}
+	for trigger := range configs {
+		definition.order = append(definition.order, trigger)
+	}
+	sort.Strings(definition.order)
+	return definition, nil
+}
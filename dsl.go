@@ -0,0 +1,184 @@
+package common
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseDSL compiles a definition written as a transition table, one trigger
+// per line:
+//
+//	name Order
+//	states INITIALIZED, PAID, SHIPPED
+//	final SHIPPED
+//
+//	pay:    INITIALIZED -> PAID    [condition=hasBalance, after=notify]
+//	ship:   PAID -> SHIPPED        [priority=1, aliases=send|dispatch]
+//	cancel: *!SHIPPED -> CANCELED
+//	remind: PAID                   [internal, after=sendReminder]
+//
+// Sources are listed as in the map form. Options are flags (internal,
+// reentrant, defer) or key=value pairs (before, after, condition, guard,
+// priority, ignore_if_in, aliases), lists being separated by "|". Callbacks,
+// conditions and guards are registered names, as in DefinitionSpec. Without a
+// states line the states are declared in order of appearance, the initial one
+// first. Everything after a "#" is a comment.
+func ParseDSL(src string) (*Definition, error) {
+	spec, err := parseDSL(src)
+	if err != nil {
+		return nil, err
+	}
+	return spec.Build()
+}
+
+func parseDSL(src string) (*DefinitionSpec, error) {
+	spec := &DefinitionSpec{}
+	var seen []string
+	known := map[string]bool{}
+	see := func(states ...string) {
+		for _, state := range states {
+			if state != AnyState && state != HistoryState && !known[state] {
+				known[state] = true
+				seen = append(seen, state)
+			}
+		}
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(src))
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		errorf := func(format string, args ...interface{}) error {
+			return fmt.Errorf("%w: line %d: %s", ErrInvalidDefinition, n, fmt.Sprintf(format, args...))
+		}
+
+		name, rest, ok := strings.Cut(line, ":")
+		if !ok {
+			directive, value, _ := strings.Cut(line, " ")
+			value = strings.TrimSpace(value)
+			switch directive {
+			case "name":
+				spec.Name = value
+			case "states":
+				spec.States = splitNames(value)
+			case "initial":
+				spec.Initial = value
+			case "final":
+				spec.Final = splitNames(value)
+			default:
+				return nil, errorf("expected a trigger or one of name, states, initial, final, got %q", line)
+			}
+			continue
+		}
+
+		t := TriggerSpec{Name: strings.TrimSpace(name)}
+		if t.Name == "" || strings.ContainsAny(t.Name, " \t") {
+			return nil, errorf("invalid trigger name %q", name)
+		}
+		rest = strings.TrimSpace(rest)
+		if i := strings.Index(rest, "["); i >= 0 {
+			if !strings.HasSuffix(rest, "]") {
+				return nil, errorf("unterminated options of trigger %s", t.Name)
+			}
+			if err := t.setOptions(rest[i+1 : len(rest)-1]); err != nil {
+				return nil, errorf("trigger %s: %v", t.Name, err)
+			}
+			rest = strings.TrimSpace(rest[:i])
+		}
+		source, dest, hasDest := strings.Cut(rest, "->")
+		t.Source = StateList{strings.TrimSpace(source)}
+		if t.Source[0] == "" {
+			return nil, errorf("trigger %s has no source", t.Name)
+		}
+		if hasDest {
+			if t.Dest = strings.TrimSpace(dest); t.Dest == "" {
+				return nil, errorf("trigger %s has an empty dest", t.Name)
+			}
+		} else if !t.Internal {
+			return nil, errorf("trigger %s has no dest and is not internal", t.Name)
+		}
+
+		states, except, err := parseSource(t.Source[0])
+		if err != nil {
+			return nil, errorf("trigger %s: %v", t.Name, err)
+		}
+		see(states...)
+		see(except...)
+		if t.Dest != "" && !t.Internal {
+			see(t.Dest)
+		}
+		spec.Triggers = append(spec.Triggers, t)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if spec.States == nil {
+		initial := spec.Initial
+		if initial == "" {
+			initial = DefaultInitialState
+		}
+		see(spec.Final...)
+		spec.States = StateList{initial}
+		for _, state := range seen {
+			if state != initial {
+				spec.States = append(spec.States, state)
+			}
+		}
+	}
+	return spec, nil
+}
+
+func (t *TriggerSpec) setOptions(options string) error {
+	for _, option := range strings.Split(options, ",") {
+		option = strings.TrimSpace(option)
+		if option == "" {
+			continue
+		}
+		key, value, hasValue := strings.Cut(option, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		flag := key == "internal" || key == "reentrant" || key == "defer"
+		if flag && hasValue {
+			return fmt.Errorf("%s takes no value", key)
+		}
+		if !flag && value == "" {
+			return fmt.Errorf("missing value for %s", key)
+		}
+		switch key {
+		case "internal":
+			t.Internal = true
+		case "reentrant":
+			t.Reentrant = true
+		case "defer":
+			t.Defer = true
+		case "before":
+			t.Before = value
+		case "after":
+			t.After = value
+		case "condition":
+			t.Condition = value
+		case "guard":
+			t.Guard = value
+		case "priority":
+			priority, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("priority must be an int, got %q", value)
+			}
+			t.Priority = priority
+		case "ignore_if_in":
+			t.IgnoreIfIn = splitNames(strings.ReplaceAll(value, "|", ","))
+		case "aliases":
+			t.Aliases = splitNames(strings.ReplaceAll(value, "|", ","))
+		default:
+			return fmt.Errorf("unknown option %q", key)
+		}
+	}
+	return nil
+}
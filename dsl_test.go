@@ -0,0 +1,124 @@
+package common
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseDSL(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want *DefinitionSpec
+	}{
+		{
+			name: "declared states",
+			src: `
+name Order
+states INITIALIZED, PAID, SHIPPED
+final SHIPPED
+
+pay:  INITIALIZED -> PAID  [condition=hasBalance, after=notify]
+ship: PAID -> SHIPPED
+`,
+			want: &DefinitionSpec{
+				Name:   "Order",
+				States: StateList{"INITIALIZED", "PAID", "SHIPPED"},
+				Final:  StateList{"SHIPPED"},
+				Triggers: []TriggerSpec{
+					{Name: "pay", Source: StateList{"INITIALIZED"}, Dest: "PAID", Condition: "hasBalance", After: "notify"},
+					{Name: "ship", Source: StateList{"PAID"}, Dest: "SHIPPED"},
+				},
+			},
+		},
+		{
+			name: "states in order of appearance",
+			src: `
+name Order # the order of a customer
+final CANCELED
+cancel: *!SHIPPED -> CANCELED
+ship:   PAID -> SHIPPED
+pay:    INITIALIZED -> PAID
+`,
+			want: &DefinitionSpec{
+				Name:   "Order",
+				States: StateList{"INITIALIZED", "SHIPPED", "CANCELED", "PAID"},
+				Final:  StateList{"CANCELED"},
+				Triggers: []TriggerSpec{
+					{Name: "cancel", Source: StateList{"*!SHIPPED"}, Dest: "CANCELED"},
+					{Name: "ship", Source: StateList{"PAID"}, Dest: "SHIPPED"},
+					{Name: "pay", Source: StateList{"INITIALIZED"}, Dest: "PAID"},
+				},
+			},
+		},
+		{
+			name: "initial state first",
+			src: `
+initial DRAFT
+publish: DRAFT, REVIEWED -> PUBLISHED
+review:  DRAFT -> REVIEWED
+`,
+			want: &DefinitionSpec{
+				Initial: "DRAFT",
+				States:  StateList{"DRAFT", "REVIEWED", "PUBLISHED"},
+				Triggers: []TriggerSpec{
+					{Name: "publish", Source: StateList{"DRAFT, REVIEWED"}, Dest: "PUBLISHED"},
+					{Name: "review", Source: StateList{"DRAFT"}, Dest: "REVIEWED"},
+				},
+			},
+		},
+		{
+			name: "options",
+			src: `
+remind: PAID              [internal, after=sendReminder]
+ship:   PAID -> SHIPPED   [priority=1, aliases=send|dispatch, defer, reentrant]
+pay:    INITIALIZED -> PAID [ignore_if_in=PAID|SHIPPED, guard=solvent, before=reserve]
+`,
+			want: &DefinitionSpec{
+				States: StateList{"INITIALIZED", "PAID", "SHIPPED"},
+				Triggers: []TriggerSpec{
+					{Name: "remind", Source: StateList{"PAID"}, Internal: true, After: "sendReminder"},
+					{Name: "ship", Source: StateList{"PAID"}, Dest: "SHIPPED", Priority: 1, Aliases: StateList{"send", "dispatch"}, Defer: true, Reentrant: true},
+					{Name: "pay", Source: StateList{"INITIALIZED"}, Dest: "PAID", IgnoreIfIn: StateList{"PAID", "SHIPPED"}, Guard: "solvent", Before: "reserve"},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := parseDSL(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(spec, tt.want) {
+				t.Errorf("parseDSL = %+v, want %+v", spec, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDSLErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{name: "unknown directive", src: "region payment"},
+		{name: "trigger name with spaces", src: "pay now: INITIALIZED -> PAID"},
+		{name: "unterminated options", src: "pay: INITIALIZED -> PAID [after=notify"},
+		{name: "no source", src: "pay: -> PAID"},
+		{name: "empty dest", src: "pay: INITIALIZED ->"},
+		{name: "no dest", src: "pay: INITIALIZED"},
+		{name: "flag with a value", src: "pay: INITIALIZED -> PAID [defer=true]"},
+		{name: "option without value", src: "pay: INITIALIZED -> PAID [after=]"},
+		{name: "priority not an int", src: "pay: INITIALIZED -> PAID [priority=high]"},
+		{name: "unknown option", src: "pay: INITIALIZED -> PAID [timeout=1s]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseDSL(tt.src); !errors.Is(err, ErrInvalidDefinition) {
+				t.Errorf("parseDSL(%q): %v, want ErrInvalidDefinition", tt.src, err)
+			}
+		})
+	}
+}
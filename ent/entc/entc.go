@@ -0,0 +1,31 @@
+// Package smentc is the ent code generation extension of sment: it embeds
+// common.StateMachine in the models of the schemas using sment.Mixin, and
+// identifies them in the log by their ID.
+//
+//	err := entc.Generate("./schema", &gen.Config{}, entc.Extensions(smentc.Extension{}))
+//
+// Its template defines model/fields/additional, which the schemas can then
+// not define themselves.
+package smentc
+
+import (
+	"embed"
+
+	"entgo.io/ent/entc"
+	"entgo.io/ent/entc/gen"
+)
+
+//go:embed template
+var templates embed.FS
+
+// Template is the template of Extension.
+var Template = gen.MustParse(gen.NewTemplate("sm").ParseFS(templates, "template/*.tmpl"))
+
+// Extension adds Template to the generated code.
+type Extension struct {
+	entc.DefaultExtension
+}
+
+func (Extension) Templates() []*gen.Template {
+	return []*gen.Template{Template}
+}
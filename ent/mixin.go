@@ -0,0 +1,139 @@
+// Package sment embeds the state machines in the models generated by ent, as
+// common.StateMachine is embedded in gorm models. The schemas of the models
+// use Mixin, which adds their state column and binds the entities to their
+// machines when they are created or queried, as AfterFind and BeforeCreate
+// do, and the code is generated with the smentc.Extension, which embeds
+// common.StateMachine in the models:
+//
+//	func (Order) Mixin() []ent.Mixin {
+//		return []ent.Mixin{sment.Mixin{Versioned: true}}
+//	}
+//
+//	err := entc.Generate("./schema", &gen.Config{}, entc.Extensions(smentc.Extension{}))
+//
+// The machines are declared on the generated models, e.g. by a Define method
+// in a file of their package, and the transitions stored by a Store:
+//
+//	sm.SetStore(sment.NewStore(dialect.Postgres, drv))
+//	order, _ := client.Order.Get(ctx, id)
+//	err := order.DoCtx(ctx, sm.MemoryDB(), "pay", userId)
+//
+// The hooks of Mixin run once the runtime package generated by ent is
+// imported. The entities loaded as edges, e.g. by WithItems, are not bound:
+// call their SetStater with themselves before firing their triggers.
+package sment
+
+import (
+	"context"
+	"reflect"
+
+	"entgo.io/ent"
+	"entgo.io/ent/schema"
+	"entgo.io/ent/schema/field"
+	"entgo.io/ent/schema/index"
+	"entgo.io/ent/schema/mixin"
+
+	common "sm"
+)
+
+// Mixin adds the state column of the machine to a schema, and its version
+// column if Versioned.
+type Mixin struct {
+	mixin.Schema
+	// Initial is the default state of new entities, DefaultInitialState if
+	// empty. Machines declaring another initial state must set it.
+	Initial string
+	// Versioned adds the version column, incremented by every transition,
+	// see common.ErrConcurrentModification.
+	Versioned bool
+}
+
+// Annotation marks the schemas using Mixin, for the smentc templates.
+type Annotation struct {
+	Initial string
+}
+
+func (Annotation) Name() string {
+	return "StateMachine"
+}
+
+func (m Mixin) initial() string {
+	if m.Initial != "" {
+		return m.Initial
+	}
+	return common.DefaultInitialState
+}
+
+func (m Mixin) Fields() []ent.Field {
+	fields := []ent.Field{
+		field.String("state").
+			MaxLen(64).
+			Default(m.initial()).
+			StructTag(`json:"state,omitempty" sm:"state"`),
+	}
+	if m.Versioned {
+		fields = append(fields, field.Int64("version").
+			Default(0).
+			StructTag(`json:"version,omitempty" sm:"version"`))
+	}
+	return fields
+}
+
+func (Mixin) Indexes() []ent.Index {
+	return []ent.Index{index.Fields("state")}
+}
+
+func (m Mixin) Annotations() []schema.Annotation {
+	return []schema.Annotation{Annotation{Initial: m.initial()}}
+}
+
+// Hooks bind the created and updated entities.
+func (Mixin) Hooks() []ent.Hook {
+	return []ent.Hook{
+		func(next ent.Mutator) ent.Mutator {
+			return ent.MutateFunc(func(ctx context.Context, m ent.Mutation) (ent.Value, error) {
+				v, err := next.Mutate(ctx, m)
+				if err == nil {
+					bind(v)
+				}
+				return v, err
+			})
+		},
+	}
+}
+
+// Interceptors bind the queried entities.
+func (Mixin) Interceptors() []ent.Interceptor {
+	return []ent.Interceptor{
+		ent.InterceptFunc(func(next ent.Querier) ent.Querier {
+			return ent.QuerierFunc(func(ctx context.Context, q ent.Query) (ent.Value, error) {
+				v, err := next.Query(ctx, q)
+				if err == nil {
+					bind(v)
+				}
+				return v, err
+			})
+		}),
+	}
+}
+
+// bind binds v, an entity or a slice of them, to its machine.
+func bind(v ent.Value) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Slice {
+		for i := 0; i < rv.Len(); i++ {
+			bindOne(rv.Index(i))
+		}
+		return
+	}
+	bindOne(rv)
+}
+
+func bindOne(v reflect.Value) {
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return
+	}
+	if s, ok := v.Interface().(common.Stater); ok {
+		s.SetStater(s)
+	}
+}
@@ -0,0 +1,125 @@
+package sment
+
+import (
+	"context"
+	stdsql "database/sql"
+	"fmt"
+	"reflect"
+	"time"
+
+	"entgo.io/ent/dialect/sql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+
+	common "sm"
+)
+
+// LogTable is the default table of the log, the one gorm migrates for
+// common.StateMachineLog.
+const LogTable = "state_machine_logs"
+
+// Execer runs the statements of a Store: an *entsql.Driver, a *sql.DB or
+// *sql.Tx, or an ent Client or Tx generated with the sql/execquery feature.
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (stdsql.Result, error)
+}
+
+type execerKey struct{}
+
+// WithExecer returns ctx running the statements of the Store with execer,
+// e.g. the ent Tx of the transitions fired with ctx.
+func WithExecer(ctx context.Context, execer Execer) context.Context {
+	return context.WithValue(ctx, execerKey{}, execer)
+}
+
+// Store updates the states in the tables of the ent models and logs in Logs.
+type Store struct {
+	dialect string
+	db      Execer
+	Logs    string
+	// Table returns the table of the model of obj, by default its struct name
+	// in snake case and plural, as ent names it.
+	Table func(obj common.Stater) string
+}
+
+// NewStore returns the store running the statements of dialect, e.g.
+// dialect.Postgres, with db, unless the context has an Execer.
+func NewStore(dialect string, db Execer) *Store {
+	return &Store{
+		dialect: dialect,
+		db:      db,
+		Logs:    LogTable,
+		Table: func(obj common.Stater) string {
+			return schema.NamingStrategy{}.TableName(common.StructName(obj))
+		},
+	}
+}
+
+func (s *Store) execer(ctx context.Context) Execer {
+	if execer, ok := ctx.Value(execerKey{}).(Execer); ok {
+		return execer
+	}
+	return s.db
+}
+
+// UpdateState sets the state of the row of the object, matched by its id and
+// by the state, and version if any, it was read in; the version is
+// incremented. It returns an error matching common.ErrConcurrentModification
+// if none matched.
+func (s *Store) UpdateState(ctx context.Context, _ *gorm.DB, u common.StateUpdate) error {
+	id := reflect.Indirect(reflect.ValueOf(u.Object)).FieldByName("ID")
+	if !id.IsValid() {
+		return fmt.Errorf("%s has no ID field", common.StructName(u.Object))
+	}
+	column := schema.NamingStrategy{}.ColumnName("", u.Field)
+	update := sql.Dialect(s.dialect).Update(s.Table(u.Object)).Set(column, u.To)
+	where := []*sql.Predicate{sql.EQ("id", id.Interface()), sql.EQ(column, u.From)}
+	if u.VersionField != "" {
+		version := schema.NamingStrategy{}.ColumnName("", u.VersionField)
+		update.Add(version, 1)
+		where = append(where, sql.EQ(version, u.Version))
+	}
+	query, args := update.Where(sql.And(where...)).Query()
+	result, err := s.execer(ctx).ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("update state of %s: %w", common.StructName(u.Object), err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("update state of %s: %w", common.StructName(u.Object), err)
+	}
+	// MySQL counts the rows changed, not matched: a reentrant transition
+	// without version changes none.
+	if affected == 0 && (u.VersionField != "" || u.Source != u.Dest) {
+		return fmt.Errorf("%w: %s %v changed since it was read", common.ErrConcurrentModification, common.StructName(u.Object), id.Interface())
+	}
+	return nil
+}
+
+// Write inserts the entries in Logs, timestamped but without IDs.
+func (s *Store) Write(ctx context.Context, _ *gorm.DB, entries []*common.StateMachineLog) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	insert := sql.Dialect(s.dialect).Insert(s.Logs).Columns(
+		"created_at", "updated_at", "object_id", "object_struct", "trigger", "source", "dest",
+		"operator_id", "operator_ref", "branch", "region", "reason", "metadata", "args",
+		"trace_id", "object_key", "tenant_id", "hash", "prev_hash",
+	)
+	for _, entry := range entries {
+		if entry.CreatedAt.IsZero() {
+			entry.CreatedAt = time.Now()
+			entry.UpdatedAt = entry.CreatedAt
+		}
+		insert.Values(
+			entry.CreatedAt, entry.UpdatedAt, entry.ObjectId, entry.ObjectStruct, entry.Trigger, entry.Source, entry.Dest,
+			entry.OperatorId, entry.OperatorRef, entry.Branch, entry.Region, entry.Reason, entry.Metadata, entry.Args,
+			entry.TraceId, entry.ObjectKey, entry.TenantId, entry.Hash, entry.PrevHash,
+		)
+	}
+	query, args := insert.Query()
+	if _, err := s.execer(ctx).ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("insert log entries: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrTriggerNotFound    = errors.New("trigger not found")
+	ErrNoTriggerAvailable = errors.New("no trigger available")
+	ErrInvalidSourceState = errors.New("invalid source state")
+	ErrGuardRejected      = errors.New("guard rejected")
+	ErrSelfTransition     = errors.New("self-transition not allowed")
+	ErrFinalState         = errors.New("state is final")
+	ErrUnknownState       = errors.New("unknown state")
+	ErrArgumentType       = errors.New("unexpected trigger argument")
+	ErrNoHistory          = errors.New("no history state")
+	ErrNothingToRevert    = errors.New("nothing to revert")
+	ErrRevertNotAllowed   = errors.New("revert not allowed")
+	ErrAlreadyInState     = errors.New("already in state")
+	ErrUnknownCallback    = errors.New("unknown callback")
+	ErrNoPool             = errors.New("no async pool configured")
+	ErrPoolClosed         = errors.New("async pool closed")
+	ErrInvalidDefinition  = errors.New("invalid state machine definition")
+	ErrInvalidMessage     = errors.New("invalid trigger message")
+	ErrNotCreated         = errors.New("object not created yet")
+	ErrObjectDeleted      = errors.New("object is deleted")
+	ErrPublishQueueFull   = errors.New("publish queue full")
+
+	// ErrConcurrentModification is returned by the transitions of an object
+	// whose state, or sm:"version" field, was changed by another since it was
+	// read. The transaction should be rolled back.
+	ErrConcurrentModification = errors.New("concurrent modification")
+
+	// ErrLockNotAcquired is returned by the transitions of an object whose
+	// lock, see SetLockProvider, is held by another instance until ctx ends.
+	ErrLockNotAcquired = errors.New("object lock not acquired")
+
+	// ErrNestedTransition is returned by a Do on an object from within one of
+	// its own transitions, e.g. in an After callback. Use EnqueueTrigger, or a
+	// Deferrable trigger, to fire it afterwards.
+	ErrNestedTransition = errors.New("nested transition")
+)
+
+// GuardError is returned when a guard refuses a trigger. It matches
+// ErrGuardRejected.
+type GuardError struct {
+	Trigger string
+	Reason  string
+}
+
+func (e *GuardError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("%s: %s", ErrGuardRejected, e.Trigger)
+	}
+	return fmt.Sprintf("%s: %s: %s", ErrGuardRejected, e.Trigger, e.Reason)
+}
+
+func (e *GuardError) Unwrap() error {
+	return ErrGuardRejected
+}
+
+// TriggerError is returned by Do for a trigger its object can not do from its
+// current state. It matches Err: ErrInvalidSourceState, or ErrFinalState.
+type TriggerError struct {
+	Err     error
+	Trigger string
+	State   string
+	// Message is the error for end users, translated by the printer of the
+	// transition with its trigger and state, see MessageOf.
+	Message string
+}
+
+func (e *TriggerError) Error() string {
+	return fmt.Sprintf("%s: can not do trigger %s from %s", e.Err, e.Trigger, e.State)
+}
+
+func (e *TriggerError) Unwrap() error {
+	return e.Err
+}
+
+// MessageOf returns err for end users: the translated Message of a
+// TriggerError it wraps, err.Error() otherwise.
+func MessageOf(err error) string {
+	var triggerErr *TriggerError
+	if errors.As(err, &triggerErr) && triggerErr.Message != "" {
+		return triggerErr.Message
+	}
+	return err.Error()
+}
+
+// AlreadyInState refuses a trigger received in one of its ignored states, see
+// TriggerConfig.IgnoreIfIn. Do and BatchDo only log it and return nil, Fire
+// reports it in TransitionResult.AlreadyInState, and CanDo returns false. It
+// matches ErrAlreadyInState.
+type AlreadyInState struct {
+	Trigger string
+	State   string
+}
+
+func (e *AlreadyInState) Error() string {
+	return fmt.Sprintf("%s %s: %s ignored", ErrAlreadyInState, e.State, e.Trigger)
+}
+
+func (e *AlreadyInState) Unwrap() error {
+	return ErrAlreadyInState
+}
@@ -0,0 +1,95 @@
+package common
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// FunnelStep is a state of a Funnel.
+type FunnelStep struct {
+	State string `json:"state"`
+	// Count is the number of objects having gone through the states of the
+	// path up to this one, in order.
+	Count int64 `json:"count"`
+	// Conversion is Count over the Count of the previous step, 1 for the
+	// first step; DropOff is 1 - Conversion.
+	Conversion float64 `json:"conversion"`
+	DropOff    float64 `json:"drop_off"`
+}
+
+// Funnel is the conversion of the objects of a model along a path of states.
+type Funnel struct {
+	ObjectStruct string       `json:"object_struct"`
+	Since        time.Time    `json:"since"`
+	Until        time.Time    `json:"until"`
+	Steps        []FunnelStep `json:"steps"`
+	// Conversion is the Count of the last step over that of the first.
+	Conversion float64 `json:"conversion"`
+}
+
+// FunnelOf computes the funnel of the objects of model's type along path,
+// e.g. INITIALIZED, PAID, SHIPPED, DELIVERED, from the log entries of their
+// main region written in [since, until), unbounded if zero. An object
+// reaches a state if an entry leaves or enters it, so objects that never
+// left their initial state are not counted.
+func FunnelOf(tx *gorm.DB, model Stater, path []string, since, until time.Time) (*Funnel, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("funnel of %s has no state", StructName(model))
+	}
+	query := scopeTenant(tx.Model(&StateMachineLog{})).Select("object_id, COALESCE(object_key, ''), source, dest").
+		Where("object_struct = ? AND region = ''", StructName(model))
+	if !since.IsZero() {
+		query = query.Where("created_at >= ?", since)
+	}
+	if !until.IsZero() {
+		query = query.Where("created_at < ?", until)
+	}
+	rows, err := query.Order(objectPartition + ", id").Rows()
+	if err != nil {
+		return nil, fmt.Errorf("read log of %s: %w", StructName(model), err)
+	}
+	defer rows.Close()
+
+	counts := make([]int64, len(path))
+	var current StateMachineLog
+	progress := -1
+	for rows.Next() {
+		var entry StateMachineLog
+		if err := rows.Scan(&entry.ObjectId, &entry.ObjectKey, &entry.Source, &entry.Dest); err != nil {
+			return nil, err
+		}
+		if progress < 0 || !sameObject(&entry, &current) {
+			current, progress = entry, 0
+		}
+		for _, state := range []string{entry.Source, entry.Dest} {
+			if progress < len(path) && state == path[progress] {
+				counts[progress]++
+				progress++
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	funnel := &Funnel{ObjectStruct: StructName(model), Since: since, Until: until}
+	for i, state := range path {
+		step := FunnelStep{State: state, Count: counts[i], Conversion: 1}
+		if i > 0 {
+			step.Conversion = ratio(counts[i], counts[i-1])
+		}
+		step.DropOff = 1 - step.Conversion
+		funnel.Steps = append(funnel.Steps, step)
+	}
+	funnel.Conversion = ratio(counts[len(counts)-1], counts[0])
+	return funnel, nil
+}
+
+func ratio(n, d int64) float64 {
+	if d == 0 {
+		return 0
+	}
+	return float64(n) / float64(d)
+}
@@ -0,0 +1,86 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFunnelOf(t *testing.T) {
+	path := []string{"INITIALIZED", "PAID", "SHIPPED"}
+	tests := []struct {
+		name         string
+		entries      []StateMachineLog
+		since, until time.Time
+		counts       []int64
+	}{
+		{
+			name: "objects keyed by ID",
+			entries: []StateMachineLog{
+				logEntry(1, "1", "INITIALIZED", "PAID", 0),
+				logEntry(2, "2", "INITIALIZED", "PAID", 5),
+				logEntry(3, "3", "INITIALIZED", "CANCELLED", 5),
+				logEntry(1, "1", "PAID", "SHIPPED", 10),
+			},
+			counts: []int64{3, 2, 1},
+		},
+		{
+			name: "objects keyed by string",
+			entries: []StateMachineLog{
+				logEntry(0, "a1", "INITIALIZED", "PAID", 0),
+				logEntry(0, "b2", "INITIALIZED", "PAID", 5),
+				logEntry(0, "c3", "INITIALIZED", "CANCELLED", 5),
+				logEntry(0, "a1", "PAID", "SHIPPED", 10),
+			},
+			counts: []int64{3, 2, 1},
+		},
+		{
+			name: "states out of order",
+			entries: []StateMachineLog{
+				logEntry(1, "1", "INITIALIZED", "SHIPPED", 0),
+				logEntry(1, "1", "SHIPPED", "PAID", 10),
+			},
+			counts: []int64{1, 1, 0},
+		},
+		{
+			name: "window",
+			entries: []StateMachineLog{
+				logEntry(1, "1", "INITIALIZED", "PAID", 0),
+				logEntry(2, "2", "INITIALIZED", "PAID", 10),
+				logEntry(2, "2", "PAID", "SHIPPED", 12),
+				logEntry(3, "3", "INITIALIZED", "PAID", 20),
+			},
+			since:  at(5),
+			until:  at(15),
+			counts: []int64{1, 1, 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			if err := db.Create(tt.entries).Error; err != nil {
+				t.Fatal(err)
+			}
+			funnel, err := FunnelOf(db, &restoredParcel{}, path, tt.since, tt.until)
+			if err != nil {
+				t.Fatal(err)
+			}
+			counts := make([]int64, len(funnel.Steps))
+			for i, step := range funnel.Steps {
+				counts[i] = step.Count
+				if i > 0 && step.Conversion != ratio(step.Count, funnel.Steps[i-1].Count) {
+					t.Errorf("step %s converts %v of %d objects into %d", step.State, step.Conversion, funnel.Steps[i-1].Count, step.Count)
+				}
+			}
+			if !reflect.DeepEqual(counts, tt.counts) {
+				t.Errorf("funnel counts %v, want %v", counts, tt.counts)
+			}
+		})
+	}
+}
+
+func TestFunnelOfEmptyPath(t *testing.T) {
+	if _, err := FunnelOf(newTestDB(t), &restoredParcel{}, nil, time.Time{}, time.Time{}); err == nil {
+		t.Error("FunnelOf without path succeeded")
+	}
+}
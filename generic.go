@@ -0,0 +1,83 @@
+package common
+
+import (
+	"context"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// TypedStateMachine is a StateMachine bound to a concrete model type, so
+// callbacks and application code can reach the model without type assertions:
+//
+//	type Order struct {
+//		gorm.Model
+//		TypedStateMachine[*Order]
+//	}
+type TypedStateMachine[T Stater] struct {
+	StateMachine
+}
+
+func (sm *TypedStateMachine[T]) Model() T {
+	model, _ := sm.stater.(T)
+	return model
+}
+
+// Callback adapts a callback receiving the concrete model to a CallbackFunc.
+func (sm *TypedStateMachine[T]) Callback(fn func(ctx context.Context, model T, tx *gorm.DB, args ...interface{}) error) CallbackFunc {
+	return func(ctx context.Context, tx *gorm.DB, args ...interface{}) error {
+		return fn(ctx, sm.Model(), tx, args...)
+	}
+}
+
+// Condition adapts a condition receiving the concrete model to a ConditionFunc.
+func (sm *TypedStateMachine[T]) Condition(fn func(ctx context.Context, model T, tx *gorm.DB, args ...interface{}) bool) ConditionFunc {
+	return func(ctx context.Context, tx *gorm.DB, args ...interface{}) bool {
+		return fn(ctx, sm.Model(), tx, args...)
+	}
+}
+
+// DoWith fires trigger on obj with a single typed argument, to be consumed by
+// callbacks created with CallbackWith and ConditionWith.
+func DoWith[A any](tx *gorm.DB, obj Stater, trigger string, operatorId uint, arg A) error {
+	sm, err := machineOf(obj)
+	if err != nil {
+		return err
+	}
+	return sm.Do(tx, trigger, operatorId, arg)
+}
+
+// CallbackWith adapts a callback taking the typed argument of DoWith.
+func CallbackWith[A any](fn func(ctx context.Context, tx *gorm.DB, arg A) error) CallbackFunc {
+	return func(ctx context.Context, tx *gorm.DB, args ...interface{}) error {
+		arg, err := argOf[A](args)
+		if err != nil {
+			return err
+		}
+		return fn(ctx, tx, arg)
+	}
+}
+
+// ConditionWith adapts a condition taking the typed argument of DoWith. The
+// condition fails when the argument is missing or of another type.
+func ConditionWith[A any](fn func(ctx context.Context, tx *gorm.DB, arg A) bool) ConditionFunc {
+	return func(ctx context.Context, tx *gorm.DB, args ...interface{}) bool {
+		arg, err := argOf[A](args)
+		if err != nil {
+			return false
+		}
+		return fn(ctx, tx, arg)
+	}
+}
+
+func argOf[A any](args []interface{}) (A, error) {
+	var zero A
+	if len(args) != 1 {
+		return zero, fmt.Errorf("%w: want one %T, got %d arguments", ErrArgumentType, zero, len(args))
+	}
+	arg, ok := args[0].(A)
+	if !ok {
+		return zero, fmt.Errorf("%w: want %T, got %T", ErrArgumentType, zero, args[0])
+	}
+	return arg, nil
+}
@@ -1,13 +1,16 @@
 module sm
 
-go 1.17
+go 1.18
 
 require (
 	golang.org/x/text v0.3.7
-	gorm.io/gorm v1.22.2
+	gopkg.in/yaml.v3 v3.0.1
+	gorm.io/driver/sqlite v1.2.6
+	gorm.io/gorm v1.22.3
 )
 
 require (
 	github.com/jinzhu/inflection v1.0.0 // indirect
 	github.com/jinzhu/now v1.1.2 // indirect
+	github.com/mattn/go-sqlite3 v1.14.9 // indirect
 )
@@ -0,0 +1,346 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// diagramStates returns the declared states, or the states used by the
+// triggers when none are.
+func (d *Definition) diagramStates() []string {
+	if states := d.States(); len(states) > 0 {
+		return states
+	}
+	return d.usedStates()
+}
+
+// checks names the checks a trigger makes before firing.
+func (tc *TriggerConfig) checks() []string {
+	var checks []string
+	if tc.Condition != nil {
+		checks = append(checks, "condition")
+	}
+	if tc.Guard != nil {
+		checks = append(checks, "guard")
+	}
+	return checks
+}
+
+// callbacks names the callbacks a trigger runs.
+func (tc *TriggerConfig) callbacks() []string {
+	var callbacks []string
+	if tc.Before != nil {
+		callbacks = append(callbacks, "before")
+	}
+	if tc.After != nil {
+		callbacks = append(callbacks, "after")
+	}
+	return callbacks
+}
+
+// edgeLabel is the label of a transition in diagrams: its trigger, branch and
+// checks, one per line, e.g. "ship (express)" and "[condition, guard]".
+func (d *Definition) edgeLabel(t TransitionInfo, trigger func(string) string) []string {
+	lines := []string{trigger(t.Trigger) + branchSuffix(t.Branch)}
+	if tc, ok := d.TriggerConfig(t.Trigger); ok {
+		if checks := tc.checks(); len(checks) > 0 {
+			lines = append(lines, "["+strings.Join(checks, ", ")+"]")
+		}
+	}
+	return lines
+}
+
+// ToDOT renders the machine as a Graphviz digraph, e.g. for dot -Tsvg.
+// Parallel regions are clusters, internal triggers dashed loops, and triggers
+// choosing their destination at runtime lead to a "?" node.
+func (d *Definition) ToDOT() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %s {\n", dotQuote(d.name))
+	b.WriteString("\trankdir=LR;\n")
+	b.WriteString("\tnode [shape=box, style=rounded];\n")
+
+	transitions := d.Transitions()
+	for _, r := range d.allRegions() {
+		indent := "\t"
+		if r.name != "" {
+			fmt.Fprintf(&b, "\tsubgraph %s {\n\t\tlabel=%s;\n", dotQuote("cluster_"+r.name), dotQuote(r.name))
+			indent = "\t\t"
+		}
+		id := func(state string) string {
+			if r.name != "" {
+				state = r.name + "." + state
+			}
+			return dotQuote(state)
+		}
+		def := r.definition
+		fmt.Fprintf(&b, "%s%s [shape=point];\n", indent, id("[*]"))
+		fmt.Fprintf(&b, "%s%s -> %s;\n", indent, id("[*]"), id(def.InitialState()))
+		for _, state := range def.diagramStates() {
+			attrs := "label=" + dotQuote(state)
+			if def.IsFinal(state) {
+				attrs += ", peripheries=2"
+			}
+			fmt.Fprintf(&b, "%s%s [%s];\n", indent, id(state), attrs)
+		}
+		declared := map[string]bool{}
+		for _, t := range transitions {
+			if t.Region != r.name {
+				continue
+			}
+			attrs := "label=" + dotQuote(strings.Join(def.edgeLabel(t, identity), "\n"))
+			dest := t.Dest
+			switch {
+			case t.Internal:
+				dest = t.Source
+				attrs += ", style=dashed"
+			case dest == "":
+				dest = "?"
+				if !declared[dest] {
+					fmt.Fprintf(&b, "%s%s [label=\"?\", shape=diamond];\n", indent, id(dest))
+					declared[dest] = true
+				}
+			case dest == HistoryState:
+				if !declared[dest] {
+					fmt.Fprintf(&b, "%s%s [label=\"H\", shape=circle];\n", indent, id(dest))
+					declared[dest] = true
+				}
+			}
+			fmt.Fprintf(&b, "%s%s -> %s [%s];\n", indent, id(t.Source), id(dest), attrs)
+		}
+		if r.name != "" {
+			b.WriteString("\t}\n")
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func identity(s string) string {
+	return s
+}
+
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + strings.ReplaceAll(s, "\n", `\n`) + `"`
+}
+
+// translate returns the label of its state or trigger name, of kind, as
+// TranslatedState shows it: translated by the printer of the machine, else
+// by the translation fallback.
+func (d *Definition) translate(kind, name string) string {
+	return translate(context.Background(), d, kind, name)
+}
+
+// ToMermaid renders the machine as a Mermaid stateDiagram-v2, e.g. for
+// markdown docs. States and triggers are labeled as by TranslatedState: with
+// their translations by its printer, looked up under the name of the
+// definition, else by the translation fallback, see SetTranslationFallback.
+// Parallel regions are concurrent parts of a composite state.
+func (d *Definition) ToMermaid() string {
+	var b strings.Builder
+	b.WriteString("stateDiagram-v2\n")
+	indent := "    "
+	if len(d.regions) > 0 {
+		fmt.Fprintf(&b, "    state %s {\n", mermaidID(d.name))
+		indent = "        "
+	}
+
+	transitions := d.Transitions()
+	for i, r := range d.allRegions() {
+		if i > 0 {
+			fmt.Fprintf(&b, "%s--\n", indent)
+		}
+		id := func(state string) string {
+			if r.name != "" {
+				state = r.name + "_" + state
+			}
+			return mermaidID(state)
+		}
+		def := r.definition
+		fmt.Fprintf(&b, "%s[*] --> %s\n", indent, id(def.InitialState()))
+		for _, state := range def.diagramStates() {
+			if label := d.translate(KindState, state); label != state || id(state) != state {
+				fmt.Fprintf(&b, "%s%s : %s\n", indent, id(state), mermaidLabel(label))
+			}
+		}
+		declared := map[string]bool{}
+		for _, t := range transitions {
+			if t.Region != r.name {
+				continue
+			}
+			dest := t.Dest
+			switch {
+			case t.Internal:
+				dest = t.Source
+			case dest == "":
+				dest = "dynamic"
+				if !declared[dest] {
+					fmt.Fprintf(&b, "%sstate %s <<choice>>\n", indent, id(dest))
+					declared[dest] = true
+				}
+			case dest == HistoryState:
+				if !declared[dest] {
+					fmt.Fprintf(&b, "%s%s : H\n", indent, id(dest))
+					declared[dest] = true
+				}
+			}
+			label := strings.Join(def.edgeLabel(t, func(trigger string) string {
+				return d.translate(KindTrigger, trigger)
+			}), " ")
+			fmt.Fprintf(&b, "%s%s --> %s : %s\n", indent, id(t.Source), id(dest), mermaidLabel(label))
+		}
+		for _, state := range def.FinalStates() {
+			fmt.Fprintf(&b, "%s%s --> [*]\n", indent, id(state))
+		}
+	}
+	if len(d.regions) > 0 {
+		b.WriteString("    }\n")
+	}
+	return b.String()
+}
+
+// mermaidID turns name into a state id, which Mermaid restricts to letters,
+// digits and underscores.
+func mermaidID(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func mermaidLabel(label string) string {
+	return strings.NewReplacer(":", "#58;", ";", "#59;", "\n", " ").Replace(label)
+}
+
+// ToPlantUML renders the machine as a PlantUML state diagram. Sub-states are
+// nested in their parents, parallel regions are concurrent parts of a
+// composite state, and notes on the transitions list their checks and
+// callbacks. Labels are translated as by ToMermaid.
+func (d *Definition) ToPlantUML() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "@startuml %s\n", mermaidID(d.name))
+	b.WriteString("hide empty description\n")
+	indent := ""
+	if len(d.regions) > 0 {
+		fmt.Fprintf(&b, "state %s {\n", mermaidID(d.name))
+		indent = "  "
+	}
+
+	transitions := d.Transitions()
+	for i, r := range d.allRegions() {
+		if i > 0 {
+			fmt.Fprintf(&b, "%s--\n", indent)
+		}
+		id := func(state string) string {
+			if r.name != "" {
+				state = r.name + "_" + state
+			}
+			return mermaidID(state)
+		}
+		def := r.definition
+		fmt.Fprintf(&b, "%s[*] --> %s\n", indent, id(def.InitialState()))
+
+		states := def.diagramStates()
+		children := map[string][]string{}
+		for _, state := range states {
+			if parent, ok := def.Parent(state); ok {
+				children[parent] = append(children[parent], state)
+			}
+		}
+		var declare func(state, indent string)
+		declare = func(state, indent string) {
+			fmt.Fprintf(&b, "%sstate %s as %s", indent, plantUMLQuote(d.translate(KindState, state)), id(state))
+			if len(children[state]) == 0 {
+				b.WriteString("\n")
+				return
+			}
+			b.WriteString(" {\n")
+			for _, child := range children[state] {
+				declare(child, indent+"  ")
+			}
+			fmt.Fprintf(&b, "%s}\n", indent)
+		}
+		for _, state := range states {
+			if parent, ok := def.Parent(state); !ok || !def.HasState(parent) {
+				declare(state, indent)
+			}
+		}
+		for _, state := range states {
+			var hooks []string
+			if len(def.onEnter[state]) > 0 {
+				hooks = append(hooks, "on enter")
+			}
+			if len(def.onExit[state]) > 0 {
+				hooks = append(hooks, "on exit")
+			}
+			if len(hooks) > 0 {
+				fmt.Fprintf(&b, "%snote right of %s : %s\n", indent, id(state), strings.Join(hooks, ", "))
+			}
+		}
+
+		// Transitions inherited from a parent are drawn from the parent only.
+		drawn := map[[2]string]bool{}
+		for _, t := range transitions {
+			if t.Region == r.name {
+				drawn[[2]string{t.Trigger, t.Source}] = true
+			}
+		}
+		declared := map[string]bool{}
+		for _, t := range transitions {
+			if t.Region != r.name {
+				continue
+			}
+			if parent, ok := def.Parent(t.Source); ok && drawn[[2]string{t.Trigger, parent}] {
+				continue
+			}
+			source, dest := id(t.Source), id(t.Dest)
+			switch {
+			case t.Internal:
+				dest = source
+			case t.Dest == "":
+				dest = id("dynamic")
+				if !declared[dest] {
+					fmt.Fprintf(&b, "%sstate %s <<choice>>\n", indent, dest)
+					declared[dest] = true
+				}
+			case t.Dest == HistoryState:
+				dest = "[H]"
+			}
+			fmt.Fprintf(&b, "%s%s --> %s : %s\n", indent, source, dest, d.translate(KindTrigger, t.Trigger)+branchSuffix(t.Branch))
+			tc, _ := def.TriggerConfig(t.Trigger)
+			var notes []string
+			if checks := tc.checks(); len(checks) > 0 {
+				notes = append(notes, "checks: "+strings.Join(checks, ", "))
+			}
+			if callbacks := tc.callbacks(); len(callbacks) > 0 {
+				notes = append(notes, "runs: "+strings.Join(callbacks, ", "))
+			}
+			if len(notes) > 0 {
+				fmt.Fprintf(&b, "%snote on link\n%s  %s\n%send note\n", indent, indent, strings.Join(notes, "\n"+indent+"  "), indent)
+			}
+		}
+		for _, state := range def.FinalStates() {
+			fmt.Fprintf(&b, "%s%s --> [*]\n", indent, id(state))
+		}
+	}
+	if len(d.regions) > 0 {
+		b.WriteString("}\n")
+	}
+	b.WriteString("@enduml\n")
+	return b.String()
+}
+
+func branchSuffix(branch string) string {
+	if branch == "" {
+		return ""
+	}
+	return " (" + branch + ")"
+}
+
+func plantUMLQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `'`) + `"`
+}
@@ -0,0 +1,87 @@
+package smgraphql
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"gorm.io/gorm"
+
+	common "sm"
+	smhttp "sm/http"
+)
+
+// machine is the part of the StateMachine API the resolvers use, promoted to
+// the models embedding it.
+type machine interface {
+	common.Stater
+	AvailableTriggersCtx(ctx context.Context) []*common.AvailableTrigger
+	FireCtx(ctx context.Context, tx *gorm.DB, trigger string, userInfoId uint, args ...interface{}) (*common.TransitionResult, error)
+}
+
+// Resolver implements the fields of Schema:
+//
+//	func (r *orderResolver) AvailableTriggers(ctx context.Context, obj *Order) ([]*sm.AvailableTrigger, error) {
+//		return r.SM.AvailableTriggers(ctx, obj)
+//	}
+//
+//	func (r *mutationResolver) FireOrderTrigger(ctx context.Context, id string, trigger string) (*sm.TransitionResult, error) {
+//		return r.SM.FireTrigger(ctx, &Order{}, id, trigger)
+//	}
+type Resolver struct {
+	DB *gorm.DB
+	// Operator returns the operator of a mutation, by default the one stored
+	// in its context by smhttp.WithOperator.
+	Operator func(ctx context.Context) (uint, error)
+}
+
+func NewResolver(db *gorm.DB) *Resolver {
+	return &Resolver{
+		DB: db,
+		Operator: func(ctx context.Context) (uint, error) {
+			if operatorId, ok := smhttp.OperatorFromContext(ctx); ok {
+				return operatorId, nil
+			}
+			return 0, smhttp.ErrNoOperator
+		},
+	}
+}
+
+func (r *Resolver) AvailableTriggers(ctx context.Context, obj common.Stater) ([]*common.AvailableTrigger, error) {
+	m, ok := obj.(machine)
+	if !ok {
+		return nil, fmt.Errorf("%T does not embed StateMachine", obj)
+	}
+	triggers := m.AvailableTriggersCtx(ctx)
+	if triggers == nil {
+		triggers = []*common.AvailableTrigger{}
+	}
+	return triggers, nil
+}
+
+// FireTrigger loads the object of the type of model with ID id and fires
+// trigger on it.
+func (r *Resolver) FireTrigger(ctx context.Context, model common.Stater, id string, trigger string, args ...interface{}) (*common.TransitionResult, error) {
+	operatorId, err := r.Operator(ctx)
+	if err != nil {
+		return nil, err
+	}
+	objectId, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id %q", id)
+	}
+	t := reflect.TypeOf(model)
+	if t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%T is not a pointer to a model", model)
+	}
+	obj, ok := reflect.New(t.Elem()).Interface().(machine)
+	if !ok {
+		return nil, fmt.Errorf("%T does not embed StateMachine", model)
+	}
+	tx := r.DB.WithContext(ctx)
+	if err := tx.First(obj, objectId).Error; err != nil {
+		return nil, err
+	}
+	return obj.FireCtx(ctx, tx, trigger, operatorId, args...)
+}
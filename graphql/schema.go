@@ -0,0 +1,84 @@
+// Package smgraphql provides the GraphQL schema and resolvers of state machine
+// models, for gqlgen and similar schema-first servers. Add CommonSchema and
+// the Schema of each model to the schema, and bind the types to sm:
+//
+//	# gqlgen.yml
+//	models:
+//	  AvailableTrigger: {model: sm.AvailableTrigger}
+//	  TriggerMetadata:  {model: sm.TriggerMetadata}
+//	  TransitionResult: {model: sm.TransitionResult}
+//	  OrderState:       {model: github.com/99designs/gqlgen/graphql.String}
+//
+// then implement the fields with a Resolver.
+package smgraphql
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	common "sm"
+)
+
+// CommonSchema declares the types shared by the schemas of every model.
+const CommonSchema = `type TriggerMetadata {
+  displayName: String!
+  description: String!
+  icon: String!
+  confirmMessage: String!
+}
+
+type AvailableTrigger {
+  trigger: String!
+  translatedTrigger: String!
+  metadata: TriggerMetadata!
+}
+
+type TransitionResult {
+  trigger: String!
+  region: String!
+  source: String!
+  dest: String!
+  branch: String!
+  logID: ID!
+  shortCircuited: Boolean!
+}
+`
+
+var name = regexp.MustCompile(`^[_A-Za-z][_0-9A-Za-z]*$`)
+
+// Schema returns the enum of the states of the type of model, e.g.
+// OrderState, the fields extending the type of the same name, and the
+// mutation firing its triggers, e.g. fireOrderTrigger.
+func Schema(model common.Stater) (string, error) {
+	definition, err := common.DefinitionOf(model)
+	if err != nil {
+		return "", err
+	}
+	typeName := common.StructName(model)
+	states := definition.States()
+	if len(states) == 0 {
+		return "", fmt.Errorf("%s does not declare its states", typeName)
+	}
+	for _, state := range states {
+		if !name.MatchString(state) {
+			return "", fmt.Errorf("state %s of %s is not a GraphQL enum value", state, typeName)
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "enum %sState {\n", typeName)
+	for _, state := range states {
+		fmt.Fprintf(&b, "  %s\n", state)
+	}
+	b.WriteString("}\n\n")
+	fmt.Fprintf(&b, "extend type %s {\n", typeName)
+	fmt.Fprintf(&b, "  state: %sState!\n", typeName)
+	b.WriteString("  translatedState: String!\n")
+	b.WriteString("  availableTriggers: [AvailableTrigger!]!\n")
+	b.WriteString("}\n\n")
+	b.WriteString("extend type Mutation {\n")
+	fmt.Fprintf(&b, "  fire%sTrigger(id: ID!, trigger: String!): TransitionResult!\n", typeName)
+	b.WriteString("}\n")
+	return b.String(), nil
+}
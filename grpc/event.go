@@ -0,0 +1,24 @@
+package smgrpc
+
+import (
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	common "sm"
+	"sm/grpc/smpb"
+)
+
+// EventProto returns the protobuf message of a transition event payload, for
+// the brokers publishing protobuf.
+func EventProto(p *common.EventPayload) *smpb.TransitionEvent {
+	return &smpb.TransitionEvent{
+		ObjectStruct: p.ObjectStruct,
+		ObjectId:     uint64(p.ObjectId),
+		Region:       p.Region,
+		Trigger:      p.Trigger,
+		Source:       p.Source,
+		Dest:         p.Dest,
+		OperatorId:   uint64(p.OperatorId),
+		Timestamp:    timestamppb.New(p.Timestamp),
+		LogId:        uint64(p.LogId),
+	}
+}
@@ -0,0 +1,22 @@
+module sm/grpc
+
+go 1.23
+
+require (
+	google.golang.org/grpc v1.69.4
+	google.golang.org/protobuf v1.36.11
+	gorm.io/gorm v1.22.3
+	sm v0.0.0
+)
+
+require (
+	github.com/jinzhu/inflection v1.0.0 // indirect
+	github.com/jinzhu/now v1.1.2 // indirect
+	golang.org/x/net v0.30.0 // indirect
+	golang.org/x/sys v0.26.0 // indirect
+	golang.org/x/text v0.19.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20241015192408-796eee8c2d53 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace sm => ../
@@ -0,0 +1,232 @@
+// Package smgrpc serves the triggers and logs of state machine models over
+// gRPC, see smpb/sm.proto:
+//
+//	srv := grpc.NewServer()
+//	smpb.RegisterStateMachineServer(srv, smgrpc.NewServer(db, &Order{}, &Invoice{}))
+//
+// The operator of a transition is taken from the context of the call, see
+// WithOperator, typically set by the authentication interceptor.
+package smgrpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative smpb/sm.proto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+
+	common "sm"
+	"sm/grpc/smpb"
+)
+
+// ErrNoOperator is returned by the default Operator of a Server for calls
+// without one.
+var ErrNoOperator = errors.New("no operator in call context")
+
+type operatorKey struct{}
+
+// WithOperator returns ctx carrying the ID of the user acting on the call.
+func WithOperator(ctx context.Context, operatorId uint) context.Context {
+	return context.WithValue(ctx, operatorKey{}, operatorId)
+}
+
+func OperatorFromContext(ctx context.Context) (uint, bool) {
+	operatorId, ok := ctx.Value(operatorKey{}).(uint)
+	return operatorId, ok
+}
+
+// machine is the part of the StateMachine API the server uses, promoted to
+// the models embedding it.
+type machine interface {
+	common.Stater
+	AvailableTriggersCtx(ctx context.Context) []*common.AvailableTrigger
+	TranslatedStateCtx(ctx context.Context) string
+	FireCtx(ctx context.Context, tx *gorm.DB, trigger string, userInfoId uint, args ...interface{}) (*common.TransitionResult, error)
+}
+
+type Server struct {
+	smpb.UnimplementedStateMachineServer
+
+	db     *gorm.DB
+	mu     sync.RWMutex
+	models map[string]reflect.Type
+	// Operator returns the operator of a call, by default the one stored in
+	// its context by WithOperator. Fire is refused with Unauthenticated when
+	// it fails.
+	Operator func(ctx context.Context) (uint, error)
+	// Args decodes the args of a FireRequest, which are ignored by default.
+	Args func(model, trigger string, data []byte) ([]interface{}, error)
+}
+
+// NewServer returns a server of the objects of the types of models, which may
+// be zero values.
+func NewServer(db *gorm.DB, models ...common.Stater) *Server {
+	s := &Server{
+		db:     db,
+		models: map[string]reflect.Type{},
+		Operator: func(ctx context.Context) (uint, error) {
+			if operatorId, ok := OperatorFromContext(ctx); ok {
+				return operatorId, nil
+			}
+			return 0, ErrNoOperator
+		},
+	}
+	for _, model := range models {
+		s.Register(model)
+	}
+	return s
+}
+
+// Register serves the objects of the type of model under its struct name.
+func (s *Server) Register(model common.Stater) {
+	t := reflect.TypeOf(model)
+	if t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("smgrpc: %T is not a pointer to a model", model))
+	}
+	if _, ok := model.(machine); !ok {
+		panic(fmt.Sprintf("smgrpc: %T does not embed StateMachine", model))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.models[common.StructName(model)] = t.Elem()
+}
+
+func (s *Server) ListTriggers(ctx context.Context, req *smpb.ListTriggersRequest) (*smpb.ListTriggersResponse, error) {
+	obj, err := s.load(ctx, req.Model, req.ObjectId)
+	if err != nil {
+		return nil, err
+	}
+	resp := &smpb.ListTriggersResponse{State: obj.GetState(), TranslatedState: obj.TranslatedStateCtx(ctx)}
+	for _, trigger := range obj.AvailableTriggersCtx(ctx) {
+		resp.Triggers = append(resp.Triggers, &smpb.Trigger{
+			Name:           trigger.Trigger,
+			TranslatedName: trigger.TranslatedTrigger,
+			DisplayName:    trigger.Metadata.DisplayName,
+			Description:    trigger.TranslatedDescription,
+			Icon:           trigger.Metadata.Icon,
+			ConfirmMessage: trigger.Metadata.ConfirmMessage,
+			Extra:          trigger.Metadata.Extra,
+		})
+	}
+	return resp, nil
+}
+
+func (s *Server) Fire(ctx context.Context, req *smpb.FireRequest) (*smpb.FireResponse, error) {
+	operatorId, err := s.Operator(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	var args []interface{}
+	if s.Args != nil {
+		var err error
+		if args, err = s.Args(req.Model, req.Trigger, req.Args); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	obj, err := s.load(ctx, req.Model, req.ObjectId)
+	if err != nil {
+		return nil, err
+	}
+	result, err := obj.FireCtx(ctx, s.db.WithContext(ctx), req.Trigger, operatorId, args...)
+	if err != nil {
+		return nil, statusOf(err)
+	}
+	return &smpb.FireResponse{
+		Trigger:        result.Trigger,
+		Region:         result.Region,
+		Source:         result.Source,
+		Dest:           result.Dest,
+		Branch:         result.Branch,
+		LogId:          uint64(result.LogID),
+		ShortCircuited: result.ShortCircuited,
+		State:          obj.GetState(),
+	}, nil
+}
+
+func (s *Server) GetHistory(ctx context.Context, req *smpb.GetHistoryRequest) (*smpb.GetHistoryResponse, error) {
+	if _, err := s.model(req.Model); err != nil {
+		return nil, err
+	}
+	// The tenant is that of the context, see common.WithTenant.
+	opts := common.HistoryOptions{ObjectStruct: req.Model, Page: int(req.Page), PageSize: int(req.PageSize)}
+	if req.ObjectKey != "" {
+		opts.ObjectKeys = []string{req.ObjectKey}
+	} else {
+		opts.ObjectIds = []uint{uint(req.ObjectId)}
+	}
+	page, err := common.QueryHistory(s.db.WithContext(ctx), opts)
+	if err != nil {
+		return nil, statusOf(err)
+	}
+	resp := &smpb.GetHistoryResponse{Total: page.Total}
+	for _, entry := range page.Entries {
+		resp.Entries = append(resp.Entries, &smpb.LogEntry{
+			Id:         uint64(entry.ID),
+			Trigger:    entry.Trigger,
+			Source:     entry.Source,
+			Dest:       entry.Dest,
+			Branch:     entry.Branch,
+			Region:     entry.Region,
+			OperatorId: uint64(entry.OperatorId),
+			Reason:     entry.Reason,
+			CreatedAt:  timestamppb.New(entry.CreatedAt),
+		})
+	}
+	return resp, nil
+}
+
+func (s *Server) model(name string) (reflect.Type, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.models[name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "unknown model %q", name)
+	}
+	return t, nil
+}
+
+func (s *Server) load(ctx context.Context, model string, id uint64) (machine, error) {
+	t, err := s.model(model)
+	if err != nil {
+		return nil, err
+	}
+	obj := reflect.New(t).Interface().(machine)
+	if err := s.db.WithContext(ctx).First(obj, id).Error; err != nil {
+		return nil, statusOf(err)
+	}
+	return obj, nil
+}
+
+// statusOf maps the errors of Do to gRPC statuses. Internal errors are not
+// disclosed.
+func statusOf(err error) error {
+	code := codes.Internal
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound), errors.Is(err, common.ErrTriggerNotFound):
+		code = codes.NotFound
+	case errors.Is(err, common.ErrGuardRejected), errors.Is(err, common.ErrInvalidSourceState),
+		errors.Is(err, common.ErrFinalState), errors.Is(err, common.ErrSelfTransition),
+		errors.Is(err, common.ErrObjectDeleted):
+		code = codes.FailedPrecondition
+	case errors.Is(err, common.ErrNestedTransition), errors.Is(err, common.ErrConcurrentModification),
+		errors.Is(err, common.ErrLockNotAcquired):
+		code = codes.Aborted
+	case errors.Is(err, common.ErrArgumentType):
+		code = codes.InvalidArgument
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	}
+	if code == codes.Internal {
+		return status.Error(code, code.String())
+	}
+	return status.Error(code, err.Error())
+}
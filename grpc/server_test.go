@@ -0,0 +1,72 @@
+package smgrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+
+	common "sm"
+	"sm/grpc/smpb"
+)
+
+func TestStatusOf(t *testing.T) {
+	tests := []struct {
+		err  error
+		code codes.Code
+	}{
+		{gorm.ErrRecordNotFound, codes.NotFound},
+		{fmt.Errorf("%w: refund", common.ErrTriggerNotFound), codes.NotFound},
+		{&common.GuardError{Trigger: "pay", Reason: "insufficient balance"}, codes.FailedPrecondition},
+		{&common.TriggerError{Err: common.ErrInvalidSourceState, Trigger: "ship", State: "INITIALIZED"}, codes.FailedPrecondition},
+		{&common.TriggerError{Err: common.ErrFinalState, Trigger: "ship", State: "CANCELLED"}, codes.FailedPrecondition},
+		{common.ErrSelfTransition, codes.FailedPrecondition},
+		{common.ErrObjectDeleted, codes.FailedPrecondition},
+		{common.ErrNestedTransition, codes.Aborted},
+		{common.ErrConcurrentModification, codes.Aborted},
+		{common.ErrLockNotAcquired, codes.Aborted},
+		{common.ErrArgumentType, codes.InvalidArgument},
+		{fmt.Errorf("load order: %w", context.Canceled), codes.Canceled},
+		{fmt.Errorf("load order: %w", context.DeadlineExceeded), codes.DeadlineExceeded},
+		{errors.New("dial tcp 10.0.0.1:5432: connection refused"), codes.Internal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			st, _ := status.FromError(statusOf(tt.err))
+			if st.Code() != tt.code {
+				t.Errorf("statusOf(%v) = %s, want %s", tt.err, st.Code(), tt.code)
+			}
+			message := tt.err.Error()
+			if tt.code == codes.Internal {
+				message = codes.Internal.String()
+			}
+			if st.Message() != message {
+				t.Errorf("statusOf(%v) message %q, want %q", tt.err, st.Message(), message)
+			}
+		})
+	}
+}
+
+func TestFireOperator(t *testing.T) {
+	tests := []struct {
+		name string
+		ctx  context.Context
+		code codes.Code
+	}{
+		{name: "without operator", ctx: context.Background(), code: codes.Unauthenticated},
+		{name: "with operator", ctx: WithOperator(context.Background(), 7), code: codes.NotFound},
+	}
+	s := NewServer(nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.Fire(tt.ctx, &smpb.FireRequest{Model: "Order", ObjectId: 1, Trigger: "pay"})
+			if code := status.Code(err); code != tt.code {
+				t.Errorf("Fire: %v, want %s", err, tt.code)
+			}
+		})
+	}
+}
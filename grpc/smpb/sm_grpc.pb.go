@@ -0,0 +1,211 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: smpb/sm.proto
+
+package smpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	StateMachine_ListTriggers_FullMethodName = "/sm.v1.StateMachine/ListTriggers"
+	StateMachine_Fire_FullMethodName         = "/sm.v1.StateMachine/Fire"
+	StateMachine_GetHistory_FullMethodName   = "/sm.v1.StateMachine/GetHistory"
+)
+
+// StateMachineClient is the client API for StateMachine service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// StateMachine fires the triggers of the models registered with the server.
+type StateMachineClient interface {
+	// ListTriggers returns the state of an object and the triggers available
+	// in it.
+	ListTriggers(ctx context.Context, in *ListTriggersRequest, opts ...grpc.CallOption) (*ListTriggersResponse, error)
+	// Fire fires a trigger of an object.
+	Fire(ctx context.Context, in *FireRequest, opts ...grpc.CallOption) (*FireResponse, error)
+	// GetHistory returns a page of the StateMachineLog of an object, oldest
+	// first.
+	GetHistory(ctx context.Context, in *GetHistoryRequest, opts ...grpc.CallOption) (*GetHistoryResponse, error)
+}
+
+type stateMachineClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewStateMachineClient(cc grpc.ClientConnInterface) StateMachineClient {
+	return &stateMachineClient{cc}
+}
+
+func (c *stateMachineClient) ListTriggers(ctx context.Context, in *ListTriggersRequest, opts ...grpc.CallOption) (*ListTriggersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListTriggersResponse)
+	err := c.cc.Invoke(ctx, StateMachine_ListTriggers_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stateMachineClient) Fire(ctx context.Context, in *FireRequest, opts ...grpc.CallOption) (*FireResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(FireResponse)
+	err := c.cc.Invoke(ctx, StateMachine_Fire_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stateMachineClient) GetHistory(ctx context.Context, in *GetHistoryRequest, opts ...grpc.CallOption) (*GetHistoryResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetHistoryResponse)
+	err := c.cc.Invoke(ctx, StateMachine_GetHistory_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// StateMachineServer is the server API for StateMachine service.
+// All implementations must embed UnimplementedStateMachineServer
+// for forward compatibility.
+//
+// StateMachine fires the triggers of the models registered with the server.
+type StateMachineServer interface {
+	// ListTriggers returns the state of an object and the triggers available
+	// in it.
+	ListTriggers(context.Context, *ListTriggersRequest) (*ListTriggersResponse, error)
+	// Fire fires a trigger of an object.
+	Fire(context.Context, *FireRequest) (*FireResponse, error)
+	// GetHistory returns a page of the StateMachineLog of an object, oldest
+	// first.
+	GetHistory(context.Context, *GetHistoryRequest) (*GetHistoryResponse, error)
+	mustEmbedUnimplementedStateMachineServer()
+}
+
+// UnimplementedStateMachineServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedStateMachineServer struct{}
+
+func (UnimplementedStateMachineServer) ListTriggers(context.Context, *ListTriggersRequest) (*ListTriggersResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ListTriggers not implemented")
+}
+func (UnimplementedStateMachineServer) Fire(context.Context, *FireRequest) (*FireResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Fire not implemented")
+}
+func (UnimplementedStateMachineServer) GetHistory(context.Context, *GetHistoryRequest) (*GetHistoryResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetHistory not implemented")
+}
+func (UnimplementedStateMachineServer) mustEmbedUnimplementedStateMachineServer() {}
+func (UnimplementedStateMachineServer) testEmbeddedByValue()                      {}
+
+// UnsafeStateMachineServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to StateMachineServer will
+// result in compilation errors.
+type UnsafeStateMachineServer interface {
+	mustEmbedUnimplementedStateMachineServer()
+}
+
+func RegisterStateMachineServer(s grpc.ServiceRegistrar, srv StateMachineServer) {
+	// If the following call panics, it indicates UnimplementedStateMachineServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&StateMachine_ServiceDesc, srv)
+}
+
+func _StateMachine_ListTriggers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListTriggersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StateMachineServer).ListTriggers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: StateMachine_ListTriggers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StateMachineServer).ListTriggers(ctx, req.(*ListTriggersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StateMachine_Fire_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FireRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StateMachineServer).Fire(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: StateMachine_Fire_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StateMachineServer).Fire(ctx, req.(*FireRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StateMachine_GetHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetHistoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StateMachineServer).GetHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: StateMachine_GetHistory_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StateMachineServer).GetHistory(ctx, req.(*GetHistoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// StateMachine_ServiceDesc is the grpc.ServiceDesc for StateMachine service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var StateMachine_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "sm.v1.StateMachine",
+	HandlerType: (*StateMachineServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListTriggers",
+			Handler:    _StateMachine_ListTriggers_Handler,
+		},
+		{
+			MethodName: "Fire",
+			Handler:    _StateMachine_Fire_Handler,
+		},
+		{
+			MethodName: "GetHistory",
+			Handler:    _StateMachine_GetHistory_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "smpb/sm.proto",
+}
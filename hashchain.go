@@ -0,0 +1,172 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrChainBroken is returned by VerifyChain for a log edited or deleted.
+var ErrChainBroken = errors.New("log hash chain broken")
+
+var hashChain int32
+
+// HashChain enables or disables the hash chain of the log: each entry then
+// stores in Hash the SHA-256 of its content and of PrevHash, the Hash of the
+// previous entry of its object, so that VerifyChain detects edits and
+// deletions. The previous entry is read in the transaction of the transition,
+// so the chain needs an AuditSink writing there, like the GormSink, and
+// CreatedAt is stored at the millisecond to survive the database precision.
+func HashChain(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&hashChain, v)
+}
+
+// chainedContent is the hashed content of an entry.
+type chainedContent struct {
+	ObjectStruct string
+	ObjectId     uint
+	// ObjectKey is only hashed without ObjectId, so that the entries
+	// written before it keep their hash.
+	ObjectKey   string `json:",omitempty"`
+	Region      string
+	Trigger     string
+	Source      string
+	Dest        string
+	OperatorId  uint
+	OperatorRef string
+	Branch      string
+	Reason      string
+	Metadata    LogMetadata
+	Args        string
+	TraceId     string
+	TenantId    string `json:",omitempty"`
+	CreatedAt   int64
+	PrevHash    string
+}
+
+// HashOf returns the hash of the content of entry and of its PrevHash.
+func HashOf(entry *StateMachineLog) (string, error) {
+	var key string
+	if entry.ObjectId == 0 {
+		key = entry.ObjectKey
+	}
+	content, err := json.Marshal(chainedContent{
+		ObjectStruct: entry.ObjectStruct,
+		ObjectId:     entry.ObjectId,
+		ObjectKey:    key,
+		Region:       entry.Region,
+		Trigger:      entry.Trigger,
+		Source:       entry.Source,
+		Dest:         entry.Dest,
+		OperatorId:   entry.OperatorId,
+		OperatorRef:  entry.OperatorRef,
+		Branch:       entry.Branch,
+		Reason:       entry.Reason,
+		Metadata:     entry.Metadata,
+		Args:         entry.Args,
+		TraceId:      entry.TraceId,
+		TenantId:     entry.TenantId,
+		CreatedAt:    entry.CreatedAt.UnixNano() / int64(time.Millisecond),
+		PrevHash:     entry.PrevHash,
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// chainEntries links entries to the last entries of their objects, if the
+// hash chain is enabled.
+func chainEntries(tx *gorm.DB, entries []*StateMachineLog) error {
+	if atomic.LoadInt32(&hashChain) == 0 {
+		return nil
+	}
+	type object struct {
+		objectStruct string
+		id           uint
+		key          string
+	}
+	last := map[object]string{}
+	for _, entry := range entries {
+		key := object{entry.ObjectStruct, entry.ObjectId, entry.ObjectKey}
+		prev, ok := last[key]
+		if !ok {
+			query := tx.Unscoped().Select("hash").Where("object_id = ? AND object_struct = ?", entry.ObjectId, entry.ObjectStruct)
+			if entry.ObjectId == 0 {
+				query = tx.Unscoped().Select("hash").Where("object_key = ? AND object_struct = ?", entry.ObjectKey, entry.ObjectStruct)
+			}
+			var previous []StateMachineLog
+			if err := query.Order("id DESC").Limit(1).Find(&previous).Error; err != nil {
+				return fmt.Errorf("read hash chain of %s %s: %w", entry.ObjectStruct, entry.ObjectKey, err)
+			}
+			if len(previous) > 0 {
+				prev = previous[0].Hash
+			}
+		}
+		if entry.CreatedAt.IsZero() {
+			entry.CreatedAt = time.Now()
+		}
+		entry.CreatedAt = entry.CreatedAt.Truncate(time.Millisecond)
+		entry.UpdatedAt = entry.CreatedAt
+		entry.PrevHash = prev
+		hash, err := HashOf(entry)
+		if err != nil {
+			return err
+		}
+		entry.Hash, last[key] = hash, hash
+	}
+	return nil
+}
+
+// VerifyChain checks the hash chain of the log of the object of model's type
+// with ID id, from its first hashed entry, and returns an error matching
+// ErrChainBroken at the first entry edited, or following deleted ones.
+// Deleting the latest entries cannot be detected.
+func VerifyChain(tx *gorm.DB, model Stater, id uint) error {
+	return verifyChain(tx, model, objectRef{id: id})
+}
+
+// VerifyChainKey is VerifyChain for the objects whose primary key is not an
+// unsigned integer, chained by their ObjectKey.
+func VerifyChainKey(tx *gorm.DB, model Stater, key string) error {
+	return verifyChain(tx, model, objectRef{key: key})
+}
+
+func verifyChain(tx *gorm.DB, model Stater, ref objectRef) error {
+	name := StructName(model)
+	var entries []StateMachineLog
+	if err := ref.where(tx.Unscoped(), name).Order("id").Find(&entries).Error; err != nil {
+		return fmt.Errorf("read log of %s %s: %w", name, ref, err)
+	}
+	prev, chained := "", false
+	for i := range entries {
+		entry := &entries[i]
+		if entry.Hash == "" && !chained {
+			continue
+		}
+		chained = true
+		if entry.PrevHash != prev {
+			return fmt.Errorf("%w: log entry %d of %s %s does not follow the previous one", ErrChainBroken, entry.ID, name, ref)
+		}
+		hash, err := HashOf(entry)
+		if err != nil {
+			return err
+		}
+		if hash != entry.Hash {
+			return fmt.Errorf("%w: log entry %d of %s %s was edited", ErrChainBroken, entry.ID, name, ref)
+		}
+		prev = entry.Hash
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package common
+
+import (
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+type chainedOrder struct {
+	ID uint
+	StateMachine
+}
+
+func (*chainedOrder) Define() *Definition {
+	return keyedDefinition
+}
+
+// withHashChain enables the hash chain for the test.
+func withHashChain(t *testing.T) {
+	HashChain(true)
+	t.Cleanup(func() { HashChain(false) })
+}
+
+func TestVerifyChain(t *testing.T) {
+	tests := []struct {
+		name string
+		// unchained is the number of transitions logged before the chain
+		// is enabled.
+		unchained int
+		tamper    func(db *gorm.DB, entries []StateMachineLog) error
+		err       error
+	}{
+		{name: "untouched log"},
+		{
+			name: "edited entry",
+			tamper: func(db *gorm.DB, entries []StateMachineLog) error {
+				return db.Model(&entries[0]).Update("operator_id", 2).Error
+			},
+			err: ErrChainBroken,
+		},
+		{
+			name: "deleted entry",
+			tamper: func(db *gorm.DB, entries []StateMachineLog) error {
+				return db.Unscoped().Delete(&entries[0]).Error
+			},
+			err: ErrChainBroken,
+		},
+		{
+			name: "soft deleted entry",
+			tamper: func(db *gorm.DB, entries []StateMachineLog) error {
+				return db.Delete(&entries[0]).Error
+			},
+		},
+		{
+			name: "deleted latest entry",
+			tamper: func(db *gorm.DB, entries []StateMachineLog) error {
+				return db.Unscoped().Delete(&entries[len(entries)-1]).Error
+			},
+		},
+		{name: "entries logged before the chain", unchained: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t, &chainedOrder{})
+			o := &chainedOrder{}
+			if err := db.Create(o).Error; err != nil {
+				t.Fatal(err)
+			}
+			for i, trigger := range []string{"pay", "ship"} {
+				if i == tt.unchained {
+					withHashChain(t)
+				}
+				if err := o.Do(db, trigger, 1); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.tamper != nil {
+				var entries []StateMachineLog
+				if err := db.Order("id").Find(&entries).Error; err != nil {
+					t.Fatal(err)
+				}
+				if err := tt.tamper(db, entries); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := VerifyChain(db, &chainedOrder{}, o.ID); !errors.Is(err, tt.err) {
+				t.Errorf("VerifyChain: %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestVerifyChainKey(t *testing.T) {
+	withHashChain(t)
+	db := newTestDB(t, &keyedOrder{})
+	a, b := &keyedOrder{ID: "a"}, &keyedOrder{ID: "b"}
+	for _, o := range []*keyedOrder{a, b} {
+		if err := db.Create(o).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, trigger := range []string{"pay", "ship"} {
+		for _, o := range []*keyedOrder{a, b} {
+			if err := o.Do(db, trigger, 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := db.Model(&StateMachineLog{}).Where("object_key = ? AND trigger = ?", "b", "pay").
+		Update("reason", "edited").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyChainKey(db, &keyedOrder{}, "a"); err != nil {
+		t.Errorf("VerifyChainKey of a: %v", err)
+	}
+	if err := VerifyChainKey(db, &keyedOrder{}, "b"); !errors.Is(err, ErrChainBroken) {
+		t.Errorf("VerifyChainKey of b: %v, want ErrChainBroken", err)
+	}
+}
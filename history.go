@@ -0,0 +1,177 @@
+package common
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DefaultHistoryPageSize is the number of entries of a HistoryPage by default.
+const DefaultHistoryPageSize = 50
+
+// HistoryOptions filters and paginates the StateMachineLog entries returned by
+// History and QueryHistory. Zero fields do not filter.
+type HistoryOptions struct {
+	// ObjectStruct and ObjectIds, or ObjectKeys for the keys that are not
+	// unsigned integers, restrict QueryHistory to objects; History sets them
+	// to its object.
+	ObjectStruct string
+	ObjectIds    []uint
+	ObjectKeys   []string
+	Triggers     []string
+	OperatorIds  []uint
+	// TenantId restricts the entries to a tenant, by default that of the
+	// context of tx in QueryHistory, see WithTenant.
+	TenantId string
+	// Since and Until bound the CreatedAt of the entries, Until excluded.
+	Since, Until time.Time
+	// Page is the page returned, from 1; PageSize is its number of entries,
+	// DefaultHistoryPageSize if 0.
+	Page, PageSize int
+	// Newest returns the latest entries first instead of the oldest.
+	Newest bool
+}
+
+type HistoryPage struct {
+	Entries  []StateMachineLog
+	Page     int
+	PageSize int
+	// Total is the number of entries matching the options on every page.
+	Total int64
+}
+
+// HasNext reports whether entries follow those of p.
+func (p *HistoryPage) HasNext() bool {
+	return int64(p.Page*p.PageSize) < p.Total
+}
+
+// History returns a page of the log of the bound object:
+//
+//	page, err := order.History(db, common.HistoryOptions{Triggers: []string{"pay", "refund"}, Newest: true})
+func (sm *StateMachine) History(tx *gorm.DB, opts HistoryOptions) (*HistoryPage, error) {
+	id, key, err := objectKeys(sm.stater)
+	if err != nil {
+		return nil, err
+	}
+	opts.ObjectStruct = StructName(sm.stater)
+	if id != 0 {
+		opts.ObjectIds = []uint{id}
+	} else {
+		opts.ObjectKeys = []string{key}
+	}
+	return QueryHistory(tx, opts)
+}
+
+// QueryHistory returns a page of the log of any objects.
+func QueryHistory(tx *gorm.DB, opts HistoryOptions) (*HistoryPage, error) {
+	if opts.Page < 1 {
+		opts.Page = 1
+	}
+	if opts.PageSize <= 0 {
+		opts.PageSize = DefaultHistoryPageSize
+	}
+	if opts.TenantId == "" {
+		opts.TenantId = TenantFromContext(contextOf(tx))
+	}
+	query := tx.Model(&StateMachineLog{}).Scopes(ScopeHistory(opts)).Session(&gorm.Session{})
+	page := &HistoryPage{Page: opts.Page, PageSize: opts.PageSize}
+	if err := query.Count(&page.Total).Error; err != nil {
+		return nil, fmt.Errorf("count history: %w", err)
+	}
+	order := "id"
+	if opts.Newest {
+		order = "id DESC"
+	}
+	if err := query.Order(order).Limit(opts.PageSize).Offset((opts.Page - 1) * opts.PageSize).
+		Find(&page.Entries).Error; err != nil {
+		return nil, fmt.Errorf("read history: %w", err)
+	}
+	return page, nil
+}
+
+// ScopeHistory restricts a query of StateMachineLog to the entries matching
+// the filters of opts, ignoring its pagination.
+func ScopeHistory(opts HistoryOptions) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if opts.ObjectStruct != "" {
+			db = db.Where("object_struct = ?", opts.ObjectStruct)
+		}
+		if len(opts.ObjectIds) > 0 {
+			db = db.Where("object_id IN ?", opts.ObjectIds)
+		}
+		if len(opts.ObjectKeys) > 0 {
+			db = db.Where("object_key IN ?", opts.ObjectKeys)
+		}
+		if len(opts.Triggers) > 0 {
+			// trigger is reserved by MySQL: let the dialect quote it.
+			triggers := make([]interface{}, len(opts.Triggers))
+			for i, trigger := range opts.Triggers {
+				triggers[i] = trigger
+			}
+			db = db.Where(clause.IN{Column: clause.Column{Name: "trigger"}, Values: triggers})
+		}
+		if len(opts.OperatorIds) > 0 {
+			db = db.Where("operator_id IN ?", opts.OperatorIds)
+		}
+		if opts.TenantId != "" {
+			db = db.Scopes(ScopeTenant(opts.TenantId))
+		}
+		if !opts.Since.IsZero() {
+			db = db.Where("created_at >= ?", opts.Since)
+		}
+		if !opts.Until.IsZero() {
+			db = db.Where("created_at < ?", opts.Until)
+		}
+		return db
+	}
+}
+
+// StateAt returns the state of the main region of the object of model's type
+// with ID id at the time at, from its log: the dest of the last entry written
+// by then, or the source of the first one written after. An object without
+// log entries has always been in its current state, and ErrNotCreated is
+// returned for times before its CreatedAt if it has one.
+func StateAt(tx *gorm.DB, model Stater, id uint, at time.Time) (string, error) {
+	return stateAt(tx, model, objectRef{id: id}, at)
+}
+
+// StateAtKey is StateAt for the objects whose primary key is not an unsigned
+// integer, see IDProvider.
+func StateAtKey(tx *gorm.DB, model Stater, key string, at time.Time) (string, error) {
+	return stateAt(tx, model, objectRef{key: key}, at)
+}
+
+func stateAt(tx *gorm.DB, model Stater, ref objectRef, at time.Time) (string, error) {
+	obj, ok := newStater(model)
+	if !ok {
+		return "", fmt.Errorf("%T is not a state machine model", model)
+	}
+	name := StructName(obj)
+	var entries []StateMachineLog
+	if err := ref.where(tx, name).Where(
+		"region = '' AND created_at <= ?", at,
+	).Order("id DESC").Limit(1).Find(&entries).Error; err != nil {
+		return "", fmt.Errorf("read log of %s %s: %w", name, ref, err)
+	}
+	if len(entries) > 0 {
+		return entries[0].Dest, nil
+	}
+
+	if err := ref.load(tx, obj); err != nil {
+		return "", fmt.Errorf("load %s %s: %w", name, ref, err)
+	}
+	if f := fieldOf(obj, "CreatedAt"); f.IsValid() {
+		if created, ok := f.Interface().(time.Time); ok && at.Before(created) {
+			return "", fmt.Errorf("%w: %s %s at %s", ErrNotCreated, name, ref, at.Format(time.RFC3339))
+		}
+	}
+	if err := ref.where(tx, name).Where("region = ''").Order("id").Limit(1).Find(&entries).Error; err != nil {
+		return "", fmt.Errorf("read log of %s %s: %w", name, ref, err)
+	}
+	if len(entries) > 0 {
+		return entries[0].Source, nil
+	}
+	return obj.GetState(), nil
+}
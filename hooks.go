@@ -0,0 +1,59 @@
+package common
+
+import (
+	"context"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// TransitionEvent describes a transition being performed by Do.
+type TransitionEvent struct {
+	Object     Stater
+	Region     string
+	Trigger    string
+	Source     string
+	Dest       string
+	OperatorId uint
+	Args       []interface{}
+	// LogId is the ID of the StateMachineLog of the transition, once written:
+	// it is 0 for the hooks of BeforeAnyTransition, and with the audit sinks
+	// not setting IDs.
+	LogId uint
+}
+
+type TransitionHook func(ctx context.Context, tx *gorm.DB, event *TransitionEvent) error
+
+var (
+	hooksMu     sync.RWMutex
+	beforeHooks []TransitionHook
+	afterHooks  []TransitionHook
+)
+
+// BeforeAnyTransition registers hook to run before every transition of every
+// machine, once its trigger has been accepted. An error aborts the transition.
+func BeforeAnyTransition(hook TransitionHook) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	beforeHooks = append(beforeHooks, hook)
+}
+
+// OnAnyTransition registers hook to run after every transition of every
+// machine has been stored and logged.
+func OnAnyTransition(hook TransitionHook) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	afterHooks = append(afterHooks, hook)
+}
+
+func runHooks(ctx context.Context, tx *gorm.DB, hooks *[]TransitionHook, event *TransitionEvent) error {
+	hooksMu.RLock()
+	registered := *hooks
+	hooksMu.RUnlock()
+	for _, hook := range registered {
+		if err := hook(ctx, tx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+// Package smhttp serves the triggers of a state machine model over REST:
+//
+//	GET  /objects/{id}/triggers         the available triggers, translated
+//	POST /objects/{id}/triggers/{name}  fires the trigger
+//
+// The triggers are translated in the language of the Accept-Language of the
+// request, if the default catalog has it, see common.MatchLanguage.
+//
+// The operator of a transition is taken from the request context, see
+// WithOperator, typically set by the authentication middleware:
+//
+//	mux.Handle("/orders/", http.StripPrefix("/orders", smhttp.NewHandler(db, &Order{})))
+package smhttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/language"
+	"gorm.io/gorm"
+
+	common "sm"
+)
+
+// ErrNoOperator is returned by the default Operator of a Handler for requests
+// without one.
+var ErrNoOperator = errors.New("no operator in request context")
+
+type operatorKey struct{}
+
+// WithOperator returns ctx carrying the ID of the user acting on the request.
+func WithOperator(ctx context.Context, operatorId uint) context.Context {
+	return context.WithValue(ctx, operatorKey{}, operatorId)
+}
+
+func OperatorFromContext(ctx context.Context) (uint, bool) {
+	operatorId, ok := ctx.Value(operatorKey{}).(uint)
+	return operatorId, ok
+}
+
+// machine is the part of the StateMachine API the handler uses, promoted to
+// the models embedding it.
+type machine interface {
+	common.Stater
+	AvailableTriggersCtx(ctx context.Context) []*common.AvailableTrigger
+	FireCtx(ctx context.Context, tx *gorm.DB, trigger string, userInfoId uint, args ...interface{}) (*common.TransitionResult, error)
+	Snapshot() *common.Snapshot
+}
+
+// Handler serves the objects of one model type.
+type Handler struct {
+	db    *gorm.DB
+	model reflect.Type
+	// Operator returns the operator of a request, by default the one stored in
+	// its context by WithOperator.
+	Operator func(r *http.Request) (uint, error)
+	// Args decodes the trigger arguments of a POST, none by default.
+	Args func(r *http.Request) ([]interface{}, error)
+}
+
+// NewHandler returns the handler of the objects of the type of model, which
+// may be a zero value.
+func NewHandler(db *gorm.DB, model common.Stater) *Handler {
+	t := reflect.TypeOf(model)
+	if t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("smhttp: %T is not a pointer to a model", model))
+	}
+	if _, ok := model.(machine); !ok {
+		panic(fmt.Sprintf("smhttp: %T does not embed StateMachine", model))
+	}
+	return &Handler{
+		db:    db,
+		model: t.Elem(),
+		Operator: func(r *http.Request) (uint, error) {
+			if operatorId, ok := OperatorFromContext(r.Context()); ok {
+				return operatorId, nil
+			}
+			return 0, ErrNoOperator
+		},
+	}
+}
+
+// FireResponse is the body of a successful POST.
+type FireResponse struct {
+	Result *common.TransitionResult
+	Object *common.Snapshot
+}
+
+type errorResponse struct {
+	Error string
+	// Message is Error for end users, translated, see common.MessageOf.
+	Message string `json:",omitempty"`
+	Reason  string `json:",omitempty"`
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if lang := common.MatchLanguage(r.Header.Get("Accept-Language")); lang != language.Und {
+		r = r.WithContext(common.WithLanguage(r.Context(), lang))
+	}
+	// objects/{id}/triggers[/{name}]
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 3 || len(parts) > 4 || parts[0] != "objects" || parts[2] != "triggers" {
+		http.NotFound(w, r)
+		return
+	}
+	id, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid id %q", parts[1]))
+		return
+	}
+
+	switch {
+	case len(parts) == 3 && r.Method == http.MethodGet:
+		obj, err := h.load(r.Context(), uint(id))
+		if err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, obj.AvailableTriggersCtx(r.Context()))
+	case len(parts) == 4 && r.Method == http.MethodPost:
+		h.fire(w, r, uint(id), parts[3])
+	case len(parts) == 3:
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	default:
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+func (h *Handler) fire(w http.ResponseWriter, r *http.Request, id uint, trigger string) {
+	operatorId, err := h.Operator(r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	var args []interface{}
+	if h.Args != nil {
+		if args, err = h.Args(r); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	obj, err := h.load(r.Context(), id)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	result, err := obj.FireCtx(r.Context(), h.db.WithContext(r.Context()), trigger, operatorId, args...)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, &FireResponse{Result: result, Object: obj.Snapshot()})
+}
+
+func (h *Handler) load(ctx context.Context, id uint) (machine, error) {
+	obj := reflect.New(h.model).Interface().(machine)
+	if err := h.db.WithContext(ctx).First(obj, id).Error; err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// statusOf maps the errors of Do to HTTP statuses.
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound), errors.Is(err, common.ErrTriggerNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, common.ErrGuardRejected):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, common.ErrInvalidSourceState), errors.Is(err, common.ErrFinalState),
+		errors.Is(err, common.ErrSelfTransition), errors.Is(err, common.ErrNestedTransition),
+		errors.Is(err, common.ErrConcurrentModification), errors.Is(err, common.ErrLockNotAcquired):
+		return http.StatusConflict
+	case errors.Is(err, common.ErrArgumentType):
+		return http.StatusBadRequest
+	case errors.Is(err, common.ErrObjectDeleted):
+		return http.StatusGone
+	}
+	return http.StatusInternalServerError
+}
+
+// writeError reports err, but for internal errors, which are not disclosed.
+func writeError(w http.ResponseWriter, status int, err error) {
+	body := errorResponse{Error: err.Error(), Message: common.MessageOf(err)}
+	if status >= http.StatusInternalServerError {
+		body.Error, body.Message = http.StatusText(status), ""
+	}
+	var guard *common.GuardError
+	if errors.As(err, &guard) {
+		body.Reason = guard.Reason
+	}
+	writeJSON(w, status, body)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
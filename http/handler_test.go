@@ -0,0 +1,85 @@
+package smhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gorm.io/gorm"
+
+	common "sm"
+)
+
+func TestStatusOf(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{gorm.ErrRecordNotFound, http.StatusNotFound},
+		{fmt.Errorf("%w: refund", common.ErrTriggerNotFound), http.StatusNotFound},
+		{&common.GuardError{Trigger: "pay", Reason: "insufficient balance"}, http.StatusUnprocessableEntity},
+		{&common.TriggerError{Err: common.ErrInvalidSourceState, Trigger: "ship", State: "INITIALIZED"}, http.StatusConflict},
+		{&common.TriggerError{Err: common.ErrFinalState, Trigger: "ship", State: "CANCELLED"}, http.StatusConflict},
+		{common.ErrSelfTransition, http.StatusConflict},
+		{common.ErrNestedTransition, http.StatusConflict},
+		{common.ErrConcurrentModification, http.StatusConflict},
+		{common.ErrLockNotAcquired, http.StatusConflict},
+		{common.ErrArgumentType, http.StatusBadRequest},
+		{common.ErrObjectDeleted, http.StatusGone},
+		{errors.New("connection refused"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			if got := statusOf(tt.err); got != tt.want {
+				t.Errorf("statusOf(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want errorResponse
+	}{
+		{
+			name: "guard",
+			err:  &common.GuardError{Trigger: "pay", Reason: "insufficient balance"},
+			want: errorResponse{
+				Error:   "guard rejected: pay: insufficient balance",
+				Message: "guard rejected: pay: insufficient balance",
+				Reason:  "insufficient balance",
+			},
+		},
+		{
+			name: "trigger",
+			err:  &common.TriggerError{Err: common.ErrInvalidSourceState, Trigger: "ship", State: "INITIALIZED", Message: "cannot ship yet"},
+			want: errorResponse{
+				Error:   "invalid source state: can not do trigger ship from INITIALIZED",
+				Message: "cannot ship yet",
+			},
+		},
+		{
+			name: "internal",
+			err:  errors.New("dial tcp 10.0.0.1:5432: connection refused"),
+			want: errorResponse{Error: http.StatusText(http.StatusInternalServerError)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			writeError(w, statusOf(tt.err), tt.err)
+			var got errorResponse
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("writeError body %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package smkafka
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	common "sm"
+)
+
+// Reader reads messages from Kafka, e.g. a *kafka.Reader with a GroupID.
+type Reader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// MaxRetryDelay bounds the delay between the attempts of Consume at a
+// message failing transiently.
+var MaxRetryDelay = time.Minute
+
+// Consume hands the messages of r to c until ctx is done, committing each
+// once handled. Messages without an Id are deduplicated by topic, partition
+// and offset. A message failing for good, see common.IsPermanent, is reported
+// to onError, which may be nil, and skipped; otherwise it is retried with a
+// growing delay, keeping the order of its partition.
+func Consume(ctx context.Context, r Reader, c *common.Consumer, onError func(kafka.Message, error)) error {
+	for {
+		m, err := r.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("fetch message: %w", err)
+		}
+		id := fmt.Sprintf("kafka:%s/%d/%d", m.Topic, m.Partition, m.Offset)
+		for delay := time.Second; ; delay *= 2 {
+			err := c.HandleData(ctx, m.Value, id)
+			if err == nil {
+				break
+			}
+			if onError != nil {
+				onError(m, err)
+			}
+			if common.IsPermanent(err) {
+				break
+			}
+			if delay > MaxRetryDelay {
+				delay = MaxRetryDelay
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(delay):
+			}
+		}
+		if err := r.CommitMessages(ctx, m); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("commit message: %w", err)
+		}
+	}
+}
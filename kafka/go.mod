@@ -0,0 +1,29 @@
+module sm/kafka
+
+go 1.23.0
+
+require (
+	github.com/segmentio/kafka-go v0.4.51
+	google.golang.org/protobuf v1.36.11
+	sm v0.0.0
+	sm/grpc v0.0.0
+)
+
+require (
+	github.com/jinzhu/inflection v1.0.0 // indirect
+	github.com/jinzhu/now v1.1.2 // indirect
+	github.com/klauspost/compress v1.15.9 // indirect
+	github.com/pierrec/lz4/v4 v4.1.15 // indirect
+	golang.org/x/net v0.38.0 // indirect
+	golang.org/x/sys v0.31.0 // indirect
+	golang.org/x/text v0.23.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20241015192408-796eee8c2d53 // indirect
+	google.golang.org/grpc v1.69.4 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+	gorm.io/gorm v1.22.3 // indirect
+)
+
+replace (
+	sm => ../
+	sm/grpc => ../grpc
+)
@@ -0,0 +1,107 @@
+// Package smkafka publishes the transitions of state machine models to Kafka:
+//
+//	w := &kafka.Writer{Addr: kafka.TCP("localhost:9092"), Balancer: &kafka.Hash{}}
+//	p := smkafka.NewPublisher(w, "transitions")
+//	defer sm.PublishTo(p, nil)()
+//
+// or, to publish the committed transitions only, through the outbox table:
+//
+//	sm.PublishThroughOutbox("kafka", p)
+//	go sm.RunOutbox(ctx, db, time.Second, nil)
+//
+// Messages are keyed by "<ObjectStruct>:<ObjectKey>", so that the transitions
+// of an object stay ordered with a hashing balancer.
+package smkafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"google.golang.org/protobuf/proto"
+
+	common "sm"
+	smgrpc "sm/grpc"
+)
+
+// Encoding is the format of the messages.
+type Encoding int
+
+const (
+	// JSON encodes common.EventPayload.
+	JSON Encoding = iota
+	// Protobuf encodes smpb.TransitionEvent.
+	Protobuf
+)
+
+// Writer writes messages to Kafka, e.g. a *kafka.Writer.
+type Writer interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// Publisher is a common.Publisher writing each transition as a message.
+type Publisher struct {
+	writer Writer
+	// Topic returns the topic of the message of an event. It may be nil if
+	// the writer has a topic of its own.
+	Topic    func(p *common.EventPayload) string
+	Encoding Encoding
+	// Timeout bounds the write of each message, 10s by default; with an
+	// asynchronous kafka.Writer writes return at once. Messages are written
+	// by the worker of common.PublishTo or by common.RelayOutbox, never
+	// within a transition.
+	Timeout time.Duration
+}
+
+// NewPublisher returns a publisher of JSON messages to topic, or to the topic
+// of w if empty.
+func NewPublisher(w Writer, topic string) *Publisher {
+	p := &Publisher{writer: w, Timeout: 10 * time.Second}
+	if topic != "" {
+		p.Topic = func(*common.EventPayload) string { return topic }
+	}
+	return p
+}
+
+func (p *Publisher) Publish(ctx context.Context, event common.TransitionEvent) error {
+	payload, err := common.NewEventPayload(event)
+	if err != nil {
+		return err
+	}
+	return p.PublishPayload(ctx, payload)
+}
+
+// PublishPayload writes the message of payload, for common.RelayOutbox.
+func (p *Publisher) PublishPayload(ctx context.Context, payload *common.EventPayload) error {
+	var err error
+	msg := kafka.Message{
+		Key:     []byte(payload.ObjectStruct + ":" + payload.ObjectKey),
+		Headers: []kafka.Header{{Key: "trigger", Value: []byte(payload.Trigger)}},
+		Time:    payload.Timestamp,
+	}
+	if p.Topic != nil {
+		msg.Topic = p.Topic(payload)
+	}
+	switch p.Encoding {
+	case JSON:
+		msg.Value, err = json.Marshal(payload)
+	case Protobuf:
+		msg.Value, err = proto.Marshal(smgrpc.EventProto(payload))
+	default:
+		err = fmt.Errorf("smkafka: unknown encoding %d", p.Encoding)
+	}
+	if err != nil {
+		return err
+	}
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+	if err := p.writer.WriteMessages(ctx, msg); err != nil {
+		return fmt.Errorf("publish %s of %s: %w", payload.Trigger, string(msg.Key), err)
+	}
+	return nil
+}
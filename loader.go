@@ -0,0 +1,303 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefinitionSpec is the file form of a Definition, in JSON or YAML:
+//
+//	name: Order
+//	states: [INITIALIZED, PAID, SHIPPED]
+//	final: [SHIPPED]
+//	triggers:
+//	  - name: pay
+//	    source: INITIALIZED
+//	    dest: PAID
+//	    condition: hasBalance
+//	    after: notifyCustomer
+//
+// Callbacks, conditions and guards are names registered with
+// RegisterCallback, RegisterCondition and RegisterGuard, looked up when they
+// run.
+type DefinitionSpec struct {
+	Name      string               `json:"name" yaml:"name"`
+	Initial   string               `json:"initial,omitempty" yaml:"initial,omitempty"`
+	States    StateList            `json:"states" yaml:"states"`
+	Final     StateList            `json:"final,omitempty" yaml:"final,omitempty"`
+	SubStates map[string]StateList `json:"substates,omitempty" yaml:"substates,omitempty"`
+	Tags      map[string]StateList `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Groups    map[string]StateList `json:"groups,omitempty" yaml:"groups,omitempty"`
+	OnEnter   map[string]StateList `json:"on_enter,omitempty" yaml:"on_enter,omitempty"`
+	OnExit    map[string]StateList `json:"on_exit,omitempty" yaml:"on_exit,omitempty"`
+	Triggers  []TriggerSpec        `json:"triggers" yaml:"triggers"`
+	Regions   []RegionSpec         `json:"regions,omitempty" yaml:"regions,omitempty"`
+	SLAs      []SLASpec            `json:"slas,omitempty" yaml:"slas,omitempty"`
+}
+
+type TriggerSpec struct {
+	Name       string       `json:"name" yaml:"name"`
+	Source     StateList    `json:"source" yaml:"source"`
+	Except     StateList    `json:"except,omitempty" yaml:"except,omitempty"`
+	Dest       string       `json:"dest,omitempty" yaml:"dest,omitempty"`
+	Branches   []BranchSpec `json:"branches,omitempty" yaml:"branches,omitempty"`
+	Before     string       `json:"before,omitempty" yaml:"before,omitempty"`
+	After      string       `json:"after,omitempty" yaml:"after,omitempty"`
+	Condition  string       `json:"condition,omitempty" yaml:"condition,omitempty"`
+	Guard      string       `json:"guard,omitempty" yaml:"guard,omitempty"`
+	Internal   bool         `json:"internal,omitempty" yaml:"internal,omitempty"`
+	Reentrant  bool         `json:"reentrant,omitempty" yaml:"reentrant,omitempty"`
+	Defer      bool         `json:"defer,omitempty" yaml:"defer,omitempty"`
+	Priority   int          `json:"priority,omitempty" yaml:"priority,omitempty"`
+	IgnoreIfIn StateList    `json:"ignore_if_in,omitempty" yaml:"ignore_if_in,omitempty"`
+	Aliases    StateList    `json:"aliases,omitempty" yaml:"aliases,omitempty"`
+	// Metadata uses the keys of the map form, e.g. display_name.
+	Metadata map[string]interface{} `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+}
+
+// BranchSpec is a Branch whose guard is a registered condition.
+type BranchSpec struct {
+	Name  string `json:"name,omitempty" yaml:"name,omitempty"`
+	Guard string `json:"guard" yaml:"guard"`
+	Dest  string `json:"dest" yaml:"dest"`
+}
+
+// SLASpec is an SLA whose duration is written like "48h".
+type SLASpec struct {
+	State    string `json:"state" yaml:"state"`
+	Within   string `json:"within" yaml:"within"`
+	Escalate string `json:"escalate,omitempty" yaml:"escalate,omitempty"`
+}
+
+type RegionSpec struct {
+	Name       string         `json:"name" yaml:"name"`
+	Field      string         `json:"field" yaml:"field"`
+	Definition DefinitionSpec `json:"definition" yaml:"definition"`
+}
+
+// StateList is a list of names, written as a list or as a comma separated
+// string.
+type StateList []string
+
+func splitNames(s string) StateList {
+	var names StateList
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (l *StateList) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*l = splitNames(s)
+		return nil
+	}
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return fmt.Errorf("expected a string or a list of strings: %w", err)
+	}
+	*l = names
+	return nil
+}
+
+func (l *StateList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*l = splitNames(node.Value)
+		return nil
+	}
+	var names []string
+	if err := node.Decode(&names); err != nil {
+		return err
+	}
+	*l = names
+	return nil
+}
+
+// Build compiles the spec, see DefinitionBuilder.Build. Its errors match
+// ErrInvalidDefinition.
+func (spec *DefinitionSpec) Build() (*Definition, error) {
+	b := NewDefinition(spec.Name).State(spec.States...).Final(spec.Final...)
+	if spec.Initial != "" {
+		b.Initial(spec.Initial)
+	}
+	for _, parent := range sortedKeys(spec.SubStates) {
+		b.SubStates(parent, spec.SubStates[parent]...)
+	}
+	for _, state := range sortedKeys(spec.Tags) {
+		b.Tag(state, spec.Tags[state]...)
+	}
+	for _, group := range sortedKeys(spec.Groups) {
+		b.Group(group, spec.Groups[group]...)
+	}
+	for _, state := range sortedKeys(spec.OnEnter) {
+		for _, name := range spec.OnEnter[state] {
+			b.OnEnter(state, NamedCallback(name))
+		}
+	}
+	for _, state := range sortedKeys(spec.OnExit) {
+		for _, name := range spec.OnExit[state] {
+			b.OnExit(state, NamedCallback(name))
+		}
+	}
+	for _, region := range spec.Regions {
+		definition, err := region.Definition.Build()
+		if err != nil {
+			return nil, fmt.Errorf("region %s: %w", region.Name, err)
+		}
+		b.Region(region.Name, region.Field, definition)
+	}
+	for _, t := range spec.Triggers {
+		source, except, err := parseSource([]string(t.Source))
+		if err != nil {
+			return nil, fmt.Errorf("%w: trigger %s: %v", ErrInvalidDefinition, t.Name, err)
+		}
+		metadata, err := triggerMetadataFromMap(t.Metadata)
+		if err != nil {
+			return nil, fmt.Errorf("%w: trigger %s: %v", ErrInvalidDefinition, t.Name, err)
+		}
+		b.Trigger(t.Name).From(source...).Except(except...).Except(t.Except...).
+			IgnoreIfIn(t.IgnoreIfIn...).Alias(t.Aliases...).Priority(t.Priority).Metadata(metadata)
+		if t.Dest != "" {
+			b.To(t.Dest)
+		}
+		for _, branch := range t.Branches {
+			b.Branch(branch.Name, branch.Dest, NamedCondition(branch.Guard))
+		}
+		if t.Before != "" {
+			b.Before(NamedCallback(t.Before))
+		}
+		if t.After != "" {
+			b.After(NamedCallback(t.After))
+		}
+		if t.Condition != "" {
+			b.Condition(NamedCondition(t.Condition))
+		}
+		if t.Guard != "" {
+			b.Guard(NamedGuard(t.Guard))
+		}
+		if t.Internal {
+			b.Internal()
+		}
+		if t.Reentrant {
+			b.Reentrant()
+		}
+		if t.Defer {
+			b.Deferrable()
+		}
+	}
+	for _, sla := range spec.SLAs {
+		within, err := time.ParseDuration(sla.Within)
+		if err != nil {
+			return nil, fmt.Errorf("%w: SLA of %s: %v", ErrInvalidDefinition, sla.State, err)
+		}
+		b.SLA(sla.State, within, sla.Escalate)
+	}
+	return b.Build()
+}
+
+// ParseDefinition compiles a definition written in format "json", "yaml" or
+// "sm", the transition table of ParseDSL.
+func ParseDefinition(data []byte, format string) (*Definition, error) {
+	spec, err := ParseDefinitionSpec(data, format)
+	if err != nil {
+		return nil, err
+	}
+	return spec.Build()
+}
+
+// ParseDefinitionSpec is ParseDefinition without compiling the spec, e.g. for
+// code generation.
+func ParseDefinitionSpec(data []byte, format string) (*DefinitionSpec, error) {
+	var spec DefinitionSpec
+	switch strings.ToLower(format) {
+	case "json":
+		if err := json.Unmarshal(data, &spec); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidDefinition, err)
+		}
+	case "yaml", "yml":
+		if err := yaml.Unmarshal(data, &spec); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidDefinition, err)
+		}
+	case "sm":
+		return parseDSL(string(data))
+	default:
+		return nil, fmt.Errorf("unknown definition format %q", format)
+	}
+	return &spec, nil
+}
+
+// LoadDefinition compiles the definition file at path, JSON, YAML or a .sm
+// transition table according to its extension.
+func LoadDefinition(path string) (*Definition, error) {
+	spec, err := LoadDefinitionSpec(path)
+	if err != nil {
+		return nil, err
+	}
+	definition, err := spec.Build()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return definition, nil
+}
+
+func LoadDefinitionSpec(path string) (*DefinitionSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := ParseDefinitionSpec(data, strings.TrimPrefix(filepath.Ext(path), "."))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return spec, nil
+}
+
+// registered holds the definitions bound to model types by
+// RegisterDefinition.
+var registered sync.Map
+
+// RegisterDefinition binds definition to the type of model, for models that
+// neither implement Definer nor declare triggers, e.g. with a definition
+// loaded from a file at startup:
+//
+//	RegisterDefinition(&Order{}, MustLoadDefinition("order.yaml"))
+func RegisterDefinition(model Stater, definition *Definition) {
+	registered.Store(reflect.TypeOf(model), definition)
+}
+
+func MustLoadDefinition(path string) *Definition {
+	definition, err := LoadDefinition(path)
+	if err != nil {
+		panic(err)
+	}
+	return definition
+}
+
+func registeredDefinition(stater Stater) (*Definition, bool) {
+	definition, ok := registered.Load(reflect.TypeOf(stater))
+	if !ok {
+		return nil, false
+	}
+	return definition.(*Definition), true
+}
+
+func sortedKeys(m map[string]StateList) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,158 @@
+package common
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseDefinitionSpec(t *testing.T) {
+	want := &DefinitionSpec{
+		Name:   "Order",
+		States: StateList{"INITIALIZED", "PAID", "CANCELLED"},
+		Final:  StateList{"CANCELLED"},
+		Triggers: []TriggerSpec{
+			{Name: "pay", Source: StateList{"INITIALIZED"}, Dest: "PAID", After: "notifyCustomer"},
+			{Name: "cancel", Source: StateList{"INITIALIZED", "PAID"}, Dest: "CANCELLED", IgnoreIfIn: StateList{"CANCELLED"}},
+		},
+	}
+	tests := []struct {
+		name   string
+		format string
+		data   string
+		want   *DefinitionSpec
+		err    error
+	}{
+		{
+			name:   "yaml lists",
+			format: "yaml",
+			data: `
+name: Order
+states: [INITIALIZED, PAID, CANCELLED]
+final: [CANCELLED]
+triggers:
+  - {name: pay, source: [INITIALIZED], dest: PAID, after: notifyCustomer}
+  - {name: cancel, source: [INITIALIZED, PAID], dest: CANCELLED, ignore_if_in: [CANCELLED]}
+`,
+			want: want,
+		},
+		{
+			name:   "yaml comma separated names",
+			format: "yml",
+			data: `
+name: Order
+states: INITIALIZED, PAID, CANCELLED
+final: CANCELLED
+triggers:
+  - {name: pay, source: INITIALIZED, dest: PAID, after: notifyCustomer}
+  - {name: cancel, source: "INITIALIZED, PAID", dest: CANCELLED, ignore_if_in: CANCELLED}
+`,
+			want: want,
+		},
+		{
+			name:   "json",
+			format: "JSON",
+			data: `{
+  "name": "Order",
+  "states": ["INITIALIZED", "PAID", "CANCELLED"],
+  "final": "CANCELLED",
+  "triggers": [
+    {"name": "pay", "source": "INITIALIZED", "dest": "PAID", "after": "notifyCustomer"},
+    {"name": "cancel", "source": ["INITIALIZED", "PAID"], "dest": "CANCELLED", "ignore_if_in": "CANCELLED"}
+  ]
+}`,
+			want: want,
+		},
+		{name: "malformed yaml", format: "yaml", data: "states: [INITIALIZED", err: ErrInvalidDefinition},
+		{name: "malformed json", format: "json", data: `{"states": 1}`, err: ErrInvalidDefinition},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := ParseDefinitionSpec([]byte(tt.data), tt.format)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("ParseDefinitionSpec: %v, want %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(spec, tt.want) {
+				t.Errorf("ParseDefinitionSpec = %+v, want %+v", spec, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDefinitionUnknownFormat(t *testing.T) {
+	if _, err := ParseDefinition([]byte("name: Order"), "toml"); err == nil {
+		t.Error("ParseDefinition of a toml file succeeded")
+	}
+}
+
+func TestParseDefinition(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		states   []string
+		triggers []string
+		err      error
+	}{
+		{
+			name: "builds the definition",
+			data: `
+name: Order
+states: [INITIALIZED, PAID, CANCELLED]
+triggers:
+  - {name: pay, source: INITIALIZED, dest: PAID}
+  - {name: cancel, source: "*!CANCELLED", dest: CANCELLED}
+`,
+			states:   []string{"INITIALIZED", "PAID", "CANCELLED"},
+			triggers: []string{"pay", "cancel"},
+		},
+		{
+			name: "refuses unknown states",
+			data: `
+name: Order
+states: [INITIALIZED]
+triggers:
+  - {name: pay, source: INITIALIZED, dest: PAID}
+`,
+			err: ErrInvalidDefinition,
+		},
+		{
+			name: "refuses triggers without source",
+			data: `
+name: Order
+states: [INITIALIZED, PAID]
+triggers:
+  - {name: pay, source: "", dest: PAID}
+`,
+			err: ErrInvalidDefinition,
+		},
+		{
+			name: "refuses malformed SLA durations",
+			data: `
+name: Order
+states: [INITIALIZED, PAID]
+triggers:
+  - {name: pay, source: INITIALIZED, dest: PAID}
+slas:
+  - {state: INITIALIZED, within: two days}
+`,
+			err: ErrInvalidDefinition,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			definition, err := ParseDefinition([]byte(tt.data), "yaml")
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("ParseDefinition: %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(definition.States(), tt.states) {
+				t.Errorf("states %v, want %v", definition.States(), tt.states)
+			}
+			if !reflect.DeepEqual(definition.Triggers(), tt.triggers) {
+				t.Errorf("triggers %v, want %v", definition.Triggers(), tt.triggers)
+			}
+		})
+	}
+}
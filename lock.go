@@ -0,0 +1,130 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type rowLockKey struct{}
+
+// WithRowLock returns ctx making the transitions run with it by DoCtx or
+// BatchDo re-read the states of their objects with SELECT ... FOR UPDATE
+// before validating the trigger, so that transitions racing across instances
+// are serialized instead of both passing validation on stale states. The lock
+// is held until the transaction of tx ends: it is pointless outside of one.
+// SQLite ignores it.
+func WithRowLock(ctx context.Context) context.Context {
+	return context.WithValue(ctx, rowLockKey{}, true)
+}
+
+func rowLockFromContext(ctx context.Context) bool {
+	lock, _ := ctx.Value(rowLockKey{}).(bool)
+	return lock
+}
+
+// lockRow reloads the state and version fields of stater, locking its row, if
+// ctx asks for it with WithRowLock. They are reloaded without locking under a
+// LockProvider, which the transition holds.
+func lockRow(ctx context.Context, tx *gorm.DB, definition *Definition, stater Stater) error {
+	if !persisting(ctx) {
+		return nil
+	}
+	if rowLockFromContext(ctx) {
+		tx = tx.Clauses(clause.Locking{Strength: "UPDATE"})
+	} else if currentLockProvider() == nil {
+		return nil
+	}
+	var columns []string
+	for _, r := range definition.allRegions() {
+		columns = append(columns, r.column(stater))
+	}
+	if f := versionFieldOf(stater); f != nil {
+		columns = append(columns, f.name)
+	}
+	if err := tx.Select(columns).Take(stater).Error; err != nil {
+		key, _ := primaryKey(stater)
+		return fmt.Errorf("lock %s %s: %w", StructName(stater), key, err)
+	}
+	return nil
+}
+
+// LockProvider provides locks shared by the instances of an application, to
+// serialize the transitions of an object across them when their database
+// cannot, see SetLockProvider.
+type LockProvider interface {
+	// Lock waits until it holds the lock named key, or ctx is done, and
+	// returns the func releasing it. It returns an error matching
+	// ErrLockNotAcquired if another holds it.
+	Lock(ctx context.Context, key string) (unlock func() error, err error)
+}
+
+// LockFunc adapts a func to a LockProvider.
+type LockFunc func(ctx context.Context, key string) (unlock func() error, err error)
+
+func (f LockFunc) Lock(ctx context.Context, key string) (func() error, error) {
+	return f(ctx, key)
+}
+
+var (
+	lockProviderMu sync.RWMutex
+	lockProvider   LockProvider
+)
+
+// SetLockProvider makes Do acquire the lock of each object, named by LockKey,
+// before validating its trigger, and reload its state and version, so that
+// the instances sharing provider transition it one at a time. The lock is
+// released when Do returns, possibly before its transaction commits: the
+// state UPDATE still fails with ErrConcurrentModification on changes not yet
+// committed then. nil, the default, disables the locks.
+func SetLockProvider(provider LockProvider) {
+	lockProviderMu.Lock()
+	defer lockProviderMu.Unlock()
+	lockProvider = provider
+}
+
+func currentLockProvider() LockProvider {
+	lockProviderMu.RLock()
+	defer lockProviderMu.RUnlock()
+	return lockProvider
+}
+
+// LockKey returns the name of the lock of stater, "sm:Order:12".
+func LockKey(stater Stater) (string, error) {
+	key, err := primaryKey(stater)
+	if err != nil {
+		return "", err
+	}
+	return "sm:" + StructName(stater) + ":" + key, nil
+}
+
+type heldLockKey struct{ key string }
+
+// acquireLock acquires the lock of stater from the LockProvider, if any and
+// not held by ctx already, e.g. for the pending triggers of a transition. It
+// returns ctx holding it and the func releasing it.
+func acquireLock(ctx context.Context, stater Stater) (context.Context, func(), error) {
+	provider := currentLockProvider()
+	if provider == nil || !persisting(ctx) {
+		return ctx, func() {}, nil
+	}
+	key, err := LockKey(stater)
+	if err != nil {
+		return ctx, nil, err
+	}
+	if ctx.Value(heldLockKey{key}) != nil {
+		return ctx, func() {}, nil
+	}
+	unlock, err := provider.Lock(ctx, key)
+	if err != nil {
+		return ctx, nil, fmt.Errorf("lock %s: %w", key, err)
+	}
+	return context.WithValue(ctx, heldLockKey{key}, true), func() {
+		if err := unlock(); err != nil {
+			currentLogger().Warn("unlock", "key", key, "error", err)
+		}
+	}, nil
+}
@@ -0,0 +1,64 @@
+package common
+
+import (
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// Logger receives the messages of the state machines, with alternating keys
+// and values. A *slog.Logger is a Logger.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{}) {}
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Warn(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
+
+var (
+	loggerMu sync.RWMutex
+	logger   Logger = nopLogger{}
+	debugSQL bool
+)
+
+// SetLogger sets the Logger of every machine; nil, the default, discards the
+// messages. Transitions are logged at debug level.
+func SetLogger(l Logger) {
+	loggerMu.Lock()
+	defer loggerMu.Unlock()
+	if l == nil {
+		l = nopLogger{}
+	}
+	logger = l
+}
+
+// DebugSQL sets whether the statements of transitions are logged by the
+// logger of gorm whatever its level, see gorm.DB.Debug.
+func DebugSQL(enabled bool) {
+	loggerMu.Lock()
+	defer loggerMu.Unlock()
+	debugSQL = enabled
+}
+
+func currentLogger() Logger {
+	loggerMu.RLock()
+	defer loggerMu.RUnlock()
+	return logger
+}
+
+// sqlDebug returns tx, in debug mode if DebugSQL is enabled.
+func sqlDebug(tx *gorm.DB) *gorm.DB {
+	loggerMu.RLock()
+	defer loggerMu.RUnlock()
+	if debugSQL {
+		return tx.Debug()
+	}
+	return tx
+}
@@ -0,0 +1,210 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// MemoryStore is a Store keeping the states and the log of the objects in
+// memory, for the unit tests of workflows, their guards, callbacks and
+// available triggers, without database:
+//
+//	store := common.NewMemoryStore()
+//	common.SetStore(store)
+//	defer common.SetStore(nil)
+//	order := &Order{}
+//	store.Create(order)
+//	err := order.Do(common.MemoryDB(), "pay", userId)
+//	fmt.Println(store.Path(order)) // [INITIALIZED PAID]
+//
+// It has no transactions: the changes of the transitions failing after their
+// update, e.g. in an After callback, stay stored.
+type MemoryStore struct {
+	mu        sync.Mutex
+	rows      map[string]*memoryRow
+	entries   []StateMachineLog
+	lastId    uint
+	lastLogId uint
+}
+
+// memoryRow is the stored state of an object.
+type memoryRow struct {
+	states  map[string]string
+	version int64
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{rows: map[string]*memoryRow{}}
+}
+
+// MemoryDB returns a *gorm.DB without database, for the transitions stored
+// by a MemoryStore. Its queries do nothing, so the features reading the log
+// or the models with gorm, like history states, Revert or deferred triggers,
+// do not work with it.
+func MemoryDB() *gorm.DB {
+	db, _ := gorm.Open(nil, &gorm.Config{Logger: gormlogger.Discard})
+	return db
+}
+
+// Create gives objects their initial states and an ID, unless they have one,
+// as gorm would, and stores them.
+func (s *MemoryStore) Create(objects ...Stater) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, obj := range objects {
+		if err := initialize(obj); err != nil {
+			return err
+		}
+		if id, err := objectId(obj); err == nil && id == 0 {
+			if field, err := primaryField(obj); err == nil && field.CanSet() {
+				s.lastId++
+				if field.CanUint() {
+					field.SetUint(uint64(s.lastId))
+				} else {
+					field.SetInt(int64(s.lastId))
+				}
+			}
+		}
+		key, err := s.keyOf(obj)
+		if err != nil {
+			return err
+		}
+		row := &memoryRow{states: map[string]string{}}
+		if definition, err := definitionOf(obj); err == nil {
+			for _, r := range definition.allRegions() {
+				row.states[r.column(obj)] = r.state(obj)
+			}
+		}
+		if f := versionFieldOf(obj); f != nil {
+			row.version = versionOf(f.of(obj))
+		}
+		s.rows[key] = row
+	}
+	return nil
+}
+
+func (s *MemoryStore) keyOf(obj Stater) (string, error) {
+	key, err := primaryKey(obj)
+	if err != nil {
+		return "", err
+	}
+	return StructName(obj) + ":" + key, nil
+}
+
+func versionOf(v reflect.Value) int64 {
+	if v.CanInt() {
+		return v.Int()
+	}
+	return int64(v.Uint())
+}
+
+// UpdateState stores the state of the change, provided the stored state and
+// version are those it was made from. Objects not created in s are stored.
+func (s *MemoryStore) UpdateState(_ context.Context, _ *gorm.DB, u StateUpdate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, err := s.keyOf(u.Object)
+	if err != nil {
+		return err
+	}
+	row := s.rows[key]
+	if row == nil {
+		row = &memoryRow{states: map[string]string{}}
+		if u.VersionField != "" {
+			row.version = versionOf(reflect.ValueOf(u.Version))
+		}
+		s.rows[key] = row
+	}
+	if state, ok := row.states[u.Field]; ok && state != u.Source ||
+		u.VersionField != "" && row.version != versionOf(reflect.ValueOf(u.Version)) {
+		return fmt.Errorf("%w: %s changed since it was read", ErrConcurrentModification, key)
+	}
+	row.states[u.Field] = u.Dest
+	if u.VersionField != "" {
+		row.version++
+	}
+	return nil
+}
+
+// Write appends the entries to the log, setting their IDs and timestamps.
+func (s *MemoryStore) Write(_ context.Context, _ *gorm.DB, entries []*StateMachineLog) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, entry := range entries {
+		s.lastLogId++
+		entry.ID = s.lastLogId
+		if entry.CreatedAt.IsZero() {
+			entry.CreatedAt = time.Now()
+		}
+		entry.UpdatedAt = entry.CreatedAt
+		s.entries = append(s.entries, *entry)
+	}
+	return nil
+}
+
+// State returns the stored state of the main region of obj, empty if it is
+// not stored.
+func (s *MemoryStore) State(obj Stater) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, err := s.keyOf(obj)
+	if err != nil || s.rows[key] == nil {
+		return ""
+	}
+	return s.rows[key].states[(&region{}).column(obj)]
+}
+
+// Entries returns the log of obj, in order, or of every object if nil.
+func (s *MemoryStore) Entries(obj Stater) []StateMachineLog {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var entries []StateMachineLog
+	var key string
+	if obj != nil {
+		key, _ = primaryKey(obj)
+	}
+	for _, entry := range s.entries {
+		if obj == nil || entry.ObjectStruct == StructName(obj) && entry.ObjectKey == key {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Triggers returns the triggers of the log of obj, in order.
+func (s *MemoryStore) Triggers(obj Stater) []string {
+	var triggers []string
+	for _, entry := range s.Entries(obj) {
+		triggers = append(triggers, entry.Trigger)
+	}
+	return triggers
+}
+
+// Path returns the states the main region of obj went through according to
+// its log, from the source of its first transition, nil without any.
+func (s *MemoryStore) Path(obj Stater) []string {
+	var path []string
+	for _, entry := range s.Entries(obj) {
+		if entry.Region != "" || entry.Source == entry.Dest {
+			continue
+		}
+		if path == nil {
+			path = append(path, entry.Source)
+		}
+		path = append(path, entry.Dest)
+	}
+	return path
+}
+
+// Reset forgets the objects and the log.
+func (s *MemoryStore) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rows, s.entries, s.lastId, s.lastLogId = map[string]*memoryRow{}, nil, 0, 0
+}
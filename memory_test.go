@@ -0,0 +1,97 @@
+package common
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMemoryStoreHistory(t *testing.T) {
+	tests := []struct {
+		name     string
+		fire     []string
+		err      error
+		state    string
+		triggers []string
+		path     []string
+	}{
+		{name: "no transition", state: "INITIALIZED"},
+		{
+			name:     "transitions",
+			fire:     []string{"pay", "ship"},
+			state:    "SHIPPED",
+			triggers: []string{"pay", "ship"},
+			path:     []string{"INITIALIZED", "PAID", "SHIPPED"},
+		},
+		{
+			name:     "ignored trigger",
+			fire:     []string{"pay", "pay"},
+			state:    "PAID",
+			triggers: []string{"pay", "pay"},
+			path:     []string{"INITIALIZED", "PAID"},
+		},
+		{
+			name:     "refused trigger",
+			fire:     []string{"ship", "pay"},
+			err:      ErrInvalidSourceState,
+			state:    "SHIPPED",
+			triggers: []string{"ship"},
+			path:     []string{"INITIALIZED", "SHIPPED"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := withMemoryStore(t)
+			invoice := &batchInvoice{}
+			if err := store.Create(invoice); err != nil {
+				t.Fatal(err)
+			}
+			var err error
+			for _, trigger := range tt.fire {
+				if err = invoice.Do(MemoryDB(), trigger, 1); err != nil {
+					break
+				}
+			}
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Do: %v, want %v", err, tt.err)
+			}
+			if state := store.State(invoice); state != tt.state {
+				t.Errorf("stored state %s, want %s", state, tt.state)
+			}
+			if triggers := store.Triggers(invoice); !reflect.DeepEqual(triggers, tt.triggers) {
+				t.Errorf("triggers %v, want %v", triggers, tt.triggers)
+			}
+			if path := store.Path(invoice); !reflect.DeepEqual(path, tt.path) {
+				t.Errorf("path %v, want %v", path, tt.path)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreEntries(t *testing.T) {
+	store := withMemoryStore(t)
+	invoice, order := &batchInvoice{}, &keyedOrder{ID: "a1"}
+	if err := store.Create(invoice, order); err != nil {
+		t.Fatal(err)
+	}
+	if invoice.ID == 0 {
+		t.Error("Create gave the invoice no ID")
+	}
+	if err := invoice.Do(MemoryDB(), "pay", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := order.Do(MemoryDB(), "pay", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if entries := store.Entries(order); len(entries) != 1 || entries[0].ObjectKey != "a1" || entries[0].OperatorId != 2 {
+		t.Errorf("entries of the order %+v, want its pay by 2", entries)
+	}
+	if entries := store.Entries(nil); len(entries) != 2 || entries[0].ID != 1 || entries[1].ID != 2 {
+		t.Errorf("entries %+v, want both, numbered in order", entries)
+	}
+	store.Reset()
+	if entries := store.Entries(nil); len(entries) != 0 || store.State(invoice) != "" {
+		t.Errorf("Reset kept %d entries, state %q", len(entries), store.State(invoice))
+	}
+}
@@ -0,0 +1,113 @@
+package common
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// LogMetadata is the key/value context of a StateMachineLog, e.g. a request
+// ID, source system or amount, stored as a JSON column.
+type LogMetadata map[string]interface{}
+
+func (m LogMetadata) Value() (driver.Value, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(map[string]interface{}(m))
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (m *LogMetadata) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("scan %T into LogMetadata", value)
+	}
+	if len(data) == 0 {
+		*m = nil
+		return nil
+	}
+	return json.Unmarshal(data, (*map[string]interface{})(m))
+}
+
+func (LogMetadata) GormDataType() string {
+	return "json"
+}
+
+func (LogMetadata) GormDBDataType(db *gorm.DB, _ *schema.Field) string {
+	switch db.Dialector.Name() {
+	case "mysql", "sqlite":
+		return "JSON"
+	case "postgres":
+		return "JSONB"
+	case "sqlserver":
+		return "NVARCHAR(MAX)"
+	}
+	return ""
+}
+
+type metadataKey struct{}
+
+// WithLogMetadata returns ctx adding metadata to the StateMachineLog of the
+// transitions run with it by DoCtx or BatchDo, over the metadata ctx already
+// holds.
+func WithLogMetadata(ctx context.Context, metadata LogMetadata) context.Context {
+	merged := LogMetadata{}
+	for k, v := range LogMetadataFromContext(ctx) {
+		merged[k] = v
+	}
+	for k, v := range metadata {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, metadataKey{}, merged)
+}
+
+// LogMetadataFromContext returns the metadata set by WithLogMetadata.
+func LogMetadataFromContext(ctx context.Context) LogMetadata {
+	metadata, _ := ctx.Value(metadataKey{}).(LogMetadata)
+	return metadata
+}
+
+var metadataKeyName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// ScopeLogMetadata restricts a query of StateMachineLog to the entries whose
+// metadata holds value, compared as text, under key:
+//
+//	db.Scopes(ScopeLogMetadata("request_id", id)).Find(&entries)
+//
+// It supports MySQL, PostgreSQL and SQLite.
+func ScopeLogMetadata(key string, value interface{}) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if !metadataKeyName.MatchString(key) {
+			_ = db.AddError(fmt.Errorf("ScopeLogMetadata: invalid key %q", key))
+			return db
+		}
+		text := fmt.Sprint(value)
+		switch db.Dialector.Name() {
+		case "mysql":
+			return db.Where("JSON_UNQUOTE(JSON_EXTRACT(metadata, ?)) = ?", "$."+key, text)
+		case "postgres":
+			return db.Where("metadata->>? = ?", key, text)
+		case "sqlite":
+			return db.Where("CAST(json_extract(metadata, ?) AS TEXT) = ?", "$."+key, text)
+		}
+		_ = db.AddError(fmt.Errorf("ScopeLogMetadata: unsupported dialect %s", db.Dialector.Name()))
+		return db
+	}
+}
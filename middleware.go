@@ -0,0 +1,41 @@
+package common
+
+import (
+	"context"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// TransitionFunc performs a transition of stater. It is the unit wrapped by
+// Middleware.
+type TransitionFunc func(ctx context.Context, tx *gorm.DB, stater Stater, trigger string, operatorId uint, args ...interface{}) error
+
+// Middleware wraps the whole Do pipeline, e.g. for logging, metrics, retries
+// or authorization. It may change the context, transaction, trigger or
+// arguments passed to next, or not call it at all.
+type Middleware func(next TransitionFunc) TransitionFunc
+
+var (
+	middlewaresMu sync.RWMutex
+	middlewares   []Middleware
+)
+
+// Use registers middlewares applied to the transitions of every machine,
+// outside of those registered on a Definition. The first one registered is
+// the outermost.
+func Use(mw ...Middleware) {
+	middlewaresMu.Lock()
+	defer middlewaresMu.Unlock()
+	middlewares = append(middlewares, mw...)
+}
+
+func chain(next TransitionFunc, definition *Definition) TransitionFunc {
+	middlewaresMu.RLock()
+	all := append(append([]Middleware(nil), middlewares...), definition.middlewares...)
+	middlewaresMu.RUnlock()
+	for i := len(all) - 1; i >= 0; i-- {
+		next = all[i](next)
+	}
+	return next
+}
@@ -0,0 +1,210 @@
+// Package smmongo is a common.Store on MongoDB, for models whose documents
+// live there: the states are updated in the collections of the models,
+// provided they did not change since they were read, and the log is
+// inserted in a collection of its own.
+//
+//	store := smmongo.NewStore(client.Database("shop"))
+//	_ = store.EnsureIndexes(ctx)
+//	sm.SetStore(store)
+//	err := order.DoCtx(ctx, sm.MemoryDB(), "pay", userId)
+//
+// Embed common.StateMachine with `bson:",inline"` for the state to be a
+// field of the documents, and implement common.IDProvider for keys that
+// are not integers or strings, e.g. returning the Hex of a bson.ObjectID.
+package smmongo
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"gorm.io/gorm"
+
+	common "sm"
+)
+
+// LogCollection is the default collection of the log.
+const LogCollection = "state_machine_logs"
+
+// Store updates the states in the collections of the models and logs in Logs.
+type Store struct {
+	db   *mongo.Database
+	Logs *mongo.Collection
+	// Collection returns the collection of the documents of the model of
+	// obj, by default its struct name in lower case, followed by "s".
+	Collection func(obj common.Stater) string
+}
+
+// NewStore returns the store of the collections of db, logging in
+// LogCollection.
+func NewStore(db *mongo.Database) *Store {
+	return &Store{
+		db:   db,
+		Logs: db.Collection(LogCollection),
+		Collection: func(obj common.Stater) string {
+			return strings.ToLower(common.StructName(obj)) + "s"
+		},
+	}
+}
+
+// LogDocument is a log entry as stored in Logs.
+type LogDocument struct {
+	ID           bson.ObjectID      `bson:"_id,omitempty"`
+	CreatedAt    time.Time          `bson:"created_at"`
+	ObjectId     uint               `bson:"object_id,omitempty"`
+	ObjectKey    string             `bson:"object_key"`
+	ObjectStruct string             `bson:"object_struct"`
+	Region       string             `bson:"region,omitempty"`
+	Trigger      string             `bson:"trigger"`
+	Source       string             `bson:"source"`
+	Dest         string             `bson:"dest"`
+	OperatorId   uint               `bson:"operator_id"`
+	OperatorRef  string             `bson:"operator_ref,omitempty"`
+	Branch       string             `bson:"branch,omitempty"`
+	Reason       string             `bson:"reason,omitempty"`
+	Metadata     common.LogMetadata `bson:"metadata,omitempty"`
+	Args         string             `bson:"args,omitempty"`
+	TraceId      string             `bson:"trace_id,omitempty"`
+	TenantId     string             `bson:"tenant_id,omitempty"`
+	Hash         string             `bson:"hash,omitempty"`
+	PrevHash     string             `bson:"prev_hash,omitempty"`
+}
+
+// EnsureIndexes creates the indexes of Logs, by object and time, by tenant
+// and by trace.
+func (s *Store) EnsureIndexes(ctx context.Context) error {
+	_, err := s.Logs.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "object_struct", Value: 1}, {Key: "object_key", Value: 1}, {Key: "created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "created_at", Value: 1}}, Options: options.Index().SetSparse(true)},
+		{Keys: bson.D{{Key: "trace_id", Value: 1}}, Options: options.Index().SetSparse(true)},
+	})
+	if err != nil {
+		return fmt.Errorf("create indexes of %s: %w", s.Logs.Name(), err)
+	}
+	return nil
+}
+
+// UpdateState sets the state of the document of the object, matched by its
+// _id and by the state, and version if any, it was read in; the version is
+// incremented. It returns an error matching common.ErrConcurrentModification
+// if none matched.
+func (s *Store) UpdateState(ctx context.Context, _ *gorm.DB, u common.StateUpdate) error {
+	id, err := idOf(u.Object)
+	if err != nil {
+		return err
+	}
+	t := reflect.Indirect(reflect.ValueOf(u.Object)).Type()
+	state, err := pathOf(t, u.Field)
+	if err != nil {
+		return err
+	}
+	filter := bson.D{{Key: "_id", Value: id}, {Key: state, Value: u.From}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: state, Value: u.To}}}}
+	if u.VersionField != "" {
+		version, err := pathOf(t, u.VersionField)
+		if err != nil {
+			return err
+		}
+		filter = append(filter, bson.E{Key: version, Value: u.Version})
+		update = append(update, bson.E{Key: "$inc", Value: bson.D{{Key: version, Value: 1}}})
+	}
+	result, err := s.db.Collection(s.Collection(u.Object)).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("update state of %s: %w", common.StructName(u.Object), err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("%w: %s %v changed since it was read", common.ErrConcurrentModification, common.StructName(u.Object), id)
+	}
+	return nil
+}
+
+// Write inserts the entries in Logs, timestamped but without IDs.
+func (s *Store) Write(ctx context.Context, _ *gorm.DB, entries []*common.StateMachineLog) error {
+	docs := make([]interface{}, len(entries))
+	for i, entry := range entries {
+		if entry.CreatedAt.IsZero() {
+			entry.CreatedAt = time.Now()
+			entry.UpdatedAt = entry.CreatedAt
+		}
+		docs[i] = LogDocument{
+			CreatedAt:    entry.CreatedAt,
+			ObjectId:     entry.ObjectId,
+			ObjectKey:    entry.ObjectKey,
+			ObjectStruct: entry.ObjectStruct,
+			Region:       entry.Region,
+			Trigger:      entry.Trigger,
+			Source:       entry.Source,
+			Dest:         entry.Dest,
+			OperatorId:   entry.OperatorId,
+			OperatorRef:  entry.OperatorRef,
+			Branch:       entry.Branch,
+			Reason:       entry.Reason,
+			Metadata:     entry.Metadata,
+			Args:         entry.Args,
+			TraceId:      entry.TraceId,
+			TenantId:     entry.TenantId,
+			Hash:         entry.Hash,
+			PrevHash:     entry.PrevHash,
+		}
+	}
+	if _, err := s.Logs.InsertMany(ctx, docs); err != nil {
+		return fmt.Errorf("insert log entries: %w", err)
+	}
+	return nil
+}
+
+// idOf returns the value of the field of obj stored as _id: tagged so, or
+// named ID.
+func idOf(obj common.Stater) (interface{}, error) {
+	v := reflect.Indirect(reflect.ValueOf(obj))
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if name, _ := bsonName(t.Field(i)); name == "_id" {
+			return v.Field(i).Interface(), nil
+		}
+	}
+	if f := v.FieldByName("ID"); f.IsValid() {
+		return f.Interface(), nil
+	}
+	return nil, fmt.Errorf("%s has no _id field", common.StructName(obj))
+}
+
+// pathOf returns the dotted path of the field of t named name, in any case as
+// the columns of the regions may be, in its documents, through the embedded
+// structs that are not inlined.
+func pathOf(t reflect.Type, name string) (string, error) {
+	f, ok := t.FieldByNameFunc(func(field string) bool { return strings.EqualFold(field, name) })
+	if !ok {
+		return "", fmt.Errorf("%s has no field %s", t.Name(), name)
+	}
+	var path []string
+	for i := range f.Index {
+		field := t.FieldByIndex(f.Index[:i+1])
+		key, inline := bsonName(field)
+		if !inline {
+			path = append(path, key)
+		}
+	}
+	return strings.Join(path, "."), nil
+}
+
+// bsonName returns the key of field in the documents, as the driver names
+// it, and whether it is inlined.
+func bsonName(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("bson")
+	parts := strings.Split(tag, ",")
+	for _, option := range parts[1:] {
+		if option == "inline" {
+			return "", true
+		}
+	}
+	if parts[0] != "" && parts[0] != "-" {
+		return parts[0], false
+	}
+	return strings.ToLower(field.Name), false
+}
@@ -0,0 +1,63 @@
+package smnats
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	common "sm"
+)
+
+// Handler returns the handler of core NATS subscriptions handing messages to
+// c, with ctx. Messages without an Id are deduplicated by their Nats-Msg-Id
+// header, if any. Errors are reported to onError, which may be nil; core NATS
+// does not redeliver.
+func Handler(ctx context.Context, c *common.Consumer, onError func(*nats.Msg, error)) nats.MsgHandler {
+	return func(m *nats.Msg) {
+		if err := c.HandleData(ctx, m.Data, m.Header.Get(nats.MsgIdHdr)); err != nil && onError != nil {
+			onError(m, err)
+		}
+	}
+}
+
+// JetStreamHandler returns the handler of JetStream consumers handing
+// messages to c, with ctx. Messages without an Id are deduplicated by their
+// Nats-Msg-Id header, or stream sequence. Handled messages are acknowledged,
+// messages failing for good, see common.IsPermanent, terminated, and the
+// others redelivered after a delay growing with their deliveries. Errors are
+// reported to onError, which may be nil.
+func JetStreamHandler(ctx context.Context, c *common.Consumer, onError func(jetstream.Msg, error)) jetstream.MessageHandler {
+	return func(m jetstream.Msg) {
+		meta, err := m.Metadata()
+		if err != nil {
+			if onError != nil {
+				onError(m, err)
+			}
+			_ = m.Term()
+			return
+		}
+		id := m.Headers().Get(nats.MsgIdHdr)
+		if id == "" {
+			id = fmt.Sprintf("nats:%s/%d", meta.Stream, meta.Sequence.Stream)
+		}
+		err = c.HandleData(ctx, m.Data(), id)
+		switch {
+		case err == nil:
+			err = m.Ack()
+		case common.IsPermanent(err):
+			_ = m.Term()
+		default:
+			delay := time.Second << 6
+			if meta.NumDelivered <= 6 {
+				delay = time.Second << (meta.NumDelivered - 1)
+			}
+			_ = m.NakWithDelay(delay)
+		}
+		if err != nil && onError != nil {
+			onError(m, err)
+		}
+	}
+}
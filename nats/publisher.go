@@ -0,0 +1,126 @@
+// Package smnats publishes the transitions of state machine models to NATS,
+// optionally persisted by JetStream:
+//
+//	nc, _ := nats.Connect(nats.DefaultURL)
+//	defer sm.PublishTo(smnats.NewPublisher(nc, "sm.{ObjectStruct}.{Trigger}"), nil)()
+//
+// or, to publish the committed transitions only, through the outbox table:
+//
+//	sm.PublishThroughOutbox("nats", smnats.NewJetStreamPublisher(js, ""))
+//	go sm.RunOutbox(ctx, db, time.Second, nil)
+//
+// Subjects are templates of the fields of common.EventPayload: {ObjectStruct},
+// {ObjectId}, its ObjectKey, {Region}, {Trigger}, {Source} and {Dest}.
+package smnats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"google.golang.org/protobuf/proto"
+
+	common "sm"
+	smgrpc "sm/grpc"
+)
+
+// DefaultSubject is the subject template of publishers created without one.
+const DefaultSubject = "sm.{ObjectStruct}.{Trigger}"
+
+// Encoding is the format of the messages.
+type Encoding int
+
+const (
+	// JSON encodes common.EventPayload.
+	JSON Encoding = iota
+	// Protobuf encodes smpb.TransitionEvent.
+	Protobuf
+)
+
+// Publisher is a common.Publisher sending each transition as a message.
+type Publisher struct {
+	publish  func(ctx context.Context, msg *nats.Msg) error
+	subject  string
+	Encoding Encoding
+	// Timeout bounds the wait for the acknowledgement of JetStream, 10s by
+	// default. Messages are sent by the worker of common.PublishTo or by
+	// common.RelayOutbox, never within a transition.
+	Timeout time.Duration
+}
+
+// NewPublisher returns a publisher of JSON messages on nc, without
+// persistence; subject is a template, DefaultSubject if empty.
+func NewPublisher(nc *nats.Conn, subject string) *Publisher {
+	return newPublisher(subject, func(_ context.Context, msg *nats.Msg) error {
+		return nc.PublishMsg(msg)
+	})
+}
+
+// NewJetStreamPublisher returns a publisher of JSON messages to the streams
+// of js, waiting for their acknowledgement. Each message carries a
+// Nats-Msg-Id header of the ObjectStruct, ObjectKey and LogId of its
+// transition, for the stream to drop duplicates.
+func NewJetStreamPublisher(js jetstream.JetStream, subject string) *Publisher {
+	return newPublisher(subject, func(ctx context.Context, msg *nats.Msg) error {
+		_, err := js.PublishMsg(ctx, msg)
+		return err
+	})
+}
+
+func newPublisher(subject string, publish func(ctx context.Context, msg *nats.Msg) error) *Publisher {
+	if subject == "" {
+		subject = DefaultSubject
+	}
+	return &Publisher{publish: publish, subject: subject, Timeout: 10 * time.Second}
+}
+
+// Subject returns the subject of the message of p.
+func (p *Publisher) Subject(payload *common.EventPayload) string {
+	return strings.NewReplacer(
+		"{ObjectStruct}", payload.ObjectStruct,
+		"{ObjectId}", payload.ObjectKey,
+		"{Region}", payload.Region,
+		"{Trigger}", payload.Trigger,
+		"{Source}", payload.Source,
+		"{Dest}", payload.Dest,
+	).Replace(p.subject)
+}
+
+func (p *Publisher) Publish(ctx context.Context, event common.TransitionEvent) error {
+	payload, err := common.NewEventPayload(event)
+	if err != nil {
+		return err
+	}
+	return p.PublishPayload(ctx, payload)
+}
+
+// PublishPayload sends the message of payload, for common.RelayOutbox.
+func (p *Publisher) PublishPayload(ctx context.Context, payload *common.EventPayload) error {
+	var err error
+	msg := nats.NewMsg(p.Subject(payload))
+	msg.Header.Set(nats.MsgIdHdr, fmt.Sprintf("%s:%s:%d", payload.ObjectStruct, payload.ObjectKey, payload.LogId))
+	switch p.Encoding {
+	case JSON:
+		msg.Data, err = json.Marshal(payload)
+	case Protobuf:
+		msg.Data, err = proto.Marshal(smgrpc.EventProto(payload))
+	default:
+		err = fmt.Errorf("smnats: unknown encoding %d", p.Encoding)
+	}
+	if err != nil {
+		return err
+	}
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+	if err := p.publish(ctx, msg); err != nil {
+		return fmt.Errorf("publish %s: %w", msg.Subject, err)
+	}
+	return nil
+}
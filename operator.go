@@ -0,0 +1,83 @@
+package common
+
+import (
+	"context"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// OperatorLog is a StateMachineLog with its operator, a model of type O whose
+// primary key is OperatorId, as a relation:
+//
+//	var entries []OperatorLog[User]
+//	db.Preload("Operator").Where("object_id = ?", order.ID).Find(&entries)
+type OperatorLog[O any] struct {
+	StateMachineLog
+	Operator *O `gorm:"foreignKey:OperatorId"`
+}
+
+func (OperatorLog[O]) TableName() string {
+	return "state_machine_logs"
+}
+
+// AutoMigrateOperatorLog migrates StateMachineLog with a foreign key of its
+// OperatorId to the table of O. Every transition then needs an operator:
+// userInfoId 0 breaks the constraint.
+func AutoMigrateOperatorLog[O any](tx *gorm.DB) {
+	if err := tx.AutoMigrate(&OperatorLog[O]{}); err != nil {
+		panic(err)
+	}
+	if !tx.Migrator().HasConstraint(&OperatorLog[O]{}, "Operator") {
+		if err := tx.Migrator().CreateConstraint(&OperatorLog[O]{}, "Operator"); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// OperatorProvider identifies the actor of a transition from its context,
+// e.g. a service account or API key, for the systems whose actors are not
+// all numeric user IDs.
+type OperatorProvider interface {
+	// OperatorOf returns the ID of the actor of ctx, 0 if it has none, and
+	// a reference to it, e.g. "apikey:7f3a", empty if it has none.
+	OperatorOf(ctx context.Context) (id uint, ref string)
+}
+
+// OperatorFunc is an OperatorProvider func.
+type OperatorFunc func(ctx context.Context) (id uint, ref string)
+
+func (f OperatorFunc) OperatorOf(ctx context.Context) (uint, string) {
+	return f(ctx)
+}
+
+var (
+	operatorProviderMu sync.RWMutex
+	operatorProvider   OperatorProvider
+)
+
+// SetOperatorProvider sets the provider completing the StateMachineLog of the
+// transitions: its ID is stored when they were fired with userInfoId 0, and
+// its reference in OperatorRef. nil, the default, removes it.
+func SetOperatorProvider(provider OperatorProvider) {
+	operatorProviderMu.Lock()
+	defer operatorProviderMu.Unlock()
+	operatorProvider = provider
+}
+
+// completeOperator fills the operator of entry from ctx.
+func completeOperator(ctx context.Context, entry *StateMachineLog) {
+	operatorProviderMu.RLock()
+	provider := operatorProvider
+	operatorProviderMu.RUnlock()
+	if provider == nil {
+		return
+	}
+	id, ref := provider.OperatorOf(ctx)
+	if entry.OperatorId == 0 {
+		entry.OperatorId = id
+	}
+	if entry.OperatorRef == "" {
+		entry.OperatorRef = ref
+	}
+}
@@ -0,0 +1,102 @@
+// Package smotel traces the transitions of state machines with
+// OpenTelemetry: a span per Do, with the object, trigger, states and operator
+// as attributes, and a child span per phase, see common.TracePhases.
+//
+//	smotel.Instrument(nil) // the global TracerProvider
+//	sm.ExtractTraceId(smotel.TraceId)
+package smotel
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+
+	common "sm"
+)
+
+const instrumentation = "sm"
+
+// Attributes of the spans of transitions.
+const (
+	ObjectType = attribute.Key("sm.object.type")
+	ObjectId   = attribute.Key("sm.object.id")
+	Trigger    = attribute.Key("sm.trigger")
+	Region     = attribute.Key("sm.region")
+	Source     = attribute.Key("sm.source")
+	Dest       = attribute.Key("sm.dest")
+	OperatorId = attribute.Key("sm.operator.id")
+)
+
+// Instrument traces every transition with the tracers of tp, or of the global
+// TracerProvider if nil. It is meant to be called once, at start-up.
+func Instrument(tp trace.TracerProvider) {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	tracer := tp.Tracer(instrumentation)
+	common.Use(Middleware(tracer))
+	common.BeforeAnyTransition(annotate)
+	common.TracePhases(PhaseTracer(tracer))
+}
+
+type spanKey struct{}
+
+// Middleware starts the span of each transition; the states are added by the
+// hook registered by Instrument.
+func Middleware(tracer trace.Tracer) common.Middleware {
+	return func(next common.TransitionFunc) common.TransitionFunc {
+		return func(ctx context.Context, tx *gorm.DB, stater common.Stater, trigger string, operatorId uint, args ...interface{}) error {
+			attrs := []attribute.KeyValue{
+				ObjectType.String(common.StructName(stater)),
+				Trigger.String(trigger),
+				OperatorId.Int64(int64(operatorId)),
+			}
+			if id, err := common.ObjectIdOf(stater); err == nil {
+				attrs = append(attrs, ObjectId.Int64(int64(id)))
+			}
+			ctx, span := tracer.Start(ctx, common.StructName(stater)+" "+trigger, trace.WithAttributes(attrs...))
+			defer span.End()
+			err := next(context.WithValue(ctx, spanKey{}, span), tx, stater, trigger, operatorId, args...)
+			end(span, err)
+			return err
+		}
+	}
+}
+
+func annotate(ctx context.Context, _ *gorm.DB, event *common.TransitionEvent) error {
+	if span, ok := ctx.Value(spanKey{}).(trace.Span); ok {
+		span.SetAttributes(Region.String(event.Region), Source.String(event.Source), Dest.String(event.Dest))
+	}
+	return nil
+}
+
+// PhaseTracer starts a span per phase of a transition, e.g. "sm.before".
+func PhaseTracer(tracer trace.Tracer) common.PhaseTracer {
+	return func(ctx context.Context, phase string) (context.Context, func(error)) {
+		ctx, span := tracer.Start(ctx, instrumentation+"."+phase)
+		return ctx, func(err error) {
+			end(span, err)
+			span.End()
+		}
+	}
+}
+
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// TraceId returns the ID of the trace of the span in ctx, for
+// common.ExtractTraceId.
+func TraceId(ctx context.Context) string {
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		return sc.TraceID().String()
+	}
+	return ""
+}
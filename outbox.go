@@ -0,0 +1,168 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// StateMachineOutboxEvent is a transition event of a publisher registered by
+// PublishThroughOutbox, written in the transaction of its transition and
+// published by RelayOutbox once committed.
+type StateMachineOutboxEvent struct {
+	gorm.Model
+	Publisher    string `gorm:"not null; index; varchar(64)"`
+	ObjectStruct string `gorm:"not null; varchar(64)"`
+	Trigger      string `gorm:"not null; varchar(64)"`
+	Payload      string `gorm:"not null"`
+	Attempts     int    `gorm:"not null; default:0"`
+	LastError    string
+	PublishedAt  *time.Time `gorm:"index"`
+}
+
+func AutoMigrateStateMachineOutboxEvent(tx *gorm.DB) {
+	if err := tx.AutoMigrate(&StateMachineOutboxEvent{}); err != nil {
+		panic(err)
+	}
+}
+
+// PayloadPublisher sends serialized transition events to a broker, e.g. the
+// publishers of sm/kafka and sm/nats.
+type PayloadPublisher interface {
+	PublishPayload(ctx context.Context, payload *EventPayload) error
+}
+
+var (
+	outboxMu   sync.RWMutex
+	outbox     = map[string]PayloadPublisher{}
+	outboxHook sync.Once
+)
+
+// PublishThroughOutbox publishes the committed transitions with publisher:
+// every successful transition, reverts and forced states included, writes a
+// StateMachineOutboxEvent for it under name, in the transaction of the
+// transition; run RelayOutbox or RunOutbox to publish them. Unlike PublishTo,
+// the events of transactions rolled back are never published, and the others
+// are published at least once, in order, whichever process committed them.
+// Registering name again replaces its publisher.
+func PublishThroughOutbox(name string, publisher PayloadPublisher) {
+	outboxHook.Do(func() {
+		OnAnyTransition(enqueueOutbox)
+	})
+	outboxMu.Lock()
+	defer outboxMu.Unlock()
+	outbox[name] = publisher
+}
+
+func enqueueOutbox(ctx context.Context, tx *gorm.DB, event *TransitionEvent) error {
+	outboxMu.RLock()
+	names := make([]string, 0, len(outbox))
+	for name := range outbox {
+		names = append(names, name)
+	}
+	outboxMu.RUnlock()
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+	p, err := NewEventPayload(*event)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := tx.Create(&StateMachineOutboxEvent{
+			Publisher:    name,
+			ObjectStruct: p.ObjectStruct,
+			Trigger:      event.Trigger,
+			Payload:      string(payload),
+		}).Error; err != nil {
+			return fmt.Errorf("record outbox event of %s: %w", p.ObjectStruct, err)
+		}
+	}
+	return nil
+}
+
+// RelayOutbox publishes up to limit (all if limit <= 0) pending outbox events
+// of the registered publishers, oldest first. It stops at the first failure,
+// recorded in its event and returned, so that the events stay ordered: the
+// event is tried again by the next call. Only one relay should run at a time.
+func RelayOutbox(ctx context.Context, db *gorm.DB, limit int) (published int, err error) {
+	outboxMu.RLock()
+	names := make([]string, 0, len(outbox))
+	for name := range outbox {
+		names = append(names, name)
+	}
+	outboxMu.RUnlock()
+	if len(names) == 0 {
+		return 0, nil
+	}
+	db = db.WithContext(ctx)
+	query := db.Where("published_at IS NULL AND publisher IN ?", names).Order("id")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	var events []StateMachineOutboxEvent
+	if err := query.Find(&events).Error; err != nil {
+		return 0, fmt.Errorf("read outbox events: %w", err)
+	}
+
+	for _, event := range events {
+		if err := ctx.Err(); err != nil {
+			return published, err
+		}
+		outboxMu.RLock()
+		publisher, ok := outbox[event.Publisher]
+		outboxMu.RUnlock()
+		if !ok {
+			continue
+		}
+		var payload EventPayload
+		err := json.Unmarshal([]byte(event.Payload), &payload)
+		if err == nil {
+			err = publisher.PublishPayload(ctx, &payload)
+		}
+		if err != nil {
+			if updateErr := db.Model(&event).Updates(map[string]interface{}{
+				"attempts":   event.Attempts + 1,
+				"last_error": err.Error(),
+			}).Error; updateErr != nil {
+				return published, fmt.Errorf("record outbox failure: %w", updateErr)
+			}
+			return published, fmt.Errorf("publish outbox event %d with %s: %w", event.ID, event.Publisher, err)
+		}
+		if err := db.Model(&event).Updates(map[string]interface{}{
+			"attempts":     event.Attempts + 1,
+			"published_at": time.Now(),
+		}).Error; err != nil {
+			return published, fmt.Errorf("record outbox publication: %w", err)
+		}
+		published++
+	}
+	return published, nil
+}
+
+// RunOutbox calls RelayOutbox every interval until ctx is done. Errors are
+// reported to onError, which may be nil.
+func RunOutbox(ctx context.Context, db *gorm.DB, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := RelayOutbox(ctx, db, 0); err != nil && onError != nil && ctx.Err() == nil {
+			onError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package common
+
+import (
+	"context"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// Phases of a transition, see TracePhases.
+const (
+	// PhaseCondition checks the source state, condition and guards of the
+	// trigger and resolves its destination.
+	PhaseCondition = "condition"
+	// PhaseBefore runs the BeforeAnyTransition hooks, the exit callbacks of
+	// the source state and the Before callback.
+	PhaseBefore = "before"
+	// PhaseUpdate stores the new state and runs the enter callbacks of the
+	// destination state.
+	PhaseUpdate = "update"
+	// PhaseAfter runs the After callback.
+	PhaseAfter = "after"
+	// PhaseLog writes the StateMachineLog.
+	PhaseLog = "log"
+)
+
+// PhaseTracer is called at the start of each phase of a transition run by
+// Do, e.g. to start a span. The context it returns is handed to the
+// callbacks of the phase; end is called with the result of the phase.
+type PhaseTracer func(ctx context.Context, phase string) (_ context.Context, end func(err error))
+
+var (
+	phaseTracerMu sync.RWMutex
+	phaseTracer   PhaseTracer
+)
+
+// TracePhases sets the PhaseTracer of every machine, none if nil.
+func TracePhases(tracer PhaseTracer) {
+	phaseTracerMu.Lock()
+	defer phaseTracerMu.Unlock()
+	phaseTracer = tracer
+}
+
+func phase(ctx context.Context, tx *gorm.DB, name string, fn func(ctx context.Context, tx *gorm.DB) error) error {
+	phaseTracerMu.RLock()
+	tracer := phaseTracer
+	phaseTracerMu.RUnlock()
+	if tracer == nil {
+		return fn(ctx, tx)
+	}
+	ctx, end := tracer(ctx, name)
+	err := fn(ctx, tx.WithContext(ctx))
+	end(err)
+	return err
+}
@@ -0,0 +1,148 @@
+// Package smprom exports Prometheus metrics of the transitions of state
+// machines:
+//
+//	smprom.Instrument(prometheus.DefaultRegisterer)
+//	http.Handle("/metrics", promhttp.Handler())
+//
+// sm_transitions_total{type,trigger,source,dest,result} counts the
+// transitions by result, sm_transition_duration_seconds{type,trigger}
+// measures them, and sm_guard_rejections_total{type,trigger} counts the
+// triggers refused by a condition or guard.
+package smprom
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+
+	common "sm"
+)
+
+// Results of the transitions.
+const (
+	ResultOK = "ok"
+	// ResultIgnored is a trigger ignored in the current state, see
+	// common.TransitionResult.AlreadyInState.
+	ResultIgnored = "ignored"
+	// ResultRejected is a trigger refused by a condition or guard.
+	ResultRejected = "rejected"
+	// ResultRefused is a trigger refused by the state machine, e.g. from a
+	// wrong source state.
+	ResultRefused = "refused"
+	ResultError   = "error"
+)
+
+// Metrics is a prometheus.Collector of the metrics of the transitions.
+type Metrics struct {
+	transitions     *prometheus.CounterVec
+	duration        *prometheus.HistogramVec
+	guardRejections *prometheus.CounterVec
+}
+
+// NewMetrics returns the metrics of the transitions, named with namespace,
+// e.g. "sm".
+func NewMetrics(namespace string) *Metrics {
+	return &Metrics{
+		transitions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "transitions_total",
+			Help:      "Transitions fired, by result.",
+		}, []string{"type", "trigger", "source", "dest", "result"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "transition_duration_seconds",
+			Help:      "Duration of the transitions, callbacks included.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"type", "trigger"}),
+		guardRejections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "guard_rejections_total",
+			Help:      "Triggers refused by a condition or guard.",
+		}, []string{"type", "trigger"}),
+	}
+}
+
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	m.transitions.Describe(ch)
+	m.duration.Describe(ch)
+	m.guardRejections.Describe(ch)
+}
+
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	m.transitions.Collect(ch)
+	m.duration.Collect(ch)
+	m.guardRejections.Collect(ch)
+}
+
+// Instrument registers the metrics of every transition with reg, in the "sm"
+// namespace. It is meant to be called once, at start-up.
+func Instrument(reg prometheus.Registerer) (*Metrics, error) {
+	m := NewMetrics("sm")
+	if err := reg.Register(m); err != nil {
+		return nil, err
+	}
+	common.Use(m.Middleware())
+	common.BeforeAnyTransition(Hook)
+	return m, nil
+}
+
+type states struct {
+	source, dest string
+}
+
+type statesKey struct{}
+
+// Middleware measures each transition. Its source and dest labels are the
+// states of the transition if Hook is registered with
+// common.BeforeAnyTransition, otherwise the main state before and after it.
+func (m *Metrics) Middleware() common.Middleware {
+	return func(next common.TransitionFunc) common.TransitionFunc {
+		return func(ctx context.Context, tx *gorm.DB, stater common.Stater, trigger string, operatorId uint, args ...interface{}) error {
+			start := time.Now()
+			s := &states{source: stater.GetState()}
+			err := next(context.WithValue(ctx, statesKey{}, s), tx, stater, trigger, operatorId, args...)
+			if s.dest == "" && err == nil {
+				s.dest = stater.GetState()
+			}
+			typ := common.StructName(stater)
+			m.duration.WithLabelValues(typ, trigger).Observe(time.Since(start).Seconds())
+			m.transitions.WithLabelValues(typ, trigger, s.source, s.dest, resultOf(ctx, err)).Inc()
+			if errors.Is(err, common.ErrGuardRejected) {
+				m.guardRejections.WithLabelValues(typ, trigger).Inc()
+			}
+			return err
+		}
+	}
+}
+
+// Hook records the states of the transitions for the labels of Middleware.
+func Hook(ctx context.Context, _ *gorm.DB, event *common.TransitionEvent) error {
+	if s, ok := ctx.Value(statesKey{}).(*states); ok {
+		s.source, s.dest = event.Source, event.Dest
+	}
+	return nil
+}
+
+func resultOf(ctx context.Context, err error) string {
+	switch {
+	case err == nil:
+		if result, ok := common.ResultFromContext(ctx); ok && result.AlreadyInState {
+			return ResultIgnored
+		}
+		return ResultOK
+	case errors.Is(err, common.ErrGuardRejected):
+		return ResultRejected
+	case errors.Is(err, common.ErrTriggerNotFound),
+		errors.Is(err, common.ErrInvalidSourceState),
+		errors.Is(err, common.ErrFinalState),
+		errors.Is(err, common.ErrSelfTransition),
+		errors.Is(err, common.ErrNestedTransition),
+		errors.Is(err, common.ErrObjectDeleted):
+		return ResultRefused
+	default:
+		return ResultError
+	}
+}
@@ -0,0 +1,156 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// StateMachineQueuedTrigger is a trigger waiting in the queue of its object,
+// see EnqueueTrigger and ProcessQueue.
+type StateMachineQueuedTrigger struct {
+	gorm.Model
+	ObjectId     uint   `gorm:"not null; index"`
+	ObjectStruct string `gorm:"not null; index; varchar(64)"`
+	Trigger      string `gorm:"not null; varchar(64)"`
+	OperatorId   uint   `gorm:"not null"`
+	Attempts     int    `gorm:"not null; default:0"`
+	LastError    string
+}
+
+func AutoMigrateStateMachineQueuedTrigger(tx *gorm.DB) {
+	if err := tx.AutoMigrate(&StateMachineQueuedTrigger{}); err != nil {
+		panic(err)
+	}
+}
+
+// EnqueueTrigger appends trigger to the queue of the object instead of firing
+// it. Queued triggers take no arguments.
+func (sm *StateMachine) EnqueueTrigger(tx *gorm.DB, trigger string, userInfoId uint) error {
+	definition, err := sm.Definition()
+	if err != nil {
+		return err
+	}
+	trigger = definition.Canonical(trigger)
+	if _, ok := definition.regionOf(trigger); !ok {
+		return fmt.Errorf("%w: %s", ErrTriggerNotFound, trigger)
+	}
+	id, err := objectId(sm.stater)
+	if err != nil {
+		return err
+	}
+	if err := tx.Create(&StateMachineQueuedTrigger{
+		ObjectId:     id,
+		ObjectStruct: StructName(sm.stater),
+		Trigger:      trigger,
+		OperatorId:   userInfoId,
+	}).Error; err != nil {
+		return fmt.Errorf("enqueue trigger %s of %s: %w", trigger, StructName(sm.stater), err)
+	}
+	return nil
+}
+
+// ProcessQueue fires up to limit (all if limit <= 0) queued triggers of the
+// objects of model's type, in order. Each runs in its own transaction holding
+// a lock on the object and removing the queue entry, so a trigger is fired at
+// least once and never concurrently with another one of the same object. A
+// failing trigger stays queued with its error and blocks the following ones
+// of its object until it succeeds.
+func ProcessQueue(ctx context.Context, db *gorm.DB, model Stater, limit int) (processed int, err error) {
+	db = db.WithContext(ctx)
+	query := db.Where("object_struct = ?", StructName(model)).Order("id")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	var queued []StateMachineQueuedTrigger
+	if err := query.Find(&queued).Error; err != nil {
+		return 0, fmt.Errorf("read queue of %s: %w", StructName(model), err)
+	}
+
+	blocked := map[uint]bool{}
+	for _, item := range queued {
+		if err := ctx.Err(); err != nil {
+			return processed, err
+		}
+		if blocked[item.ObjectId] {
+			continue
+		}
+		done, err := processQueued(ctx, db, model, item)
+		if err != nil {
+			blocked[item.ObjectId] = true
+			if err := db.Model(&item).Updates(map[string]interface{}{
+				"attempts":   gorm.Expr("attempts + 1"),
+				"last_error": err.Error(),
+			}).Error; err != nil {
+				return processed, fmt.Errorf("record queue failure of %s: %w", StructName(model), err)
+			}
+			continue
+		}
+		if done {
+			processed++
+		}
+	}
+	return processed, nil
+}
+
+// processQueued fires item unless another worker already did, or an older
+// entry of the same object is still queued.
+func processQueued(ctx context.Context, db *gorm.DB, model Stater, item StateMachineQueuedTrigger) (done bool, err error) {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		obj, ok := reflect.New(reflect.TypeOf(model).Elem()).Interface().(Stater)
+		if !ok {
+			return fmt.Errorf("%s is not a pointer to a Stater", StructName(model))
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(obj, item.ObjectId).Error; err != nil {
+			return fmt.Errorf("load %s %d: %w", StructName(model), item.ObjectId, err)
+		}
+
+		var head StateMachineQueuedTrigger
+		if err := tx.Where(
+			"object_id = ? AND object_struct = ?", item.ObjectId, item.ObjectStruct,
+		).Order("id").First(&head).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+		if head.ID != item.ID {
+			return nil
+		}
+
+		sm, err := machineOf(obj)
+		if err != nil {
+			return err
+		}
+		if err := sm.DoCtx(ctx, tx, item.Trigger, item.OperatorId); err != nil {
+			return err
+		}
+		done = true
+		return tx.Unscoped().Delete(&head).Error
+	})
+	return done, err
+}
+
+// RunQueue calls ProcessQueue for each model every interval until ctx is
+// done. Errors are reported to onError, which may be nil.
+func RunQueue(ctx context.Context, db *gorm.DB, interval time.Duration, onError func(error), models ...Stater) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, model := range models {
+			if _, err := ProcessQueue(ctx, db, model, 0); err != nil && onError != nil && ctx.Err() == nil {
+				onError(err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
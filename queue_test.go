@@ -0,0 +1,102 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+var queuedDefinition = NewDefinition("Ticket").
+	State("INITIALIZED", "PAID", "SHIPPED").
+	Trigger("pay").From("INITIALIZED").To("PAID").
+	Trigger("ship").From("PAID").To("SHIPPED").
+	MustBuild()
+
+type queuedTicket struct {
+	ID uint
+	StateMachine
+}
+
+func (*queuedTicket) Define() *Definition {
+	return queuedDefinition
+}
+
+func TestProcessQueue(t *testing.T) {
+	tests := []struct {
+		name      string
+		queued    [2][]string
+		processed int
+		states    [2]string
+		remaining int
+	}{
+		{
+			name:      "fires the triggers in order",
+			queued:    [2][]string{{"pay", "ship"}, {"pay"}},
+			processed: 3,
+			states:    [2]string{"SHIPPED", "PAID"},
+		},
+		{
+			name:      "blocks the object of a failing trigger",
+			queued:    [2][]string{{"ship", "pay"}, {"pay", "ship"}},
+			processed: 2,
+			states:    [2]string{"INITIALIZED", "SHIPPED"},
+			remaining: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t, &queuedTicket{})
+			AutoMigrateStateMachineQueuedTrigger(db)
+			tickets := [2]*queuedTicket{{}, {}}
+			for i, ticket := range tickets {
+				if err := db.Create(ticket).Error; err != nil {
+					t.Fatal(err)
+				}
+				for _, trigger := range tt.queued[i] {
+					if err := ticket.EnqueueTrigger(db, trigger, 1); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			processed, err := ProcessQueue(context.Background(), db, &queuedTicket{}, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if processed != tt.processed {
+				t.Errorf("processed %d triggers, want %d", processed, tt.processed)
+			}
+			for i, ticket := range tickets {
+				var stored queuedTicket
+				if err := db.First(&stored, ticket.ID).Error; err != nil {
+					t.Fatal(err)
+				}
+				if stored.State != tt.states[i] {
+					t.Errorf("ticket %d is %s, want %s", ticket.ID, stored.State, tt.states[i])
+				}
+			}
+			var remaining []StateMachineQueuedTrigger
+			if err := db.Order("id").Find(&remaining).Error; err != nil {
+				t.Fatal(err)
+			}
+			if len(remaining) != tt.remaining {
+				t.Fatalf("%d queued triggers remain, want %d", len(remaining), tt.remaining)
+			}
+			if len(remaining) > 0 && (remaining[0].Attempts != 1 || remaining[0].LastError == "") {
+				t.Errorf("failed trigger %s recorded %d attempts, error %q", remaining[0].Trigger, remaining[0].Attempts, remaining[0].LastError)
+			}
+		})
+	}
+}
+
+func TestEnqueueUnknownTrigger(t *testing.T) {
+	db := newTestDB(t, &queuedTicket{})
+	AutoMigrateStateMachineQueuedTrigger(db)
+	ticket := &queuedTicket{}
+	if err := db.Create(ticket).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := ticket.EnqueueTrigger(db, "refund", 1); !errors.Is(err, ErrTriggerNotFound) {
+		t.Errorf("EnqueueTrigger(refund): %v, want ErrTriggerNotFound", err)
+	}
+}
@@ -0,0 +1,57 @@
+// Package smredsync is a common.LockProvider on Redis, with the Redlock
+// algorithm of redsync, so that the instances of an application sharing
+// Redis transition an object one at a time.
+//
+//	client := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+//	sm.SetLockProvider(smredsync.NewRedis(client, redsync.WithExpiry(30*time.Second)))
+package smredsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/go-redsync/redsync/v4"
+	"github.com/go-redsync/redsync/v4/redis/goredis/v9"
+	"github.com/redis/go-redis/v9"
+
+	common "sm"
+)
+
+// Provider locks the objects with the mutexes of a Redsync.
+type Provider struct {
+	rs      *redsync.Redsync
+	options []redsync.Option
+}
+
+// New returns the provider of the mutexes of rs, created with options. The
+// expiry of the mutexes, 8s by default, must exceed the longest transition.
+func New(rs *redsync.Redsync, options ...redsync.Option) *Provider {
+	return &Provider{rs: rs, options: options}
+}
+
+// NewRedis returns the provider of the mutexes of a Redsync on the Redis of
+// client.
+func NewRedis(client redis.UniversalClient, options ...redsync.Option) *Provider {
+	return New(redsync.New(goredis.NewPool(client)), options...)
+}
+
+// Lock acquires the mutex named key, with the retries of its options. It
+// returns an error matching common.ErrLockNotAcquired if it is taken.
+func (p *Provider) Lock(ctx context.Context, key string) (func() error, error) {
+	mutex := p.rs.NewMutex(key, p.options...)
+	if err := mutex.LockContext(ctx); err != nil {
+		var taken *redsync.ErrTaken
+		if errors.Is(err, redsync.ErrFailed) || errors.As(err, &taken) {
+			return nil, fmt.Errorf("%w: %v", common.ErrLockNotAcquired, err)
+		}
+		return nil, err
+	}
+	return func() error {
+		// ctx may be done already.
+		if _, err := mutex.UnlockContext(context.Background()); err != nil {
+			return fmt.Errorf("unlock %s: %w", key, err)
+		}
+		return nil
+	}, nil
+}
@@ -0,0 +1,246 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+)
+
+// region is the part of a machine a trigger belongs to: the main definition,
+// whose state is GetState, or a parallel region stored in its own field.
+type region struct {
+	name       string
+	field      string
+	definition *Definition
+}
+
+// column returns the column of the region as accepted by Update: a column or
+// a field name.
+func (r *region) column(stater Stater) string {
+	if r.field != "" {
+		return r.field
+	}
+	if f := stateFieldOf(stater); f != nil {
+		return f.name
+	}
+	return "state"
+}
+
+// value returns state converted for the column of the region.
+func (r *region) value(stater Stater, state string) (interface{}, error) {
+	if r.field == "" {
+		if f := stateFieldOf(stater); f != nil {
+			return f.value(state)
+		}
+	}
+	return state, nil
+}
+
+func (r *region) stateField(stater Stater) (reflect.Value, error) {
+	ele := reflect.Indirect(reflect.ValueOf(stater))
+	if ele.Kind() == reflect.Struct {
+		if field := ele.FieldByName(r.field); field.Kind() == reflect.String && field.CanSet() {
+			return field, nil
+		}
+	}
+	return reflect.Value{}, fmt.Errorf("%s has no string field %s for region %s", StructName(stater), r.field, r.name)
+}
+
+func (r *region) state(stater Stater) string {
+	if r.field == "" {
+		return stater.GetState()
+	}
+	field, err := r.stateField(stater)
+	if err != nil {
+		return ""
+	}
+	return field.String()
+}
+
+func (r *region) setState(stater Stater, state string) error {
+	if r.field == "" {
+		if f := stateFieldOf(stater); f != nil {
+			if err := f.set(stater, state); err != nil {
+				return err
+			}
+		}
+		stater.SetState(state)
+		return nil
+	}
+	field, err := r.stateField(stater)
+	if err != nil {
+		return err
+	}
+	field.SetString(state)
+	return nil
+}
+
+// saveState returns a func restoring the state of stater in r, and its
+// version if it has one, to their current values: transitions failing after
+// setting them undo them, so that the object keeps matching its row once the
+// transaction rolls back.
+func (r *region) saveState(stater Stater) func() {
+	state := r.state(stater)
+	f := versionFieldOf(stater)
+	var version interface{}
+	if f != nil {
+		version = f.of(stater).Interface()
+	}
+	return func() {
+		_ = r.setState(stater, state)
+		if f != nil {
+			f.of(stater).Set(reflect.ValueOf(version))
+		}
+	}
+}
+
+func (d *Definition) mainRegion() *region {
+	return &region{definition: d}
+}
+
+// allRegions returns the main region followed by the parallel ones.
+func (d *Definition) allRegions() []*region {
+	return append([]*region{d.mainRegion()}, d.regions...)
+}
+
+func (d *Definition) regionOf(trigger string) (*region, bool) {
+	for _, r := range d.allRegions() {
+		if _, ok := r.definition.triggers[trigger]; ok {
+			return r, true
+		}
+	}
+	return nil, false
+}
+
+func (d *Definition) region(name string) (*region, bool) {
+	for _, r := range d.allRegions() {
+		if r.name == name {
+			return r, true
+		}
+	}
+	return nil, false
+}
+
+// Regions returns the names of the parallel regions.
+func (d *Definition) Regions() []string {
+	names := make([]string, 0, len(d.regions))
+	for _, r := range d.regions {
+		names = append(names, r.name)
+	}
+	return names
+}
+
+// Region returns the definition of a parallel region.
+func (d *Definition) Region(name string) (*Definition, bool) {
+	for _, r := range d.regions {
+		if r.name == name {
+			return r.definition, true
+		}
+	}
+	return nil, false
+}
+
+// NamedMachine is one of the machines of a model, the main one or a parallel
+// region, e.g. "review" and "payment" each stored in its own column.
+type NamedMachine struct {
+	sm   *StateMachine
+	name string
+}
+
+// Machine returns the machine of the model named name, the main one for "".
+func (sm *StateMachine) Machine(name string) *NamedMachine {
+	return &NamedMachine{sm: sm, name: name}
+}
+
+func (m *NamedMachine) Name() string {
+	return m.name
+}
+
+func (m *NamedMachine) region() (*region, error) {
+	definition, err := m.sm.Definition()
+	if err != nil {
+		return nil, err
+	}
+	return m.regionIn(definition)
+}
+
+// regionIn returns the region of this machine in definition, bound to the
+// object.
+func (m *NamedMachine) regionIn(definition *Definition) (*region, error) {
+	r, ok := definition.region(m.name)
+	if !ok {
+		return nil, fmt.Errorf("%s has no machine %s", StructName(m.sm.stater), m.name)
+	}
+	return r, nil
+}
+
+// owns fails unless trigger, or an alias of it, belongs to this machine of
+// definition.
+func (m *NamedMachine) owns(definition *Definition, trigger string) error {
+	r, err := m.regionIn(definition)
+	if err != nil {
+		return err
+	}
+	if _, ok := r.definition.triggers[r.definition.Canonical(trigger)]; !ok {
+		return fmt.Errorf("%w: %s in machine %s", ErrTriggerNotFound, trigger, m.name)
+	}
+	return nil
+}
+
+func (m *NamedMachine) State() (string, error) {
+	r, err := m.region()
+	if err != nil {
+		return "", err
+	}
+	return r.state(m.sm.stater), nil
+}
+
+func (m *NamedMachine) Definition() (*Definition, error) {
+	r, err := m.region()
+	if err != nil {
+		return nil, err
+	}
+	return r.definition, nil
+}
+
+func (m *NamedMachine) AvailableTriggers() []*AvailableTrigger {
+	definition, err := m.sm.Definition()
+	if err != nil {
+		return nil
+	}
+	r, err := m.regionIn(definition)
+	if err != nil {
+		return nil
+	}
+	return m.sm.availableIn(context.Background(), definition, r)
+}
+
+func (m *NamedMachine) CanDo(tx *gorm.DB, trigger string, args ...interface{}) (bool, error) {
+	definition, err := m.sm.Definition()
+	if err != nil {
+		return false, err
+	}
+	if err := m.owns(definition, trigger); err != nil {
+		return false, err
+	}
+	return m.sm.canDo(tx, definition, trigger, args...)
+}
+
+// Do fires trigger, which must belong to this machine.
+func (m *NamedMachine) Do(tx *gorm.DB, trigger string, userInfoId uint, args ...interface{}) error {
+	return m.DoCtx(contextOf(tx), tx, trigger, userInfoId, args...)
+}
+
+func (m *NamedMachine) DoCtx(ctx context.Context, tx *gorm.DB, trigger string, userInfoId uint, args ...interface{}) error {
+	definition, err := m.sm.Definition()
+	if err != nil {
+		return err
+	}
+	if err := m.owns(definition, trigger); err != nil {
+		return err
+	}
+	_, err = m.sm.fire(ctx, tx, definition, trigger, userInfoId, args...)
+	return err
+}
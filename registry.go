@@ -0,0 +1,91 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+var (
+	registryMu sync.RWMutex
+	callbacks  = map[string]CallbackFunc{}
+	conditions = map[string]ConditionFunc{}
+	guards     = map[string]GuardFunc{}
+)
+
+// RegisterCallback makes fn usable by name as a before or after callback, e.g.
+// "after": "notifyCustomer" in the map form. Registered callbacks are shared
+// by every object, use ObjectFromContext to reach the one transitioning.
+func RegisterCallback(name string, fn CallbackFunc) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	callbacks[name] = fn
+}
+
+// RegisterCondition makes fn usable by name as a condition.
+func RegisterCondition(name string, fn ConditionFunc) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	conditions[name] = fn
+}
+
+// RegisterGuard makes fn usable by name as a guard.
+func RegisterGuard(name string, fn GuardFunc) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	guards[name] = fn
+}
+
+func LookupCallback(name string) (CallbackFunc, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	fn, ok := callbacks[name]
+	return fn, ok
+}
+
+func LookupCondition(name string) (ConditionFunc, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	fn, ok := conditions[name]
+	return fn, ok
+}
+
+func LookupGuard(name string) (GuardFunc, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	fn, ok := guards[name]
+	return fn, ok
+}
+
+// NamedCallback refers to a registered callback, looked up when called so
+// that it may be registered after the definition is built.
+func NamedCallback(name string) CallbackFunc {
+	return func(ctx context.Context, tx *gorm.DB, args ...interface{}) error {
+		fn, ok := LookupCallback(name)
+		if !ok {
+			return fmt.Errorf("%w: callback %s", ErrUnknownCallback, name)
+		}
+		return fn(ctx, tx, args...)
+	}
+}
+
+// NamedCondition refers to a registered condition; an unknown one fails.
+func NamedCondition(name string) ConditionFunc {
+	return func(ctx context.Context, tx *gorm.DB, args ...interface{}) bool {
+		fn, ok := LookupCondition(name)
+		return ok && fn(ctx, tx, args...)
+	}
+}
+
+// NamedGuard refers to a registered guard.
+func NamedGuard(name string) GuardFunc {
+	return func(ctx context.Context, tx *gorm.DB, args ...interface{}) (bool, string, error) {
+		fn, ok := LookupGuard(name)
+		if !ok {
+			return false, "", fmt.Errorf("%w: guard %s", ErrUnknownCallback, name)
+		}
+		return fn(ctx, tx, args...)
+	}
+}
@@ -0,0 +1,95 @@
+package common
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// RegionReplay is the outcome of replaying the log of one region of an
+// object.
+type RegionReplay struct {
+	Region string
+	// Expected is the state the log leads to, Stored the one in the database.
+	Expected string
+	Stored   string
+	// Breaks are the IDs of the log entries whose source is not the state the
+	// previous entries led to.
+	Breaks []uint
+}
+
+func (r *RegionReplay) Diverged() bool {
+	return r.Expected != r.Stored || len(r.Breaks) > 0
+}
+
+// ReplayReport is returned by ReplayState.
+type ReplayReport struct {
+	ObjectStruct string
+	ObjectId     uint
+	// ObjectKey is the key of the objects replayed by ReplayStateKey.
+	ObjectKey string
+	Regions   []*RegionReplay
+}
+
+func (r *ReplayReport) Diverged() bool {
+	for _, region := range r.Regions {
+		if region.Diverged() {
+			return true
+		}
+	}
+	return false
+}
+
+// ReplayState rebuilds the states of the object of model's type with ID id
+// from its StateMachineLog, starting from the initial states, and compares
+// them with the stored ones, e.g. after a partial failure or a manual edit.
+func ReplayState(tx *gorm.DB, model Stater, id uint) (*ReplayReport, error) {
+	return replayState(tx, model, objectRef{id: id})
+}
+
+// ReplayStateKey is ReplayState for the objects whose primary key is not an
+// unsigned integer, see IDProvider.
+func ReplayStateKey(tx *gorm.DB, model Stater, key string) (*ReplayReport, error) {
+	return replayState(tx, model, objectRef{key: key})
+}
+
+func replayState(tx *gorm.DB, model Stater, ref objectRef) (*ReplayReport, error) {
+	obj, ok := newStater(model)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a state machine model", model)
+	}
+	if err := ref.load(tx, obj); err != nil {
+		return nil, fmt.Errorf("load %s %s: %w", StructName(obj), ref, err)
+	}
+	definition, err := definitionOf(obj)
+	if err != nil {
+		return nil, err
+	}
+	var entries []StateMachineLog
+	if err := ref.where(tx, StructName(obj)).Order("id").Find(&entries).Error; err != nil {
+		return nil, fmt.Errorf("read log of %s %s: %w", StructName(obj), ref, err)
+	}
+
+	report := &ReplayReport{ObjectStruct: StructName(obj), ObjectId: ref.id, ObjectKey: ref.key}
+	replays := map[string]*RegionReplay{}
+	for _, r := range definition.allRegions() {
+		initial := r.definition.InitialState()
+		if r.field == "" {
+			initial = initialStateOf(obj, definition)
+		}
+		replay := &RegionReplay{Region: r.name, Expected: initial, Stored: r.state(obj)}
+		replays[r.name] = replay
+		report.Regions = append(report.Regions, replay)
+	}
+	for _, entry := range entries {
+		replay, ok := replays[entry.Region]
+		if !ok {
+			return nil, fmt.Errorf("log entry %d of %s %s: unknown region %s", entry.ID, StructName(obj), ref, entry.Region)
+		}
+		if entry.Source != replay.Expected {
+			replay.Breaks = append(replay.Breaks, entry.ID)
+		}
+		replay.Expected = entry.Dest
+	}
+	return report, nil
+}
@@ -0,0 +1,90 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// purgeBatchSize is the number of log entries deleted per transaction, so
+// that purging a large table does not lock it for long.
+const purgeBatchSize = 1000
+
+// PurgeLogs deletes the StateMachineLog entries older than olderThan, soft
+// deleted ones included, restricted by the filters scopes:
+//
+//	PurgeLogs(db, 90*24*time.Hour, func(db *gorm.DB) *gorm.DB {
+//		return db.Where("object_struct = ?", "Order")
+//	})
+//
+// Revert and Replay cannot go past the purged entries.
+func PurgeLogs(tx *gorm.DB, olderThan time.Duration, filters ...func(*gorm.DB) *gorm.DB) (int64, error) {
+	return purgeLogs(tx, "", olderThan, filters)
+}
+
+// ArchiveLogs is PurgeLogs copying the entries to the table archive first,
+// which is created or migrated like state_machine_logs.
+func ArchiveLogs(tx *gorm.DB, archive string, olderThan time.Duration, filters ...func(*gorm.DB) *gorm.DB) (int64, error) {
+	if err := tx.Table(archive).AutoMigrate(&StateMachineLog{}); err != nil {
+		return 0, fmt.Errorf("migrate %s: %w", archive, err)
+	}
+	return purgeLogs(tx, archive, olderThan, filters)
+}
+
+func purgeLogs(tx *gorm.DB, archive string, olderThan time.Duration, filters []func(*gorm.DB) *gorm.DB) (int64, error) {
+	cutoff := time.Now().Add(-olderThan)
+	var purged int64
+	for {
+		var batch []StateMachineLog
+		if err := tx.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Unscoped().Model(&StateMachineLog{}).Scopes(filters...).
+				Where("created_at < ?", cutoff).Order("id").Limit(purgeBatchSize).
+				Find(&batch).Error; err != nil || len(batch) == 0 {
+				return err
+			}
+			if archive != "" {
+				if err := tx.Table(archive).Create(&batch).Error; err != nil {
+					return fmt.Errorf("archive logs to %s: %w", archive, err)
+				}
+			}
+			ids := make([]uint, len(batch))
+			for i, entry := range batch {
+				ids[i] = entry.ID
+			}
+			return tx.Unscoped().Delete(&StateMachineLog{}, ids).Error
+		}); err != nil {
+			return purged, fmt.Errorf("purge logs: %w", err)
+		}
+		purged += int64(len(batch))
+		if len(batch) < purgeBatchSize {
+			return purged, nil
+		}
+	}
+}
+
+// RunLogRetention purges, or archives to archive if not empty, the entries
+// older than olderThan every interval until ctx is done. Errors are reported
+// to onError, which may be nil.
+func RunLogRetention(ctx context.Context, db *gorm.DB, interval, olderThan time.Duration, archive string, onError func(error), filters ...func(*gorm.DB) *gorm.DB) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	db = db.WithContext(ctx)
+	for {
+		var err error
+		if archive != "" {
+			_, err = ArchiveLogs(db, archive, olderThan, filters...)
+		} else {
+			_, err = PurgeLogs(db, olderThan, filters...)
+		}
+		if err != nil && onError != nil && ctx.Err() == nil {
+			onError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RetryPolicy retries the state UPDATE and the log insert of Do when they
+// fail transiently, e.g. on a deadlock, so that the transition does not fail
+// for it. Inside a transaction, each attempt runs in a savepoint; the
+// databases aborting the whole transaction on such errors, like MySQL on a
+// deadlock, fail the retries, and the transaction itself must be retried.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts, the first included,
+	// DefaultRetryMaxAttempts if 0.
+	MaxAttempts int
+	// Backoff returns the delay before the attempt following the attempt-th,
+	// from 1, ExponentialBackoff(DefaultRetryDelay, DefaultRetryMaxDelay) if
+	// nil.
+	Backoff func(attempt int) time.Duration
+	// Retryable reports whether an error is worth retrying, IsTransient if
+	// nil.
+	Retryable func(err error) bool
+}
+
+const (
+	DefaultRetryMaxAttempts = 3
+	DefaultRetryDelay       = 10 * time.Millisecond
+	DefaultRetryMaxDelay    = time.Second
+)
+
+var (
+	retryPolicyMu sync.RWMutex
+	retryPolicy   *RetryPolicy
+)
+
+// SetRetryPolicy sets the retry policy of every transition; nil, the default,
+// disables the retries.
+func SetRetryPolicy(policy *RetryPolicy) {
+	retryPolicyMu.Lock()
+	defer retryPolicyMu.Unlock()
+	retryPolicy = policy
+}
+
+func currentRetryPolicy() *RetryPolicy {
+	retryPolicyMu.RLock()
+	defer retryPolicyMu.RUnlock()
+	return retryPolicy
+}
+
+// ExponentialBackoff returns the Backoff waiting delay before the first
+// retry, doubled after each one up to max.
+func ExponentialBackoff(delay, max time.Duration) func(attempt int) time.Duration {
+	return func(attempt int) time.Duration {
+		d := delay
+		for i := 1; i < attempt && d < max; i++ {
+			d <<= 1
+		}
+		if d > max {
+			return max
+		}
+		return d
+	}
+}
+
+// sqlStater is implemented by the errors of the PostgreSQL drivers.
+type sqlStater interface {
+	SQLState() string
+}
+
+// transientErrors are the messages of the drivers for the deadlocks,
+// serialization failures and lock timeouts.
+var transientErrors = []string{
+	"deadlock",
+	"could not serialize access",
+	"serialization failure",
+	"lock wait timeout exceeded",
+	"database is locked",
+	"database table is locked",
+	"sqlstate 40001",
+	"sqlstate 40p01",
+	"error 1205",
+	"error 1213",
+}
+
+// IsTransient reports whether err is a deadlock, a serialization failure or a
+// lock timeout, which may succeed if retried. The errors of sm, like
+// ErrConcurrentModification, are not.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, ErrConcurrentModification) || errors.Is(err, ErrObjectDeleted) {
+		return false
+	}
+	var state sqlStater
+	if errors.As(err, &state) {
+		switch state.SQLState() {
+		case "40001", "40P01", "55P03":
+			return true
+		}
+	}
+	msg := strings.ToLower(err.Error())
+	for _, transient := range transientErrors {
+		if strings.Contains(msg, transient) {
+			return true
+		}
+	}
+	return false
+}
+
+// retrying runs fn as described by the retry policy, if any.
+func retrying(ctx context.Context, tx *gorm.DB, fn func(tx *gorm.DB) error) error {
+	policy := currentRetryPolicy()
+	if policy == nil {
+		return fn(tx)
+	}
+	maxAttempts, backoff, retryable := policy.MaxAttempts, policy.Backoff, policy.Retryable
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultRetryMaxAttempts
+	}
+	if backoff == nil {
+		backoff = ExponentialBackoff(DefaultRetryDelay, DefaultRetryMaxDelay)
+	}
+	if retryable == nil {
+		retryable = IsTransient
+	}
+	_, inTransaction := tx.Statement.ConnPool.(gorm.TxCommitter)
+	for attempt := 1; ; attempt++ {
+		var err error
+		if inTransaction {
+			err = tx.Transaction(fn)
+		} else {
+			err = fn(tx)
+		}
+		if err == nil || attempt >= maxAttempts || !retryable(err) {
+			return err
+		}
+		currentLogger().Debug("retry", "attempt", attempt, "error", err)
+		timer := time.NewTimer(backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
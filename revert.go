@@ -0,0 +1,160 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// RevertTrigger is the trigger of the log entries written by Revert.
+const RevertTrigger = "$revert"
+
+// Revert undoes the last transition of the object: its previous state is
+// restored and a compensating log entry is written, with reason. It is only
+// allowed if a trigger could lead back to that state now; callbacks are not
+// run, global hooks are, with RevertTrigger as trigger.
+func (sm *StateMachine) Revert(tx *gorm.DB, userInfoId uint, reason string) error {
+	return sm.revert(tx, userInfoId, reason, false)
+}
+
+// ForceRevert is Revert without checking that a trigger leads back, e.g. for
+// administrators fixing a mistake, or to leave a final state.
+func (sm *StateMachine) ForceRevert(tx *gorm.DB, userInfoId uint, reason string) error {
+	return sm.revert(tx, userInfoId, reason, true)
+}
+
+func (sm *StateMachine) revert(tx *gorm.DB, userInfoId uint, reason string, force bool) error {
+	ctx := contextOf(tx)
+	if inTransition(ctx, sm.stater) {
+		return fmt.Errorf("%w: revert of %s", ErrNestedTransition, StructName(sm.stater))
+	}
+	definition, err := sm.Definition()
+	if err != nil {
+		return err
+	}
+	query, err := whereObject(tx, sm.stater)
+	if err != nil {
+		return err
+	}
+	var last StateMachineLog
+	if err := query.Where("source <> dest").Order("id DESC").First(&last).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			key, _ := primaryKey(sm.stater)
+			return fmt.Errorf("%w: %s %s has no transition", ErrNothingToRevert, StructName(sm.stater), key)
+		}
+		return fmt.Errorf("read last transition of %s: %w", StructName(sm.stater), err)
+	}
+	r, ok := definition.region(last.Region)
+	if !ok {
+		return fmt.Errorf("%s has no region %s", StructName(sm.stater), last.Region)
+	}
+	current := r.state(sm.stater)
+	if current != last.Dest {
+		return fmt.Errorf("%w: %s is %s, not %s as last logged", ErrRevertNotAllowed, StructName(sm.stater), current, last.Dest)
+	}
+	if !force && !sm.canReach(tx, definition, r, last.Source) {
+		return fmt.Errorf("%w: no trigger leads %s back from %s to %s", ErrRevertNotAllowed, StructName(sm.stater), current, last.Source)
+	}
+
+	ctx, published := publishing(ctx)
+	err = atomically(tx, []Stater{sm.stater}, func(tx *gorm.DB) error {
+		return sm.overwrite(ctx, tx, r, RevertTrigger, current, last.Source, userInfoId, reason)
+	})
+	published(err)
+	return err
+}
+
+// ForceTrigger is the trigger of the log entries written by ForceState.
+const ForceTrigger = "$force"
+
+// ForceState moves the object to state whatever its current one, e.g. for
+// administrators repairing an object no trigger can fix. Like Revert it
+// writes a log entry with reason and runs the global hooks only, with
+// ForceTrigger as trigger.
+func (sm *StateMachine) ForceState(tx *gorm.DB, state string, userInfoId uint, reason string) error {
+	ctx := contextOf(tx)
+	if inTransition(ctx, sm.stater) {
+		return fmt.Errorf("%w: forcing %s", ErrNestedTransition, StructName(sm.stater))
+	}
+	definition, err := sm.Definition()
+	if err != nil {
+		return err
+	}
+	r := definition.mainRegion()
+	if len(definition.states) > 0 && !definition.HasState(state) {
+		return fmt.Errorf("%w: %s", ErrUnknownState, state)
+	}
+	ctx, published := publishing(ctx)
+	err = atomically(tx, []Stater{sm.stater}, func(tx *gorm.DB) error {
+		return sm.overwrite(ctx, tx, r, ForceTrigger, r.state(sm.stater), state, userInfoId, reason)
+	})
+	published(err)
+	return err
+}
+
+// overwrite stores dest as the state of region r without any check, logging
+// the change under trigger.
+func (sm *StateMachine) overwrite(ctx context.Context, tx *gorm.DB, r *region, trigger, current, dest string, userInfoId uint, reason string) (err error) {
+	if err := checkDeleted(tx, sm.stater); err != nil {
+		return err
+	}
+	ctx = withTransition(ctx, sm.stater)
+	tx = tx.WithContext(ctx)
+	event := &TransitionEvent{
+		Object:     sm.stater,
+		Region:     r.name,
+		Trigger:    trigger,
+		Source:     current,
+		Dest:       dest,
+		OperatorId: userInfoId,
+	}
+	if err := runHooks(ctx, tx, &beforeHooks, event); err != nil {
+		return err
+	}
+	restore := r.saveState(sm.stater)
+	defer func() {
+		if err != nil {
+			restore()
+		}
+	}()
+	if err := r.setState(sm.stater, dest); err != nil {
+		return err
+	}
+	if err := updateState(tx, sm.stater, r, current, dest); err != nil {
+		return err
+	}
+	entry := &StateMachineLog{
+		Region:     r.name,
+		Trigger:    trigger,
+		Source:     current,
+		Dest:       dest,
+		OperatorId: userInfoId,
+		Reason:     reason,
+	}
+	if err := sm.log(tx, entry); err != nil {
+		return err
+	}
+	event.LogId = entry.ID
+	return runHooks(ctx, tx, &afterHooks, event)
+}
+
+// canReach reports whether a trigger of region r of definition the object
+// accepts now leads to state.
+func (sm *StateMachine) canReach(tx *gorm.DB, definition *Definition, r *region, state string) bool {
+	for _, trigger := range r.definition.order {
+		config := r.definition.triggers[trigger]
+		leads := config.Dest == state && !config.Internal && config.DestFunc == nil
+		for _, branch := range config.Branches {
+			leads = leads || branch.Dest == state
+		}
+		if !leads {
+			continue
+		}
+		if _, err := sm.check(contextOf(tx), tx, definition, r, trigger); err == nil {
+			return true
+		}
+	}
+	return false
+}
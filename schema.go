@@ -0,0 +1,95 @@
+package common
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// schemas caches the gorm schemas of the models, parsed with the default
+// naming strategy: only their fields are read from them, not their columns.
+var schemas sync.Map
+
+// schemaOf returns the gorm schema of stater, so that its fields are found
+// as gorm finds them, in embedded structs or renamed by tags.
+func schemaOf(stater Stater) (*schema.Schema, error) {
+	s, err := schema.Parse(stater, &schemas, schema.NamingStrategy{})
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", StructName(stater), err)
+	}
+	return s, nil
+}
+
+// fieldOf returns the field name of stater, invalid if it has none.
+func fieldOf(stater Stater, name string) reflect.Value {
+	ele := reflect.Indirect(reflect.ValueOf(stater))
+	if ele.Kind() != reflect.Struct {
+		return reflect.Value{}
+	}
+	s, err := schemaOf(stater)
+	if err != nil {
+		return reflect.Value{}
+	}
+	f := s.LookUpField(name)
+	if f == nil {
+		return reflect.Value{}
+	}
+	return valueOfField(f, ele)
+}
+
+// valueOfField returns field f of struct value v, following the path of the
+// embedded structs gorm resolved it through. It is invalid if one of them is
+// a nil pointer.
+func valueOfField(f *schema.Field, v reflect.Value) reflect.Value {
+	for _, i := range f.StructField.Index {
+		if i < 0 {
+			// Embedded pointers have negative indexes.
+			i = -i - 1
+		}
+		if v = reflect.Indirect(v); !v.IsValid() {
+			return v
+		}
+		v = v.Field(i)
+	}
+	return v
+}
+
+// primaryField returns the primary key field of stater, the first one if it
+// has several.
+func primaryField(stater Stater) (reflect.Value, error) {
+	ele := reflect.Indirect(reflect.ValueOf(stater))
+	if ele.Kind() != reflect.Struct {
+		return reflect.Value{}, fmt.Errorf("%s is not a struct", StructName(stater))
+	}
+	s, err := schemaOf(stater)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	if s.PrioritizedPrimaryField == nil {
+		return reflect.Value{}, fmt.Errorf("%s has no primary key", StructName(stater))
+	}
+	field := valueOfField(s.PrioritizedPrimaryField, ele)
+	if !field.IsValid() {
+		return field, fmt.Errorf("%s has a nil embedded primary key", StructName(stater))
+	}
+	return field, nil
+}
+
+// hookedStater returns the model a hook registered by StateMachine runs for:
+// gorm runs the hooks of the models of a slice one by one, the current one
+// being at Statement.CurDestIndex.
+func hookedStater(tx *gorm.DB, value reflect.Value) (Stater, error) {
+	ele := reflect.Indirect(value)
+	if kind := ele.Kind(); (kind == reflect.Slice || kind == reflect.Array) && tx.Statement.CurDestIndex < ele.Len() {
+		ele = reflect.Indirect(ele.Index(tx.Statement.CurDestIndex))
+	}
+	if ele.Kind() == reflect.Struct && ele.CanAddr() {
+		if s, ok := ele.Addr().Interface().(Stater); ok {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("StateMachine unknown type %s", value.Type())
+}
@@ -0,0 +1,147 @@
+package common
+
+import (
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ScopeStateGroup restricts a query to the rows whose state is in group of
+// the queried model's machine:
+//
+//	db.Scopes(ScopeStateGroup("OPEN")).Find(&orders)
+func ScopeStateGroup(group string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		stater, definition, ok := scopeModel(db, "ScopeStateGroup")
+		if !ok {
+			return db
+		}
+		states, ok := definition.Group(group)
+		if !ok {
+			_ = db.AddError(fmt.Errorf("%w: group %s of %s", ErrUnknownState, group, StructName(stater)))
+			return db
+		}
+		return scopeStates(db, stater, definition, states)
+	}
+}
+
+// ScopeState restricts a query to the rows in one of states:
+//
+//	db.Scopes(ScopeState("PAID", "SHIPPED")).Find(&orders)
+func ScopeState(states ...string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		stater, definition, ok := scopeModel(db, "ScopeState")
+		if !ok {
+			return db
+		}
+		return scopeStates(db, stater, definition, states)
+	}
+}
+
+func scopeModel(db *gorm.DB, scope string) (Stater, *Definition, bool) {
+	model := db.Statement.Model
+	if model == nil {
+		model = db.Statement.Dest
+	}
+	stater, ok := newStater(model)
+	if !ok {
+		_ = db.AddError(fmt.Errorf("%s: %T is not a state machine model", scope, model))
+		return nil, nil, false
+	}
+	definition, err := definitionOf(stater)
+	if err != nil {
+		_ = db.AddError(err)
+		return nil, nil, false
+	}
+	return stater, definition, true
+}
+
+func scopeStates(db *gorm.DB, stater Stater, definition *Definition, states []string) *gorm.DB {
+	r := definition.mainRegion()
+	values := make([]interface{}, len(states))
+	for i, state := range states {
+		var err error
+		if values[i], err = r.value(stater, state); err != nil {
+			_ = db.AddError(err)
+			return db
+		}
+	}
+	column, err := stateColumn(db, stater)
+	if err != nil {
+		_ = db.AddError(err)
+		return db
+	}
+	return db.Where(clause.IN{Column: clause.Column{Table: clause.CurrentTable, Name: column}, Values: values})
+}
+
+// stateColumn returns the column of the main state of stater.
+func stateColumn(db *gorm.DB, stater Stater) (string, error) {
+	f := stateFieldOf(stater)
+	if f == nil {
+		return "state", nil
+	}
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(stater); err != nil {
+		return "", err
+	}
+	return stmt.Schema.LookUpField(f.name).DBName, nil
+}
+
+// CountByState counts the objects of the type of model in each state. States
+// without objects are left out.
+func CountByState(tx *gorm.DB, model Stater) (map[string]int64, error) {
+	stater, ok := newStater(model)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a state machine model", model)
+	}
+	column, err := stateColumn(tx, stater)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.Model(stater).Select(column + ", COUNT(*)").Group(column).Rows()
+	if err != nil {
+		return nil, fmt.Errorf("count %s by state: %w", StructName(stater), err)
+	}
+	defer rows.Close()
+
+	f := stateFieldOf(stater)
+	counts := map[string]int64{}
+	for rows.Next() {
+		var state string
+		var count int64
+		if f != nil {
+			value := reflect.New(f.typ)
+			if err := rows.Scan(value.Interface(), &count); err != nil {
+				return nil, err
+			}
+			f.of(stater).Set(value.Elem())
+			state = f.get(stater)
+		} else if err := rows.Scan(&state, &count); err != nil {
+			return nil, err
+		}
+		counts[state] = count
+	}
+	return counts, rows.Err()
+}
+
+// newStater returns a new model of the type of value, a model or a slice of
+// models.
+func newStater(value interface{}) (Stater, bool) {
+	if value == nil {
+		return nil, false
+	}
+	t := reflect.TypeOf(value)
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, false
+	}
+	stater, ok := reflect.New(t).Interface().(Stater)
+	if ok {
+		stater.SetStater(stater)
+	}
+	return stater, ok
+}
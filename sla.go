@@ -0,0 +1,168 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SLA requires the objects of a machine to leave State, of its main region,
+// within a duration of entering it.
+type SLA struct {
+	State  string
+	Within time.Duration
+	// Escalate is the trigger fired on the objects breaching the SLA, none if
+	// empty.
+	Escalate string
+}
+
+// SLADeclarer is implemented by staters declaring SLAs without a Definition.
+type SLADeclarer interface {
+	SLAs() []SLA
+}
+
+// SLAs returns the SLAs of the machine, in declaration order.
+func (d *Definition) SLAs() []SLA {
+	return d.slas
+}
+
+// SLA declares that objects must leave state within a duration of entering
+// it, see CheckSLAs; escalate, if not empty, is the trigger fired on the
+// objects breaching it.
+func (b *DefinitionBuilder) SLA(state string, within time.Duration, escalate string) *DefinitionBuilder {
+	b.definition.slas = append(b.definition.slas, SLA{State: state, Within: within, Escalate: escalate})
+	return b
+}
+
+// SLABreach is an object found in the state of an SLA past its duration.
+type SLABreach struct {
+	SLA
+	StuckObject
+}
+
+type SLABreachHook func(ctx context.Context, tx *gorm.DB, breach *SLABreach) error
+
+var (
+	slaHooksMu sync.RWMutex
+	slaHooks   []SLABreachHook
+)
+
+// OnSLABreach registers hook to run once per breach found by CheckSLAs, before
+// its escalation. An error aborts both, retried by the next check.
+func OnSLABreach(hook SLABreachHook) {
+	slaHooksMu.Lock()
+	defer slaHooksMu.Unlock()
+	slaHooks = append(slaHooks, hook)
+}
+
+// StateMachineSLABreach records a breach handled by CheckSLAs, so that it is
+// handled once while the object stays in the state.
+type StateMachineSLABreach struct {
+	gorm.Model
+	ObjectId     uint      `gorm:"not null; uniqueIndex:idx_sla_breach"`
+	ObjectStruct string    `gorm:"not null; uniqueIndex:idx_sla_breach; varchar(64)"`
+	State        string    `gorm:"not null; uniqueIndex:idx_sla_breach; varchar(64)"`
+	Since        time.Time `gorm:"not null; uniqueIndex:idx_sla_breach"`
+}
+
+func AutoMigrateStateMachineSLABreach(tx *gorm.DB) {
+	if err := tx.AutoMigrate(&StateMachineSLABreach{}); err != nil {
+		panic(err)
+	}
+}
+
+// CheckSLAs finds the objects of the types of models breaching the SLAs of
+// their machine, and handles each breach once, in its own transaction: the
+// OnSLABreach hooks run, then the escalation trigger is fired. It returns
+// the number of breaches handled.
+func CheckSLAs(ctx context.Context, db *gorm.DB, models ...Stater) (int, error) {
+	db = db.WithContext(ctx)
+	handled := 0
+	for _, model := range models {
+		stater, ok := newStater(model)
+		if !ok {
+			return handled, fmt.Errorf("%T is not a state machine model", model)
+		}
+		definition, err := definitionOf(stater)
+		if err != nil {
+			return handled, err
+		}
+		for _, sla := range definition.SLAs() {
+			stuck, err := FindStuck(db, stater, sla.State, sla.Within)
+			if err != nil {
+				return handled, err
+			}
+			for _, s := range stuck {
+				if err := ctx.Err(); err != nil {
+					return handled, err
+				}
+				breach := &SLABreach{SLA: sla, StuckObject: s}
+				var first bool
+				if err := db.Transaction(func(tx *gorm.DB) error {
+					first, err = handleBreach(ctx, tx, breach)
+					return err
+				}); err != nil {
+					id, _ := objectId(s.Object)
+					return handled, fmt.Errorf("handle SLA breach of %s %d in %s: %w", StructName(s.Object), id, sla.State, err)
+				}
+				if first {
+					handled++
+				}
+			}
+		}
+	}
+	return handled, nil
+}
+
+func handleBreach(ctx context.Context, tx *gorm.DB, breach *SLABreach) (bool, error) {
+	id, err := objectId(breach.Object)
+	if err != nil {
+		return false, err
+	}
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&StateMachineSLABreach{
+		ObjectId:     id,
+		ObjectStruct: StructName(breach.Object),
+		State:        breach.State,
+		Since:        breach.Since,
+	})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+
+	slaHooksMu.RLock()
+	registered := slaHooks
+	slaHooksMu.RUnlock()
+	for _, hook := range registered {
+		if err := hook(ctx, tx, breach); err != nil {
+			return false, err
+		}
+	}
+	if breach.Escalate == "" {
+		return true, nil
+	}
+	if ReasonFromContext(ctx) == "" {
+		ctx = WithReason(ctx, fmt.Sprintf("SLA of %s breached: in it for more than %s", breach.State, breach.Within))
+	}
+	return true, Escalate(breach.Escalate, 0)(ctx, tx, breach.StuckObject)
+}
+
+// RunSLAs calls CheckSLAs every interval until ctx is done. Errors are
+// reported to onError, which may be nil.
+func RunSLAs(ctx context.Context, db *gorm.DB, interval time.Duration, onError func(error), models ...Stater) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := CheckSLAs(ctx, db, models...); err != nil && onError != nil && ctx.Err() == nil {
+			onError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package common
+
+import "fmt"
+
+// Snapshot is the serializable view of a machine instance, e.g. for API
+// responses or test fixtures. Marshaling StateMachine itself would replace
+// the JSON of the models embedding it.
+type Snapshot struct {
+	State           string
+	TranslatedState string
+	// Regions holds the states of the parallel regions by name.
+	Regions           map[string]string `json:",omitempty"`
+	AvailableTriggers []*AvailableTrigger
+}
+
+func (sm *StateMachine) Snapshot() *Snapshot {
+	snapshot := &Snapshot{
+		State:             sm.stater.GetState(),
+		TranslatedState:   sm.TranslatedState(),
+		AvailableTriggers: sm.AvailableTriggers(),
+	}
+	if definition, err := sm.Definition(); err == nil && len(definition.regions) > 0 {
+		snapshot.Regions = map[string]string{}
+		for _, r := range definition.regions {
+			snapshot.Regions[r.name] = r.state(sm.stater)
+		}
+	}
+	return snapshot
+}
+
+// Restore sets the states of the object from snapshot, in memory only.
+// Translations and triggers of the snapshot are ignored.
+func (sm *StateMachine) Restore(snapshot *Snapshot) error {
+	definition, err := sm.Definition()
+	if err != nil {
+		return err
+	}
+	states := map[*region]string{definition.mainRegion(): snapshot.State}
+	for name, state := range snapshot.Regions {
+		r, ok := definition.region(name)
+		if !ok || r.field == "" {
+			return fmt.Errorf("%s has no region %s", StructName(sm.stater), name)
+		}
+		states[r] = state
+	}
+	for r, state := range states {
+		if len(r.definition.states) > 0 && !r.definition.HasState(state) {
+			return fmt.Errorf("%w: %s", ErrUnknownState, state)
+		}
+	}
+	for r, state := range states {
+		if err := r.setState(sm.stater, state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
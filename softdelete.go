@@ -0,0 +1,40 @@
+package common
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"gorm.io/gorm"
+)
+
+var refuseDeleted int32
+
+// RefuseDeleted makes Do, BatchDo, Revert and ForceState return
+// ErrObjectDeleted for the objects soft deleted with gorm.DeletedAt, unless
+// called with tx.Unscoped(), which also lets their UPDATE reach the deleted
+// rows. Otherwise, the default, it is only returned once their UPDATE found
+// no row, after their Before callbacks.
+func RefuseDeleted(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&refuseDeleted, v)
+}
+
+// checkDeleted returns ErrObjectDeleted if stater is soft deleted and
+// RefuseDeleted is enabled.
+func checkDeleted(tx *gorm.DB, stater Stater) error {
+	if atomic.LoadInt32(&refuseDeleted) == 0 || tx.Statement.Unscoped {
+		return nil
+	}
+	f := fieldOf(stater, "DeletedAt")
+	if !f.IsValid() {
+		return nil
+	}
+	if deletedAt, ok := f.Interface().(gorm.DeletedAt); ok && deletedAt.Valid {
+		key, _ := primaryKey(stater)
+		return fmt.Errorf("%w: %s %s", ErrObjectDeleted, StructName(stater), key)
+	}
+	return nil
+}
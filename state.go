@@ -0,0 +1,102 @@
+package common
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// StateType is implemented by pointers to custom state types that are not
+// strings, e.g. integer enums, declared as the field tagged sm:"state".
+// Typed strings need not implement it. New models get the initial state only
+// if String returns "" for the zero value.
+//
+//	type OrderState int
+//
+//	func (s OrderState) String() string { return orderStateNames[s] }
+//	func (s *OrderState) ParseState(name string) error { ... }
+type StateType interface {
+	String() string
+	ParseState(name string) error
+}
+
+var stateTypeType = reflect.TypeOf((*StateType)(nil)).Elem()
+
+// stateField is the field of a model tagged sm:"state". Models storing their
+// state in a column of their own declare such a field and embed StateMachine
+// with gorm:"-":
+//
+//	type Order struct {
+//		gorm.Model
+//		common.StateMachine `gorm:"-"`
+//		Status OrderState `gorm:"column:order_status" sm:"state"`
+//	}
+type stateField struct {
+	name string
+	typ  reflect.Type
+	// custom fields implement StateType, the others are typed strings.
+	custom bool
+}
+
+// stateFields caches the *stateField of each model type, nil if it has none.
+var stateFields sync.Map
+
+func stateFieldOf(stater Stater) *stateField {
+	t := reflect.TypeOf(stater)
+	if f, ok := stateFields.Load(t); ok {
+		return f.(*stateField)
+	}
+	var field *stateField
+	st := t
+	if st.Kind() == reflect.Ptr {
+		st = st.Elem()
+	}
+	if st.Kind() == reflect.Struct {
+		for i := 0; i < st.NumField(); i++ {
+			f := st.Field(i)
+			if f.Tag.Get("sm") != "state" || f.PkgPath != "" {
+				continue
+			}
+			if custom := reflect.PtrTo(f.Type).Implements(stateTypeType); custom || f.Type.Kind() == reflect.String {
+				field = &stateField{name: f.Name, typ: f.Type, custom: custom}
+				break
+			}
+		}
+	}
+	stateFields.Store(t, field)
+	return field
+}
+
+func (f *stateField) of(stater Stater) reflect.Value {
+	return reflect.Indirect(reflect.ValueOf(stater)).FieldByName(f.name)
+}
+
+func (f *stateField) get(stater Stater) string {
+	v := f.of(stater)
+	if f.custom {
+		return v.Addr().Interface().(StateType).String()
+	}
+	return v.String()
+}
+
+func (f *stateField) set(stater Stater, state string) error {
+	value, err := f.value(state)
+	if err != nil {
+		return err
+	}
+	f.of(stater).Set(reflect.ValueOf(value))
+	return nil
+}
+
+// value converts state to the type of the field, as stored in the database.
+func (f *stateField) value(state string) (interface{}, error) {
+	v := reflect.New(f.typ)
+	if f.custom {
+		if err := v.Interface().(StateType).ParseState(state); err != nil {
+			return nil, fmt.Errorf("%w: %s as %s: %v", ErrUnknownState, state, f.typ, err)
+		}
+	} else {
+		v.Elem().SetString(state)
+	}
+	return v.Elem().Interface(), nil
+}
@@ -0,0 +1,95 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// StateUpdate is a state change to persist, already made on Object.
+type StateUpdate struct {
+	Object Stater
+	// Region is the region of the change, empty for the main one, and Field
+	// the field of its state.
+	Region string
+	Field  string
+	// Source and Dest are the states before and after the change, and From
+	// and To their values in Field, which differ for the main state of
+	// models with a sm:"state" field of a StateType.
+	Source, Dest string
+	From, To     interface{}
+	// VersionField is the name of the sm:"version" field of Object, if it
+	// has one, and Version its value before the change.
+	VersionField string
+	Version      interface{}
+}
+
+// Store persists the transitions: their state changes, with UpdateState, and
+// their log entries, with the AuditSink it embeds, unless one is set with
+// SetAuditSink. tx is the *gorm.DB of the transition, which stores on other
+// databases ignore but for its context.
+type Store interface {
+	AuditSink
+	// UpdateState stores update provided the object is still in its Source,
+	// and in Version if any, which it then increments, and returns an error
+	// matching ErrConcurrentModification if it was not.
+	UpdateState(ctx context.Context, tx *gorm.DB, update StateUpdate) error
+}
+
+// GormStore, the default, updates the tables of the models with tx, and logs
+// in the state_machine_logs table.
+type GormStore struct {
+	GormSink
+}
+
+func (GormStore) UpdateState(_ context.Context, tx *gorm.DB, u StateUpdate) error {
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(u.Object); err != nil {
+		return err
+	}
+	column := stmt.Schema.LookUpField(u.Field).DBName
+	query := tx.Model(u.Object).Omit(clause.Associations).
+		Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: column}, Value: u.From})
+	updates := map[string]interface{}{column: u.To}
+	if u.VersionField != "" {
+		version := stmt.Schema.LookUpField(u.VersionField).DBName
+		query = query.Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: version}, Value: u.Version})
+		updates[version] = gorm.Expr(stmt.Quote(version)+" + ?", 1)
+	}
+	result := query.Updates(updates)
+	if result.Error != nil {
+		return fmt.Errorf("update state of %s: %w", StructName(u.Object), result.Error)
+	}
+	// MySQL counts the rows changed, not matched: a reentrant transition
+	// without version changes none.
+	if result.RowsAffected == 0 && (u.VersionField != "" || u.Source != u.Dest) {
+		return conflictOf(tx, u.Object)
+	}
+	return nil
+}
+
+var (
+	storeMu sync.RWMutex
+	store   Store = GormStore{}
+)
+
+// SetStore sets the store persisting every transition; nil restores the
+// GormStore. The features querying the log or the models, like History,
+// FindStuck or WithRowLock, still read them with gorm.
+func SetStore(s Store) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	if s == nil {
+		s = GormStore{}
+	}
+	store = s
+}
+
+func currentStore() Store {
+	storeMu.RLock()
+	defer storeMu.RUnlock()
+	return store
+}
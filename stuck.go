@@ -0,0 +1,144 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// StuckObject is an object found by FindStuck.
+type StuckObject struct {
+	Object Stater
+	// Since is when the object entered its state: the last log entry doing
+	// so, or its CreatedAt if it has none.
+	Since time.Time
+}
+
+// FindStuck returns the objects of model's type that have been in state, of
+// their main region, for longer than threshold, loaded. Objects without log
+// entry entering state are stuck since their CreatedAt field, and ignored if
+// they have none.
+func FindStuck(tx *gorm.DB, model Stater, state string, threshold time.Duration) ([]StuckObject, error) {
+	stater, ok := newStater(model)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a state machine model", model)
+	}
+	name := StructName(stater)
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(stater); err != nil {
+		return nil, err
+	}
+	if stmt.Schema.PrioritizedPrimaryField == nil {
+		return nil, fmt.Errorf("%s has no primary key", name)
+	}
+	pk := tx.Statement.Quote(clause.Column{Table: stmt.Schema.Table, Name: stmt.Schema.PrioritizedPrimaryField.DBName})
+	createdAt := stmt.Schema.LookUpField("CreatedAt")
+	since := "l.since"
+	if createdAt != nil {
+		since = "COALESCE(l.since, " + tx.Statement.Quote(clause.Column{Table: stmt.Schema.Table, Name: createdAt.DBName}) + ")"
+	}
+
+	entered := tx.Session(&gorm.Session{NewDB: true}).Model(&StateMachineLog{}).
+		Select("object_id, MAX(created_at) AS since").
+		Where("object_struct = ? AND region = '' AND dest = ? AND source <> dest", name, state).
+		Group("object_id")
+	objs := reflect.New(reflect.SliceOf(reflect.TypeOf(stater)))
+	if err := tx.Model(stater).Scopes(ScopeState(state)).Select(tx.Statement.Quote(stmt.Schema.Table)+".*").
+		Joins("LEFT JOIN (?) l ON l.object_id = "+pk, entered).
+		Where(since+" < ?", time.Now().Add(-threshold)).
+		Order(pk).Find(objs.Interface()).Error; err != nil {
+		return nil, fmt.Errorf("find stuck %s: %w", name, err)
+	}
+	if objs.Elem().Len() == 0 {
+		return nil, nil
+	}
+
+	// The entry times are read apart: the drivers do not all scan aggregated
+	// timestamps as time.Time.
+	stuck := make([]StuckObject, objs.Elem().Len())
+	byId := map[uint]*StuckObject{}
+	ids := make([]uint, len(stuck))
+	for i := range stuck {
+		obj := objs.Elem().Index(i).Interface().(Stater)
+		id, err := objectId(obj)
+		if err != nil {
+			return nil, err
+		}
+		stuck[i].Object, ids[i], byId[id] = obj, id, &stuck[i]
+		if createdAt != nil {
+			stuck[i].Since, _ = valueOf(valueOfField(createdAt, reflect.ValueOf(obj))).(time.Time)
+		}
+	}
+	var entries []StateMachineLog
+	if err := tx.Session(&gorm.Session{NewDB: true}).Select("object_id", "created_at").Where(
+		"object_struct = ? AND object_id IN ? AND region = '' AND dest = ? AND source <> dest", name, ids, state,
+	).Order("id").Find(&entries).Error; err != nil {
+		return nil, fmt.Errorf("read log of stuck %s: %w", name, err)
+	}
+	for _, entry := range entries {
+		byId[entry.ObjectId].Since = entry.CreatedAt
+	}
+	return stuck, nil
+}
+
+// StuckHandler handles an object found by FindStuck, in a transaction.
+type StuckHandler func(ctx context.Context, tx *gorm.DB, stuck StuckObject) error
+
+// Escalate returns the StuckHandler firing trigger on the stuck objects, by
+// operatorId, with a reason telling since when they were stuck unless ctx
+// has one.
+func Escalate(trigger string, operatorId uint) StuckHandler {
+	return func(ctx context.Context, tx *gorm.DB, stuck StuckObject) error {
+		sm, err := machineOf(stuck.Object)
+		if err != nil {
+			return err
+		}
+		if ReasonFromContext(ctx) == "" {
+			ctx = WithReason(ctx, fmt.Sprintf("in %s since %s", stuck.Object.GetState(), stuck.Since.Format(time.RFC3339)))
+		}
+		return sm.DoCtx(ctx, tx.WithContext(ctx), trigger, operatorId)
+	}
+}
+
+// HandleStuck calls handle with every object found by FindStuck, each in its
+// own transaction, and returns the number handled.
+func HandleStuck(ctx context.Context, db *gorm.DB, model Stater, state string, threshold time.Duration, handle StuckHandler) (int, error) {
+	db = db.WithContext(ctx)
+	stuck, err := FindStuck(db, model, state, threshold)
+	if err != nil {
+		return 0, err
+	}
+	for i, s := range stuck {
+		if err := ctx.Err(); err != nil {
+			return i, err
+		}
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return handle(ctx, tx, s)
+		}); err != nil {
+			id, _ := objectId(s.Object)
+			return i, fmt.Errorf("handle stuck %s %d: %w", StructName(s.Object), id, err)
+		}
+	}
+	return len(stuck), nil
+}
+
+// RunStuckDetection calls HandleStuck every interval until ctx is done.
+// Errors are reported to onError, which may be nil.
+func RunStuckDetection(ctx context.Context, db *gorm.DB, interval time.Duration, model Stater, state string, threshold time.Duration, handle StuckHandler, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := HandleStuck(ctx, db, model, state, threshold, handle); err != nil && onError != nil && ctx.Err() == nil {
+			onError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
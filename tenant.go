@@ -0,0 +1,64 @@
+package common
+
+import (
+	"context"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+type tenantKey struct{}
+
+// WithTenant returns ctx running the transitions fired with it for tenantId,
+// stored in the TenantId of their log entries. The log read by QueryHistory,
+// History, StateSpans, StateDurationStats and FunnelOf with a tx of ctx is
+// also restricted to the entries of tenantId.
+func WithTenant(ctx context.Context, tenantId string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenantId)
+}
+
+var (
+	tenantMu        sync.RWMutex
+	tenantExtractor func(ctx context.Context) string
+)
+
+// ExtractTenant sets the func returning the tenant of the contexts without
+// one set by WithTenant, e.g. from the claims of the request; nil, the
+// default, returns none.
+func ExtractTenant(extractor func(ctx context.Context) string) {
+	tenantMu.Lock()
+	defer tenantMu.Unlock()
+	tenantExtractor = extractor
+}
+
+// TenantFromContext returns the tenant set by WithTenant, or returned by the
+// ExtractTenant func.
+func TenantFromContext(ctx context.Context) string {
+	if tenantId, ok := ctx.Value(tenantKey{}).(string); ok {
+		return tenantId
+	}
+	tenantMu.RLock()
+	extractor := tenantExtractor
+	tenantMu.RUnlock()
+	if extractor == nil {
+		return ""
+	}
+	return extractor(ctx)
+}
+
+// ScopeTenant restricts a query of StateMachineLog to the entries of
+// tenantId.
+func ScopeTenant(tenantId string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("tenant_id = ?", tenantId)
+	}
+}
+
+// scopeTenant restricts query, of StateMachineLog, to the tenant of its
+// context, if any.
+func scopeTenant(query *gorm.DB) *gorm.DB {
+	if tenantId := TenantFromContext(contextOf(query)); tenantId != "" {
+		return query.Scopes(ScopeTenant(tenantId))
+	}
+	return query
+}
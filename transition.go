@@ -1,22 +1,33 @@
 package common
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"reflect"
-	"strings"
+	"sort"
+	"strconv"
+	"time"
 
 	"golang.org/x/text/language"
-	"golang.org/x/text/message"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
-var Lang = message.NewPrinter(language.Chinese)
-
 type AvailableTrigger struct {
 	TranslatedTrigger string
 	Trigger           string
+	Metadata          TriggerMetadata
+	// TranslatedDescription is the Description of Metadata, translated if the
+	// catalog has it, see KindDescription.
+	TranslatedDescription string
+}
+
+// RejectedTrigger is a trigger whose source matches the current state but
+// whose condition or guard refused it, e.g. to render a disabled button.
+type RejectedTrigger struct {
+	AvailableTrigger
+	Reason string
 }
 
 type StateMachineLog struct {
@@ -27,6 +38,29 @@ type StateMachineLog struct {
 	Source       string `gorm:"not null; varchar(64)"`
 	Dest         string `gorm:"not null; varchar(64)"`
 	OperatorId   uint   `gorm:"not null; index"`
+	// OperatorRef identifies operators without an ID, see OperatorProvider.
+	OperatorRef string `gorm:"index; varchar(128)"`
+	Branch      string `gorm:"varchar(64)"`
+	// Region is the name of the parallel region, or named machine, of the
+	// trigger; empty for the main machine.
+	Region   string `gorm:"varchar(64)"`
+	Reason   string
+	Metadata LogMetadata
+	// Args are the arguments of the trigger, serialized by the marshaler set
+	// with MarshalArgs.
+	Args string
+	// TraceId correlates the transition with the request or trace it was
+	// fired from, see ExtractTraceId.
+	TraceId string `gorm:"index; varchar(64)"`
+	// ObjectKey is the primary key of the object as a string, the only one
+	// set of ObjectId and ObjectKey for keys that are not unsigned integers,
+	// see IDProvider.
+	ObjectKey string `gorm:"index; varchar(64)"`
+	// TenantId is the tenant the transition was fired for, see WithTenant.
+	TenantId string `gorm:"index; varchar(64)"`
+	// Hash and PrevHash chain the entries of an object, see HashChain.
+	Hash     string `gorm:"varchar(64)"`
+	PrevHash string `gorm:"varchar(64)"`
 }
 
 func StructName(obj interface{}) string {
@@ -37,14 +71,19 @@ func StructName(obj interface{}) string {
 	}
 }
 
+// Stater is implemented by models embedding StateMachine. The machine itself is
+// declared by also implementing Definer, TriggerConfiger, or MapTriggerer for
+// the legacy map form.
 type Stater interface {
-	States() []string
-	Triggers() map[string]map[string]interface{}
 	GetState() string
 	SetState(state string)
 	SetStater(stater Stater)
 }
 
+// DefaultInitialState is the state of new models whose machine declares no
+// initial state.
+const DefaultInitialState = "INITIALIZED"
+
 type Transition struct {
 	State string `gorm:"type:varchar(64);not null;default:INITIALIZED"`
 }
@@ -57,6 +96,8 @@ func (ts *Transition) SetState(state string) {
 	ts.State = state
 }
 
+// StateMachine is embedded in models. It only holds their state: the machine
+// itself is a Definition shared by all the models of a type.
 type StateMachine struct {
 	stater Stater `gorm:"-"`
 	Transition
@@ -66,112 +107,863 @@ func (sm *StateMachine) SetStater(stater Stater) {
 	sm.stater = stater
 }
 
+// GetState returns the field tagged sm:"state" of the model if it has one,
+// Transition.State otherwise.
+func (sm *StateMachine) GetState() string {
+	if sm.stater != nil {
+		if f := stateFieldOf(sm.stater); f != nil {
+			return f.get(sm.stater)
+		}
+	}
+	return sm.Transition.GetState()
+}
+
+// SetState also sets the field tagged sm:"state", unless state is not a
+// valid value of its type.
+func (sm *StateMachine) SetState(state string) {
+	sm.Transition.SetState(state)
+	if sm.stater != nil {
+		if f := stateFieldOf(sm.stater); f != nil {
+			_ = f.set(sm.stater, state)
+		}
+	}
+}
+
+func (sm *StateMachine) machine() *StateMachine {
+	return sm
+}
+
+// machineOf returns the StateMachine embedded in obj, bound to obj.
+func machineOf(obj Stater) (*StateMachine, error) {
+	m, ok := obj.(interface{ machine() *StateMachine })
+	if !ok {
+		return nil, fmt.Errorf("%s does not embed StateMachine", StructName(obj))
+	}
+	sm := m.machine()
+	if sm.stater == nil {
+		sm.SetStater(obj)
+	}
+	return sm, nil
+}
+
 func (sm *StateMachine) AfterFind(tx *gorm.DB) error {
-	ele := reflect.ValueOf(tx.Statement.Model).Elem()
-	switch ele.Kind() {
-	case reflect.Slice:
-		for i := 0; i < ele.Len(); i++ {
-			val := ele.Index(i).Interface()
-			obj := val.(Stater)
-			obj.SetStater(obj)
-		}
-	case reflect.Struct:
-		s := tx.Statement.Model.(Stater)
-		s.SetStater(s)
-	default:
-		return errors.New("StateMachine AfterFind unknown type")
+	// The found rows are in Dest, which differs from Model in
+	// db.Model(&Order{}).Find(&orders).
+	value := tx.Statement.ReflectValue
+	if !value.IsValid() {
+		value = reflect.ValueOf(tx.Statement.Model)
+	}
+	s, err := hookedStater(tx, value)
+	if err != nil {
+		return err
 	}
+	s.SetStater(s)
 	return nil
 }
 
-func (sm *StateMachine) TranslatedState() string {
-	return Lang.Sprintf(StructName(sm.stater) + ":" + sm.stater.GetState())
-}
-
-func (sm *StateMachine) AvailableTriggers() (triggers []*AvailableTrigger) {
-	for trigger, config := range sm.stater.Triggers() {
-		source := config["source"]
-		for _, src := range strings.Split(source.(string), ",") {
-			if src == sm.stater.GetState() {
-				triggers = append(triggers,
-					&AvailableTrigger{
-						TranslatedTrigger: Lang.Sprintf(StructName(sm.stater) + ":" + trigger),
-						Trigger:           trigger,
-					})
+// BeforeCreate binds new models and gives them their initial state, see
+// InitialStater. Models defining their own BeforeCreate must call it.
+func (sm *StateMachine) BeforeCreate(tx *gorm.DB) error {
+	value := tx.Statement.ReflectValue
+	if !value.IsValid() {
+		value = reflect.ValueOf(tx.Statement.Model)
+	}
+	s, err := hookedStater(tx, value)
+	if err != nil {
+		return err
+	}
+	return initialize(s)
+}
+
+// initialize binds a new model and gives it its initial states.
+func initialize(s Stater) error {
+	s.SetStater(s)
+	definition, _ := definitionOf(s)
+	if s.GetState() == "" {
+		s.SetState(initialStateOf(s, definition))
+	}
+	if definition == nil {
+		return nil
+	}
+	for _, r := range definition.regions {
+		if r.state(s) == "" {
+			if err := r.setState(s, r.definition.InitialState()); err != nil {
+				return err
 			}
 		}
 	}
+	return nil
+}
+
+func (sm *StateMachine) TranslatedState() string {
+	return sm.TranslatedStateCtx(context.Background())
+}
+
+// TranslatedStateCtx is TranslatedState with the printer of ctx, see
+// PrinterOf. The state missing from its catalog is translated by the printer
+// of the machine, else labeled by the translation fallback, see
+// SetTranslationFallback.
+func (sm *StateMachine) TranslatedStateCtx(ctx context.Context) string {
+	definition, _ := sm.Definition()
+	state := sm.stater.GetState()
+	return translate(ctx, definition, KindState, state)
+}
+
+// TranslatedStateIn is TranslatedState in lang, see WithLanguage.
+func (sm *StateMachine) TranslatedStateIn(lang language.Tag) string {
+	return sm.TranslatedStateCtx(WithLanguage(context.Background(), lang))
+}
+
+// IsIn reports whether the current state is state or one of its sub-states.
+func (sm *StateMachine) IsIn(state string) bool {
+	definition, err := sm.Definition()
+	if err != nil {
+		return sm.stater.GetState() == state
+	}
+	for _, s := range definition.Ancestry(sm.stater.GetState()) {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// StateHasTag reports whether the current state, or a state containing it,
+// has tag.
+func (sm *StateMachine) StateHasTag(tag string) bool {
+	definition, err := sm.Definition()
+	return err == nil && definition.HasTag(sm.stater.GetState(), tag)
+}
+
+// InGroup reports whether the current state belongs to group.
+func (sm *StateMachine) InGroup(group string) bool {
+	definition, err := sm.Definition()
+	return err == nil && definition.InGroup(sm.stater.GetState(), group)
+}
+
+// IsFinal reports whether the current state is final, so that no trigger can
+// fire anymore.
+func (sm *StateMachine) IsFinal() bool {
+	definition, err := sm.Definition()
+	return err == nil && definition.IsFinal(sm.stater.GetState())
+}
+
+// IsTerminated is an alias of IsFinal.
+func (sm *StateMachine) IsTerminated() bool {
+	return sm.IsFinal()
+}
+
+func (sm *StateMachine) Definition() (*Definition, error) {
+	return definitionOf(sm.stater)
+}
+
+func (sm *StateMachine) AvailableTriggers() []*AvailableTrigger {
+	return sm.AvailableTriggersCtx(context.Background())
+}
+
+// AvailableTriggersCtx is AvailableTriggers translated with the printer of
+// ctx, see PrinterOf.
+func (sm *StateMachine) AvailableTriggersCtx(ctx context.Context) (triggers []*AvailableTrigger) {
+	definition, err := sm.Definition()
+	if err != nil {
+		return nil
+	}
+	for _, r := range definition.allRegions() {
+		triggers = append(triggers, sm.availableIn(ctx, definition, r)...)
+	}
 	return triggers
 }
 
-func (sm *StateMachine) Do(tx *gorm.DB, trigger string, userInfoId uint, args ...interface{}) error {
-	if _, ok := sm.stater.Triggers()[trigger]; !ok {
-		return errors.New(fmt.Sprintf("can not do trigger: %s", trigger))
+// AvailableTriggersIn is AvailableTriggers translated in lang, see
+// WithLanguage.
+func (sm *StateMachine) AvailableTriggersIn(lang language.Tag) []*AvailableTrigger {
+	return sm.AvailableTriggersCtx(WithLanguage(context.Background(), lang))
+}
+
+func (sm *StateMachine) availableIn(ctx context.Context, definition *Definition, r *region) (triggers []*AvailableTrigger) {
+	state := r.state(sm.stater)
+	if r.definition.IsFinal(state) {
+		return nil
+	}
+	for _, trigger := range r.definition.prioritized() {
+		config := r.definition.triggers[trigger]
+		if config.hasSource(r.definition.Ancestry(state)...) && !config.isRejectedSelfTransition(state) {
+			triggers = append(triggers, sm.availableTrigger(ctx, definition, trigger, config))
+		}
 	}
+	return triggers
+}
+
+// AvailableTriggersWithGuards is AvailableTriggers with each condition
+// evaluated: triggers refused by their condition are returned separately with
+// the reason.
+func (sm *StateMachine) AvailableTriggersWithGuards(tx *gorm.DB, args ...interface{}) (triggers []*AvailableTrigger, rejected []*RejectedTrigger) {
+	definition, err := sm.Definition()
+	if err != nil {
+		return nil, nil
+	}
+	ctx := contextOf(tx)
+	for _, r := range definition.allRegions() {
+		state := r.state(sm.stater)
+		if r.definition.IsFinal(state) {
+			continue
+		}
+		for _, trigger := range r.definition.prioritized() {
+			config := r.definition.triggers[trigger]
+			if !config.hasSource(r.definition.Ancestry(state)...) || config.isRejectedSelfTransition(state) {
+				continue
+			}
+			if _, err := sm.check(ctx, tx, definition, r, trigger, args...); err != nil {
+				reason := err.Error()
+				var guardErr *GuardError
+				if errors.As(err, &guardErr) && guardErr.Reason != "" {
+					reason = guardErr.Reason
+				}
+				rejected = append(rejected, &RejectedTrigger{
+					AvailableTrigger: *sm.availableTrigger(ctx, definition, trigger, config),
+					Reason:           reason,
+				})
+				continue
+			}
+			triggers = append(triggers, sm.availableTrigger(ctx, definition, trigger, config))
+		}
+	}
+	return triggers, rejected
+}
+
+func (sm *StateMachine) availableTrigger(ctx context.Context, definition *Definition, trigger string, config *TriggerConfig) *AvailableTrigger {
+	available := &AvailableTrigger{
+		TranslatedTrigger: translate(ctx, definition, KindTrigger, trigger),
+		Trigger:           trigger,
+		Metadata:          config.Metadata,
+	}
+	if description := config.Metadata.Description; description != "" {
+		available.TranslatedDescription = description
+		if translated, ok := lookup(ctx, definition, TranslationKey(definition.name, KindDescription, trigger)); ok {
+			available.TranslatedDescription = translated
+		}
+	}
+	return available
+}
 
-	source := sm.stater.Triggers()[trigger]["source"].(string)
-	dest := sm.stater.Triggers()[trigger]["dest"].(string)
-	beforeFunc := sm.stater.Triggers()[trigger]["before"]
-	afterFunc := sm.stater.Triggers()[trigger]["after"]
-	conditionFunc := sm.stater.Triggers()[trigger]["condition"]
+// RegionState returns the current state of a parallel region.
+func (sm *StateMachine) RegionState(name string) (string, error) {
+	definition, err := sm.Definition()
+	if err != nil {
+		return "", err
+	}
+	r, ok := definition.region(name)
+	if !ok {
+		return "", fmt.Errorf("%s has no region %s", StructName(sm.stater), name)
+	}
+	return r.state(sm.stater), nil
+}
 
-	currentState := sm.stater.GetState()
+func contextOf(tx *gorm.DB) context.Context {
+	if tx != nil && tx.Statement != nil && tx.Statement.Context != nil {
+		return tx.Statement.Context
+	}
+	return context.Background()
+}
 
-	canDo := false
-	var src string
-	for _, src = range strings.Split(source, ",") {
-		if src == currentState {
-			canDo = true
+func (sm *StateMachine) regionOf(definition *Definition, trigger string) (*region, error) {
+	r, ok := definition.regionOf(trigger)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTriggerNotFound, trigger)
+	}
+	if r.field != "" {
+		if _, err := r.stateField(sm.stater); err != nil {
+			return nil, err
 		}
 	}
+	return r, nil
+}
+
+// triggerError returns the TriggerError of trigger refused in state, its
+// Message translated with the printer of ctx.
+func (sm *StateMachine) triggerError(ctx context.Context, definition *Definition, err error, trigger, state string) *TriggerError {
+	return &TriggerError{
+		Err:     err,
+		Trigger: trigger,
+		State:   state,
+		Message: translateMessage(ctx, definition, "can not do trigger %s from %s",
+			translate(ctx, definition, KindTrigger, trigger), translate(ctx, definition, KindState, state)),
+	}
+}
+
+// check reports whether trigger can fire from the current state of its
+// region r of definition, running its condition but nothing else.
+func (sm *StateMachine) check(ctx context.Context, tx *gorm.DB, definition *Definition, r *region, trigger string, args ...interface{}) (*TriggerConfig, error) {
+	ctx = withObject(ctx, sm.stater)
+	config := r.definition.triggers[trigger]
+	currentState := r.state(sm.stater)
+
+	if config.ignores(r.definition.Ancestry(currentState)...) {
+		return nil, &AlreadyInState{Trigger: trigger, State: currentState}
+	}
+
+	if r.definition.IsFinal(currentState) {
+		return nil, sm.triggerError(ctx, definition, ErrFinalState, trigger, currentState)
+	}
+
+	if !config.hasSource(r.definition.Ancestry(currentState)...) {
+		return nil, sm.triggerError(ctx, definition, ErrInvalidSourceState, trigger, currentState)
+	}
 
-	if !canDo {
-		return errors.New(fmt.Sprintf("can not do trigger: %s, current state: %s", trigger, currentState))
+	if config.isRejectedSelfTransition(currentState) {
+		return nil, fmt.Errorf("%w: %s is already %s", ErrSelfTransition, trigger, currentState)
 	}
 
-	if conditionFunc != nil {
-		if !conditionFunc.(func(*gorm.DB, ...interface{}) bool)(tx, args...) {
+	if config.Condition != nil {
+		if !config.Condition(ctx, tx, args...) {
+			return nil, &GuardError{Trigger: trigger}
+		}
+	}
+
+	if config.Guard != nil {
+		ok, reason, err := config.Guard(ctx, tx, args...)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, &GuardError{Trigger: trigger, Reason: reason}
+		}
+	}
+	return config, nil
+}
+
+// CanDo reports whether Do would accept trigger in the current state, without
+// changing the state or writing a log. Only its condition is evaluated.
+func (sm *StateMachine) CanDo(tx *gorm.DB, trigger string, args ...interface{}) (bool, error) {
+	definition, err := sm.Definition()
+	if err != nil {
+		return false, err
+	}
+	return sm.canDo(tx, definition, trigger, args...)
+}
+
+// canDo is CanDo with the definition bound to the object.
+func (sm *StateMachine) canDo(tx *gorm.DB, definition *Definition, trigger string, args ...interface{}) (bool, error) {
+	trigger = definition.Canonical(trigger)
+	r, err := sm.regionOf(definition, trigger)
+	if err != nil {
+		return false, err
+	}
+	if _, err := sm.check(contextOf(tx), tx, definition, r, trigger, args...); err != nil {
+		if errors.Is(err, ErrFinalState) || errors.Is(err, ErrInvalidSourceState) ||
+			errors.Is(err, ErrSelfTransition) || errors.Is(err, ErrGuardRejected) ||
+			errors.Is(err, ErrAlreadyInState) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// DoNext fires the trigger of highest priority that CanDo accepts, over all
+// regions, and returns its name.
+func (sm *StateMachine) DoNext(tx *gorm.DB, userInfoId uint, args ...interface{}) (string, error) {
+	definition, err := sm.Definition()
+	if err != nil {
+		return "", err
+	}
+	var triggers []string
+	for _, r := range definition.allRegions() {
+		triggers = append(triggers, r.definition.prioritized()...)
+	}
+	sort.SliceStable(triggers, func(i, j int) bool {
+		ri, _ := definition.regionOf(triggers[i])
+		rj, _ := definition.regionOf(triggers[j])
+		return ri.definition.triggers[triggers[i]].Priority > rj.definition.triggers[triggers[j]].Priority
+	})
+	for _, trigger := range triggers {
+		ok, err := sm.canDo(tx, definition, trigger, args...)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			_, err := sm.fire(contextOf(tx), tx, definition, trigger, userInfoId, args...)
+			return trigger, err
+		}
+	}
+	return "", fmt.Errorf("%w: %s in %s", ErrNoTriggerAvailable, StructName(sm.stater), sm.stater.GetState())
+}
+
+// TransitionResult describes what a trigger did, see Fire.
+type TransitionResult struct {
+	Trigger string
+	Region  string
+	Source  string
+	Dest    string
+	Branch  string
+	// LogID is the ID of the StateMachineLog written, 0 if none was.
+	LogID    uint
+	Duration time.Duration
+	// ShortCircuited is set when the trigger did not transition: its
+	// condition or guard refused it, it was ignored in the current state, or
+	// it was deferred.
+	ShortCircuited bool
+	// AlreadyInState is set when the trigger was ignored in the current
+	// state, see TriggerConfig.IgnoreIfIn. Only its log was written, and no
+	// error is returned.
+	AlreadyInState bool
+}
+
+func (sm *StateMachine) Do(tx *gorm.DB, trigger string, userInfoId uint, args ...interface{}) error {
+	return sm.DoCtx(contextOf(tx), tx, trigger, userInfoId, args...)
+}
+
+// DoWithReason is Do recording reason in the StateMachineLog, see WithReason.
+func (sm *StateMachine) DoWithReason(tx *gorm.DB, trigger string, userInfoId uint, reason string, args ...interface{}) error {
+	return sm.DoCtx(WithReason(contextOf(tx), reason), tx, trigger, userInfoId, args...)
+}
+
+// DoCtx is Do with a context that is handed to every callback and used for
+// the database statements issued by the transition.
+func (sm *StateMachine) DoCtx(ctx context.Context, tx *gorm.DB, trigger string, userInfoId uint, args ...interface{}) error {
+	_, err := sm.FireCtx(ctx, tx, trigger, userInfoId, args...)
+	return err
+}
+
+// Fire is Do returning what the trigger did. The result is never nil: on
+// errors it holds what was known when it failed.
+func (sm *StateMachine) Fire(tx *gorm.DB, trigger string, userInfoId uint, args ...interface{}) (*TransitionResult, error) {
+	return sm.FireCtx(contextOf(tx), tx, trigger, userInfoId, args...)
+}
+
+func (sm *StateMachine) FireCtx(ctx context.Context, tx *gorm.DB, trigger string, userInfoId uint, args ...interface{}) (*TransitionResult, error) {
+	definition, err := sm.Definition()
+	if err != nil {
+		return &TransitionResult{Trigger: trigger}, err
+	}
+	return sm.fire(ctx, tx, definition, trigger, userInfoId, args...)
+}
+
+// fire is FireCtx with the definition bound to the object, which the whole
+// transition uses.
+func (sm *StateMachine) fire(ctx context.Context, tx *gorm.DB, definition *Definition, trigger string, userInfoId uint, args ...interface{}) (*TransitionResult, error) {
+	start := time.Now()
+	result := &TransitionResult{Trigger: trigger}
+	defer func() {
+		result.Duration = time.Since(start)
+	}()
+
+	if tx == nil {
+		tx, ctx = MemoryDB().WithContext(ctx), WithNoPersist(ctx)
+	}
+	trigger = definition.Canonical(trigger)
+	result.Trigger = trigger
+	if inTransition(ctx, sm.stater) || inTransition(contextOf(tx), sm.stater) {
+		return result, fmt.Errorf("%w: %s on %s", ErrNestedTransition, trigger, StructName(sm.stater))
+	}
+	do := chain(func(ctx context.Context, tx *gorm.DB, _ Stater, trigger string, userInfoId uint, args ...interface{}) error {
+		doCtx, published := publishing(ctx)
+		run := func(tx *gorm.DB) error {
+			return sm.do(doCtx, tx, definition, result, trigger, userInfoId, args...)
+		}
+		var err error
+		if persisting(ctx) {
+			err = atomically(tx, []Stater{sm.stater}, run)
+		} else {
+			err = run(tx)
+		}
+		published(err)
+		if err == nil && !result.ShortCircuited {
+			// Pending triggers are transitions of their own, fired once this
+			// one is committed.
+			sm.firePending(ctx, tx, definition)
+		}
+		return err
+	}, definition)
+	return result, do(withResult(ctx, result), tx, sm.stater, trigger, userInfoId, args...)
+}
+
+func (sm *StateMachine) do(ctx context.Context, tx *gorm.DB, definition *Definition, result *TransitionResult, trigger string, userInfoId uint, args ...interface{}) (err error) {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ctx, unlock, err := acquireLock(ctx, sm.stater)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := checkDeleted(tx, sm.stater); err != nil {
+		return err
+	}
+	if err := lockRow(ctx, tx, definition, sm.stater); err != nil {
+		return err
+	}
+	ctx = withTransition(ctx, sm.stater)
+	tx = tx.WithContext(ctx)
+
+	r, err := sm.regionOf(definition, trigger)
+	if err != nil {
+		return err
+	}
+	currentState := r.state(sm.stater)
+	result.Trigger, result.Region, result.Source = trigger, r.name, currentState
+
+	var config *TriggerConfig
+	var dest, branch string
+	err = phase(ctx, tx, PhaseCondition, func(ctx context.Context, tx *gorm.DB) error {
+		var err error
+		if config, err = sm.check(ctx, tx, definition, r, trigger, args...); err != nil {
+			return err
+		}
+		dest, branch, err = sm.resolveDest(ctx, tx, r, trigger, config, args...)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, ErrInvalidSourceState) && r.definition.triggers[trigger].Deferrable && persisting(ctx) {
+			result.ShortCircuited = true
+			return sm.deferTrigger(tx, r, trigger, userInfoId, args)
+		}
+		if errors.Is(err, ErrAlreadyInState) {
+			result.ShortCircuited = true
+			serialized, logErr := marshalArgs(trigger, args)
+			if logErr != nil {
+				return logErr
+			}
+			entry := &StateMachineLog{
+				Region:     r.name,
+				Trigger:    trigger,
+				Source:     currentState,
+				Dest:       currentState,
+				OperatorId: userInfoId,
+				Args:       serialized,
+			}
+			if logErr := sm.log(tx, entry); logErr != nil {
+				return logErr
+			}
+			result.Dest, result.LogID = currentState, entry.ID
+			result.AlreadyInState = true
 			return nil
 		}
+		if errors.Is(err, ErrGuardRejected) {
+			result.ShortCircuited = true
+		}
+		return err
 	}
+	result.Dest, result.Branch = dest, branch
 
-	if beforeFunc != nil {
-		if err := beforeFunc.(func(*gorm.DB, ...interface{}) error)(tx, args...); err != nil {
+	event := &TransitionEvent{
+		Object:     sm.stater,
+		Region:     r.name,
+		Trigger:    trigger,
+		Source:     currentState,
+		Dest:       dest,
+		OperatorId: userInfoId,
+		Args:       args,
+	}
+	if err := phase(ctx, tx, PhaseBefore, func(ctx context.Context, tx *gorm.DB) error {
+		if err := runHooks(ctx, tx, &beforeHooks, event); err != nil {
 			return err
 		}
+		if !config.Internal {
+			if err := runCallbacks(ctx, tx, r.definition.onExit[currentState], args...); err != nil {
+				return err
+			}
+		}
+		if config.Before != nil {
+			return config.Before(ctx, tx, args...)
+		}
+		return nil
+	}); err != nil {
+		return err
 	}
 
-	sm.stater.SetState(dest)
+	// From the update on, the object is restored if the transition fails.
+	var restore func()
+	defer func() {
+		if err != nil && restore != nil {
+			restore()
+		}
+	}()
+	if !config.Internal {
+		restore = r.saveState(sm.stater)
+		if err := phase(ctx, tx, PhaseUpdate, func(ctx context.Context, tx *gorm.DB) error {
+			if err := r.setState(sm.stater, dest); err != nil {
+				return err
+			}
+			if err := retrying(ctx, sqlDebug(tx), func(tx *gorm.DB) error {
+				return updateState(tx, sm.stater, r, currentState, dest)
+			}); err != nil {
+				return err
+			}
 
-	if err := tx.Debug().Model(
-		sm.stater,
-	).Omit(clause.Associations).Update(
-		"state", dest,
-	).Error; err != nil {
-		return err
+			return runCallbacks(ctx, tx, r.definition.onEnter[dest], args...)
+		}); err != nil {
+			return err
+		}
 	}
 
-	if afterFunc != nil {
-		if err := afterFunc.(func(*gorm.DB, ...interface{}) error)(tx, args...); err != nil {
+	if config.After != nil {
+		if err := phase(ctx, tx, PhaseAfter, func(ctx context.Context, tx *gorm.DB) error {
+			return config.After(ctx, tx, args...)
+		}); err != nil {
 			return err
 		}
 	}
-	fmt.Println(tx, src, dest)
 
-	return sm.log(tx, trigger, src, dest, userInfoId)
+	entry := &StateMachineLog{
+		Region:     r.name,
+		Trigger:    trigger,
+		Source:     currentState,
+		Dest:       dest,
+		OperatorId: userInfoId,
+		Branch:     branch,
+	}
+	if err := phase(ctx, tx, PhaseLog, func(ctx context.Context, tx *gorm.DB) error {
+		var err error
+		if entry.Args, err = marshalArgs(trigger, args); err != nil {
+			return err
+		}
+		return retrying(ctx, tx, func(tx *gorm.DB) error {
+			return sm.log(tx, entry)
+		})
+	}); err != nil {
+		return err
+	}
+	result.LogID, event.LogId = entry.ID, entry.ID
+	currentLogger().Debug("transition",
+		"object", StructName(sm.stater), "id", entry.ObjectId, "region", r.name,
+		"trigger", trigger, "source", currentState, "dest", dest, "operator", userInfoId)
+
+	return runHooks(ctx, tx, &afterHooks, event)
 }
 
-func (sm *StateMachine) log(tx *gorm.DB, trigger, source, dest string, userInfoId uint) error {
-	if err := tx.Create(&StateMachineLog{
-		ObjectId:     uint(reflect.ValueOf(sm.stater).Elem().FieldByName("ID").Uint()),
-		ObjectStruct: StructName(sm.stater),
-		Trigger:      trigger,
-		Source:       source,
-		Dest:         dest,
-		OperatorId:   userInfoId,
-	}).Error; err != nil {
+// resolveDest returns where trigger leads from the current state, calling its
+// DestFunc or branch guards if any and checking the result against the
+// declared states. branch names the Branch taken, if any.
+func (sm *StateMachine) resolveDest(ctx context.Context, tx *gorm.DB, r *region, trigger string, config *TriggerConfig, args ...interface{}) (dest, branch string, err error) {
+	definition := r.definition
+	currentState := r.state(sm.stater)
+	switch {
+	case config.Internal:
+		return currentState, "", nil
+	case config.DestFunc != nil:
+		if dest, err = config.DestFunc(ctx, tx, args...); err != nil {
+			return "", "", err
+		}
+	case len(config.Branches) > 0:
+		for i, b := range config.Branches {
+			if b.Guard == nil || b.Guard(ctx, tx, args...) {
+				dest, branch = b.Dest, b.name(i)
+				break
+			}
+		}
+		if branch == "" {
+			if config.Dest == "" {
+				return "", "", fmt.Errorf("%w: no branch of %s matched", ErrGuardRejected, trigger)
+			}
+			dest, branch = config.Dest, DefaultBranch
+		}
+	default:
+		if config.Dest != HistoryState {
+			return config.Dest, "", nil
+		}
+		dest = config.Dest
+	}
+
+	if dest == HistoryState {
+		if dest, err = sm.historyState(tx, r); err != nil {
+			return "", "", err
+		}
+	}
+
+	if len(definition.states) > 0 && !definition.HasState(dest) {
+		return "", "", fmt.Errorf("%w: trigger %s resolved to %s", ErrUnknownState, trigger, dest)
+	}
+	if !config.Reentrant && dest == currentState {
+		return "", "", fmt.Errorf("%w: %s is already %s", ErrSelfTransition, trigger, currentState)
+	}
+	return dest, branch, nil
+}
+
+// historyState returns the state the object left to enter its current state
+// of region r, according to the log.
+func (sm *StateMachine) historyState(tx *gorm.DB, r *region) (string, error) {
+	query, err := whereObject(tx, sm.stater)
+	if err != nil {
+		return "", err
+	}
+	currentState := r.state(sm.stater)
+	var entry StateMachineLog
+	if err := query.Where(
+		"region = ? AND dest = ? AND source <> dest", r.name, currentState,
+	).Order("id DESC").First(&entry).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", fmt.Errorf("%w: %s never entered %s", ErrNoHistory, StructName(sm.stater), currentState)
+		}
+		return "", fmt.Errorf("read history of %s: %w", StructName(sm.stater), err)
+	}
+	return entry.Source, nil
+}
+
+// Identifier lets a stater report its primary key without reflection.
+type Identifier interface {
+	StateMachineObjectId() uint
+}
+
+// ObjectIdOf returns the primary key of stater, from Identifier or its
+// primary key field.
+func ObjectIdOf(stater Stater) (uint, error) {
+	return objectId(stater)
+}
+
+// IDProvider lets a stater whose primary key is not an unsigned integer, e.g.
+// a UUID, report it as a string, stored as the ObjectKey of its log entries.
+// Deferrable and queued triggers, SLAs, FindStuck and the Consumer still need
+// unsigned keys.
+type IDProvider interface {
+	StateMachineObjectKey() string
+}
+
+// ObjectKeyOf returns the primary key of stater as a string, from IDProvider
+// or its primary key field: an integer, a string, or a fmt.Stringer like
+// uuid.UUID.
+func ObjectKeyOf(stater Stater) (string, error) {
+	return primaryKey(stater)
+}
+
+func primaryKey(stater Stater) (string, error) {
+	if provider, ok := stater.(IDProvider); ok {
+		return provider.StateMachineObjectKey(), nil
+	}
+	if id, err := objectId(stater); err == nil {
+		return strconv.FormatUint(uint64(id), 10), nil
+	}
+	field, err := primaryField(stater)
+	if err != nil {
+		return "", fmt.Errorf("%w, implement IDProvider", err)
+	}
+	if s, ok := field.Interface().(fmt.Stringer); ok {
+		return s.String(), nil
+	}
+	if field.Kind() == reflect.String {
+		return field.String(), nil
+	}
+	return "", fmt.Errorf("%s has a %s primary key, implement IDProvider", StructName(stater), field.Type())
+}
+
+// objectKeys returns the ObjectId, 0 for keys that are not unsigned integers,
+// and the ObjectKey of the log entries of stater.
+func objectKeys(stater Stater) (uint, string, error) {
+	key, err := primaryKey(stater)
+	if err != nil {
+		return 0, "", err
+	}
+	id, _ := objectId(stater)
+	return id, key, nil
+}
+
+// whereObject returns tx selecting the log entries of stater: by object_id,
+// as written before ObjectKey, for unsigned keys, by object_key otherwise.
+func whereObject(tx *gorm.DB, stater Stater) (*gorm.DB, error) {
+	if id, err := objectId(stater); err == nil {
+		return tx.Where("object_id = ? AND object_struct = ?", id, StructName(stater)), nil
+	}
+	key, err := primaryKey(stater)
+	if err != nil {
+		return nil, err
+	}
+	return tx.Where("object_key = ? AND object_struct = ?", key, StructName(stater)), nil
+}
+
+// objectPartition orders and partitions the log entries by object: by
+// object_id for unsigned keys, by object_key for the others, logged with an
+// object_id of 0.
+const objectPartition = "object_struct, object_id, CASE WHEN object_id = 0 THEN object_key ELSE '' END"
+
+// objectRef is the ID, or the key if it is not an unsigned integer, of an
+// object whose log is read.
+type objectRef struct {
+	id  uint
+	key string
+}
+
+func (o objectRef) String() string {
+	if o.key != "" {
+		return o.key
+	}
+	return strconv.FormatUint(uint64(o.id), 10)
+}
+
+// where returns tx selecting the log entries of the object of the model
+// named name, as whereObject does.
+func (o objectRef) where(tx *gorm.DB, name string) *gorm.DB {
+	if o.key != "" {
+		return tx.Where("object_key = ? AND object_struct = ?", o.key, name)
+	}
+	return tx.Where("object_id = ? AND object_struct = ?", o.id, name)
+}
+
+// load loads the object in obj by its primary key.
+func (o objectRef) load(tx *gorm.DB, obj Stater) error {
+	if o.key == "" {
+		return tx.First(obj, o.id).Error
+	}
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(obj); err != nil {
 		return err
 	}
+	if stmt.Schema.PrioritizedPrimaryField == nil {
+		return fmt.Errorf("%s has no primary key", StructName(obj))
+	}
+	return tx.Where(clause.Eq{
+		Column: clause.Column{Table: clause.CurrentTable, Name: stmt.Schema.PrioritizedPrimaryField.DBName},
+		Value:  o.key,
+	}).First(obj).Error
+}
+
+func objectId(stater Stater) (uint, error) {
+	if identifier, ok := stater.(Identifier); ok {
+		return identifier.StateMachineObjectId(), nil
+	}
+	field, err := primaryField(stater)
+	if err != nil {
+		return 0, fmt.Errorf("%w, implement Identifier", err)
+	}
+	switch field.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return uint(field.Uint()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if field.Int() >= 0 {
+			return uint(field.Int()), nil
+		}
+	}
+	return 0, fmt.Errorf("%s has no unsigned primary key, implement Identifier", StructName(stater))
+}
+
+func (sm *StateMachine) log(tx *gorm.DB, entry *StateMachineLog) error {
+	if !persisting(contextOf(tx)) {
+		return nil
+	}
+	id, key, err := objectKeys(sm.stater)
+	if err != nil {
+		return err
+	}
+	entry.ObjectId, entry.ObjectKey = id, key
+	entry.ObjectStruct = StructName(sm.stater)
+	if entry.Reason == "" {
+		entry.Reason = ReasonFromContext(contextOf(tx))
+	}
+	if entry.Metadata == nil {
+		entry.Metadata = LogMetadataFromContext(contextOf(tx))
+	}
+	if entry.TraceId == "" {
+		entry.TraceId = traceIdOf(contextOf(tx))
+	}
+	if entry.TenantId == "" {
+		entry.TenantId = TenantFromContext(contextOf(tx))
+	}
+	completeOperator(contextOf(tx), entry)
+	if err := chainEntries(tx, []*StateMachineLog{entry}); err != nil {
+		return err
+	}
+	if err := currentAuditSink().Write(contextOf(tx), tx, []*StateMachineLog{entry}); err != nil {
+		return fmt.Errorf("log transition of %s: %w", StructName(sm.stater), err)
+	}
 	return nil
 }
 
@@ -0,0 +1,263 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var errCarrier = errors.New("carrier down")
+
+var restoredDefinition = NewDefinition("Parcel").
+	State("INITIALIZED", "PAID", "SHIPPED", "CANCELLED").
+	OnEnter("CANCELLED", func(context.Context, *gorm.DB, ...interface{}) error { return errCarrier }).
+	Trigger("pay").From("INITIALIZED").To("PAID").
+	Trigger("ship").From("PAID").To("SHIPPED").
+	After(func(context.Context, *gorm.DB, ...interface{}) error { return errCarrier }).
+	Trigger("cancel").From("INITIALIZED").To("CANCELLED").
+	MustBuild()
+
+type restoredParcel struct {
+	ID uint
+	StateMachine
+	Version int64 `sm:"version"`
+}
+
+func (*restoredParcel) Define() *Definition {
+	return restoredDefinition
+}
+
+// withMemoryStore stores the transitions of the test in a MemoryStore.
+func withMemoryStore(t *testing.T) *MemoryStore {
+	store := NewMemoryStore()
+	SetStore(store)
+	t.Cleanup(func() { SetStore(nil) })
+	return store
+}
+
+// stale returns a copy of p, bound to itself, as read before p changes.
+func stale(p *restoredParcel) *restoredParcel {
+	copied := &restoredParcel{ID: p.ID, Version: p.Version}
+	copied.SetStater(copied)
+	copied.SetState(p.GetState())
+	return copied
+}
+
+func assertParcel(t *testing.T, p *restoredParcel, state string, version int64) {
+	t.Helper()
+	if p.GetState() != state || p.Version != version {
+		t.Errorf("parcel %d is %s at version %d, want %s at version %d", p.ID, p.GetState(), p.Version, state, version)
+	}
+}
+
+func TestDoRestoresStateOnConflict(t *testing.T) {
+	store := withMemoryStore(t)
+	p := &restoredParcel{}
+	if err := store.Create(p); err != nil {
+		t.Fatal(err)
+	}
+	old := stale(p)
+	if err := p.Do(MemoryDB(), "pay", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := old.Do(MemoryDB(), "pay", 1); !errors.Is(err, ErrConcurrentModification) {
+		t.Fatalf("Do of a stale parcel: %v, want ErrConcurrentModification", err)
+	}
+	assertParcel(t, old, "INITIALIZED", 0)
+}
+
+func TestDoRestoresStateOnAfterError(t *testing.T) {
+	store := withMemoryStore(t)
+	p := &restoredParcel{}
+	if err := store.Create(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Do(MemoryDB(), "pay", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Do(MemoryDB(), "ship", 1); !errors.Is(err, errCarrier) {
+		t.Fatalf("Do failing in After: %v, want %v", err, errCarrier)
+	}
+	assertParcel(t, p, "PAID", 1)
+}
+
+func TestDoRestoresStateOnEnterError(t *testing.T) {
+	store := withMemoryStore(t)
+	p := &restoredParcel{}
+	if err := store.Create(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Do(MemoryDB(), "cancel", 1); !errors.Is(err, errCarrier) {
+		t.Fatalf("Do failing in OnEnter: %v, want %v", err, errCarrier)
+	}
+	assertParcel(t, p, "INITIALIZED", 0)
+}
+
+func TestBatchDoRestoresStatesOnConflict(t *testing.T) {
+	store := withMemoryStore(t)
+	a, b := &restoredParcel{}, &restoredParcel{}
+	if err := store.Create(a, b); err != nil {
+		t.Fatal(err)
+	}
+	old := stale(b)
+	if err := b.Do(MemoryDB(), "pay", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := BatchDo(MemoryDB(), []Stater{a, old}, "pay", 1); !errors.Is(err, ErrConcurrentModification) {
+		t.Fatalf("BatchDo with a stale parcel: %v, want ErrConcurrentModification", err)
+	}
+	assertParcel(t, a, "INITIALIZED", 0)
+	assertParcel(t, old, "INITIALIZED", 0)
+}
+
+func TestBatchDoRestoresStatesOnAfterError(t *testing.T) {
+	store := withMemoryStore(t)
+	a, b := &restoredParcel{}, &restoredParcel{}
+	if err := store.Create(a, b); err != nil {
+		t.Fatal(err)
+	}
+	if err := BatchDo(MemoryDB(), []Stater{a, b}, "pay", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := BatchDo(MemoryDB(), []Stater{a, b}, "ship", 1); !errors.Is(err, errCarrier) {
+		t.Fatalf("BatchDo failing in After: %v, want %v", err, errCarrier)
+	}
+	assertParcel(t, a, "PAID", 1)
+	assertParcel(t, b, "PAID", 1)
+}
+
+func TestBatchDoRestoresStatesOnEnterError(t *testing.T) {
+	store := withMemoryStore(t)
+	a, b := &restoredParcel{}, &restoredParcel{}
+	if err := store.Create(a, b); err != nil {
+		t.Fatal(err)
+	}
+	if err := BatchDo(MemoryDB(), []Stater{a, b}, "cancel", 1); !errors.Is(err, errCarrier) {
+		t.Fatalf("BatchDo failing in OnEnter: %v, want %v", err, errCarrier)
+	}
+	assertParcel(t, a, "INITIALIZED", 0)
+	assertParcel(t, b, "INITIALIZED", 0)
+}
+
+// ForceState overwrites the state as Revert does, without reading the log.
+func TestForceStateRestoresStateOnConflict(t *testing.T) {
+	store := withMemoryStore(t)
+	p := &restoredParcel{}
+	if err := store.Create(p); err != nil {
+		t.Fatal(err)
+	}
+	old := stale(p)
+	if err := p.Do(MemoryDB(), "pay", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := old.ForceState(MemoryDB(), "SHIPPED", 1, "lost"); !errors.Is(err, ErrConcurrentModification) {
+		t.Fatalf("ForceState of a stale parcel: %v, want ErrConcurrentModification", err)
+	}
+	assertParcel(t, old, "INITIALIZED", 0)
+}
+
+var keyedDefinition = NewDefinition("Order").
+	State("INITIALIZED", "PAID", "SHIPPED").
+	Trigger("pay").From("INITIALIZED").To("PAID").
+	Trigger("ship").From("PAID").To("SHIPPED").
+	MustBuild()
+
+type keyedOrder struct {
+	ID string
+	StateMachine
+}
+
+func (*keyedOrder) Define() *Definition {
+	return keyedDefinition
+}
+
+type providedOrder struct {
+	Code string `gorm:"primaryKey"`
+	StateMachine
+}
+
+func (*providedOrder) Define() *Definition {
+	return keyedDefinition
+}
+
+func (o *providedOrder) StateMachineObjectKey() string {
+	return "order-" + o.Code
+}
+
+// keyedMachine is the part of the StateMachine API of the keyed models the
+// tests use.
+type keyedMachine interface {
+	Stater
+	Do(tx *gorm.DB, trigger string, userInfoId uint, args ...interface{}) error
+	History(tx *gorm.DB, opts HistoryOptions) (*HistoryPage, error)
+	StateSpans(tx *gorm.DB) ([]StateSpan, error)
+}
+
+func TestStringKeys(t *testing.T) {
+	tests := []struct {
+		name   string
+		model  keyedMachine
+		object func(key string) keyedMachine
+		prefix string
+	}{
+		{
+			name:   "string primary key",
+			model:  &keyedOrder{},
+			object: func(key string) keyedMachine { return &keyedOrder{ID: key} },
+		},
+		{
+			name:   "IDProvider",
+			model:  &providedOrder{},
+			object: func(key string) keyedMachine { return &providedOrder{Code: key} },
+			prefix: "order-",
+		},
+	}
+	triggers := map[string][]string{"a": {"pay", "ship"}, "b": {"pay"}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t, tt.model)
+			for _, key := range []string{"a", "b"} {
+				obj := tt.object(key)
+				if err := db.Create(obj).Error; err != nil {
+					t.Fatal(err)
+				}
+				for _, trigger := range triggers[key] {
+					if err := obj.Do(db, trigger, 1); err != nil {
+						t.Fatalf("Do(%s) on %s: %v", trigger, key, err)
+					}
+				}
+			}
+
+			for key, fired := range triggers {
+				obj := tt.object(key)
+				obj.SetStater(obj)
+				page, err := obj.History(db, HistoryOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(page.Entries) != len(fired) {
+					t.Fatalf("%d log entries of %s, want %d", len(page.Entries), key, len(fired))
+				}
+				for i, entry := range page.Entries {
+					if entry.ObjectId != 0 || entry.ObjectKey != tt.prefix+key || entry.Trigger != fired[i] {
+						t.Errorf("log entry %d of %s: %s of object %d, key %q", i, key, entry.Trigger, entry.ObjectId, entry.ObjectKey)
+					}
+				}
+				spans, err := obj.StateSpans(db)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(spans) != len(fired) {
+					t.Errorf("%d state spans of %s, want %d", len(spans), key, len(fired))
+				}
+			}
+			state, err := StateAtKey(db, tt.model, tt.prefix+"b", time.Now())
+			if err != nil || state != "PAID" {
+				t.Errorf("StateAtKey of b: %s, %v, want PAID", state, err)
+			}
+		})
+	}
+}
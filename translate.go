@@ -0,0 +1,284 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// Lang is the printer translating the states and triggers of the machines
+// without one, unless SetPrinter sets another.
+var Lang = message.NewPrinter(language.Chinese)
+
+var (
+	printerMu sync.RWMutex
+	printer   *message.Printer
+)
+
+// SetPrinter sets the printer translating the states and triggers of the
+// machines without one of their own, see DefinitionBuilder.Printer; nil
+// restores Lang.
+func SetPrinter(p *message.Printer) {
+	printerMu.Lock()
+	defer printerMu.Unlock()
+	printer = p
+}
+
+type printerKey struct{}
+
+// WithPrinter returns ctx translating with p the states and triggers read
+// with it, e.g. in the language of a request, over the printer of their
+// machine: see TranslatedStateCtx, AvailableTriggersCtx and
+// AvailableTriggersWithGuards.
+func WithPrinter(ctx context.Context, p *message.Printer) context.Context {
+	return context.WithValue(ctx, printerKey{}, p)
+}
+
+// WithLanguage returns ctx translating in lang, from the default catalog of
+// golang.org/x/text/message, the states and triggers read with it, see
+// WithPrinter.
+func WithLanguage(ctx context.Context, lang language.Tag) context.Context {
+	return WithPrinter(ctx, message.NewPrinter(lang))
+}
+
+var (
+	languageMu        sync.RWMutex
+	languageExtractor func(ctx context.Context) language.Tag
+)
+
+// ExtractLanguage sets the func returning the language of the contexts
+// without a printer set by WithPrinter or WithLanguage, e.g. from the
+// Accept-Language of their request; language.Und leaves the printer of the
+// machine. nil, the default, returns none.
+func ExtractLanguage(extractor func(ctx context.Context) language.Tag) {
+	languageMu.Lock()
+	defer languageMu.Unlock()
+	languageExtractor = extractor
+}
+
+// MatchLanguage returns the language of the default catalog best matching an
+// Accept-Language header, language.Und if none does.
+func MatchLanguage(acceptLanguage string) language.Tag {
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tags) == 0 {
+		return language.Und
+	}
+	supported := message.DefaultCatalog.Languages()
+	if len(supported) == 0 {
+		return language.Und
+	}
+	_, i, confidence := language.NewMatcher(supported).Match(tags...)
+	if confidence == language.No {
+		return language.Und
+	}
+	return supported[i]
+}
+
+// PrinterOf returns the printer translating the states and triggers of
+// definition read with ctx: the one set by WithPrinter, of the language
+// returned by the ExtractLanguage func, by the Printer of definition, by
+// SetPrinter, or Lang.
+func PrinterOf(ctx context.Context, definition *Definition) *message.Printer {
+	if p, ok := ctx.Value(printerKey{}).(*message.Printer); ok && p != nil {
+		return p
+	}
+	languageMu.RLock()
+	extractor := languageExtractor
+	languageMu.RUnlock()
+	if extractor != nil {
+		if lang := extractor(ctx); lang != language.Und {
+			return message.NewPrinter(lang)
+		}
+	}
+	return machinePrinter(definition)
+}
+
+// machinePrinter returns the printer of definition whatever the context: its
+// own, the one set by SetPrinter, or Lang.
+func machinePrinter(definition *Definition) *message.Printer {
+	if definition != nil && definition.printer != nil {
+		return definition.printer
+	}
+	printerMu.RLock()
+	defer printerMu.RUnlock()
+	if printer != nil {
+		return printer
+	}
+	return Lang
+}
+
+// KeyFunc returns the catalog key of the translation of value, a state or a
+// trigger of the model objectStruct as kind tells: KindState, KindTrigger, or
+// KindDescription for the description of the trigger value. objectStruct is
+// the name of the definition of the machine, the struct name of the model
+// unless NewDefinition named it otherwise.
+type KeyFunc func(objectStruct, kind, value string) string
+
+// The kinds of the translated values.
+const (
+	KindState       = "state"
+	KindTrigger     = "trigger"
+	KindDescription = "description"
+)
+
+// DefaultKeyFunc returns the keys of the states and triggers of a model
+// prefixed by its name, e.g. "Order:PAID" and "Order:pay", and of the
+// descriptions of its triggers suffixed, e.g. "Order:pay:description".
+func DefaultKeyFunc(objectStruct, kind, value string) string {
+	if kind == KindDescription {
+		return objectStruct + ":" + value + ":" + kind
+	}
+	return objectStruct + ":" + value
+}
+
+var (
+	keyFuncMu sync.RWMutex
+	keyFunc   KeyFunc = DefaultKeyFunc
+)
+
+// SetKeyFunc sets the func returning the catalog keys of the translations,
+// e.g. to reuse a catalog keyed by "order.state.paid"; nil restores
+// DefaultKeyFunc.
+func SetKeyFunc(fn KeyFunc) {
+	keyFuncMu.Lock()
+	defer keyFuncMu.Unlock()
+	if fn == nil {
+		fn = DefaultKeyFunc
+	}
+	keyFunc = fn
+}
+
+// TranslationKey returns the catalog key of the translation of value, of
+// kind, of the model objectStruct, by the func set with SetKeyFunc.
+func TranslationKey(objectStruct, kind, value string) string {
+	keyFuncMu.RLock()
+	fn := keyFunc
+	keyFuncMu.RUnlock()
+	return fn(objectStruct, kind, value)
+}
+
+var (
+	fallbackMu sync.RWMutex
+	fallback   = Humanize
+)
+
+// SetTranslationFallback sets the func labeling the states and triggers that
+// neither the printer of their context nor the one of their machine
+// translates; nil restores Humanize.
+func SetTranslationFallback(fn func(name string) string) {
+	fallbackMu.Lock()
+	defer fallbackMu.Unlock()
+	if fn == nil {
+		fn = Humanize
+	}
+	fallback = fn
+}
+
+// Humanize returns a state or trigger name as a label: its words, split on
+// underscores, dashes and case changes, in lower case but the first letter,
+// e.g. "Payment pending" for PAYMENT_PENDING and "Cancel order" for
+// cancelOrder.
+func Humanize(name string) string {
+	var words []string
+	var word []rune
+	runes := []rune(name)
+	for i, c := range runes {
+		if c == '_' || c == '-' || c == ' ' {
+			if len(word) > 0 {
+				words, word = append(words, string(word)), nil
+			}
+			continue
+		}
+		if i > 0 && unicode.IsUpper(c) && unicode.IsLower(runes[i-1]) && len(word) > 0 {
+			words, word = append(words, string(word)), nil
+		}
+		word = append(word, unicode.ToLower(c))
+	}
+	if len(word) > 0 {
+		words = append(words, string(word))
+	}
+	label := []rune(strings.Join(words, " "))
+	if len(label) > 0 {
+		label[0] = unicode.ToUpper(label[0])
+	}
+	return string(label)
+}
+
+var (
+	logMissing  int32
+	missingKeys sync.Map
+)
+
+// LogMissingTranslations, if enabled, warns once of each key of a state or
+// trigger that neither the printer of its context nor the one of its machine
+// translates.
+func LogMissingTranslations(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&logMissing, v)
+}
+
+// Translate returns the translation of name, a state or trigger of d as told
+// by kind, like TranslatedStateCtx does, e.g. for the states of no object:
+//
+//	label := definition.Translate(ctx, common.KindState, "PAID")
+func (d *Definition) Translate(ctx context.Context, kind, name string) string {
+	return translate(ctx, d, kind, name)
+}
+
+// translate returns the translation of name, of kind, of the machine of
+// definition, keyed by the name of definition, by the printer of ctx, else
+// by the printer of the machine, else the translation fallback of name.
+func translate(ctx context.Context, definition *Definition, kind, name string) string {
+	if definition != nil {
+		if translated, ok := lookup(ctx, definition, TranslationKey(definition.name, kind, name)); ok {
+			return translated
+		}
+	}
+	fallbackMu.RLock()
+	fn := fallback
+	fallbackMu.RUnlock()
+	return fn(name)
+}
+
+// lookup returns the translation of key by the printer of ctx, else by the
+// printer of the machine of definition, and whether one has it.
+func lookup(ctx context.Context, definition *Definition, key string) (string, bool) {
+	p := PrinterOf(ctx, definition)
+	if translated := p.Sprintf(key); translated != key {
+		return translated, true
+	}
+	if machine := machinePrinter(definition); machine != p {
+		if translated := machine.Sprintf(key); translated != key {
+			return translated, true
+		}
+	}
+	if atomic.LoadInt32(&logMissing) == 1 {
+		if _, logged := missingKeys.LoadOrStore(key, true); !logged {
+			currentLogger().Warn("missing translation", "key", key)
+		}
+	}
+	return "", false
+}
+
+// translateMessage returns the message of format and args, keyed in the
+// catalogs by format, translated as by lookup, untranslated if none has it.
+func translateMessage(ctx context.Context, definition *Definition, format string, args ...interface{}) string {
+	untranslated := fmt.Sprintf(format, args...)
+	p := PrinterOf(ctx, definition)
+	if translated := p.Sprintf(format, args...); translated != untranslated {
+		return translated
+	}
+	if machine := machinePrinter(definition); machine != p {
+		return machine.Sprintf(format, args...)
+	}
+	return untranslated
+}
@@ -0,0 +1,94 @@
+package common
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/message/catalog"
+)
+
+// printerOf returns a printer of lang translating with messages only.
+func printerOf(lang language.Tag, messages map[string]string) *message.Printer {
+	cat := catalog.NewBuilder()
+	for key, msg := range messages {
+		if err := cat.SetString(lang, key, msg); err != nil {
+			panic(err)
+		}
+	}
+	return message.NewPrinter(lang, message.Catalog(cat))
+}
+
+func TestHumanize(t *testing.T) {
+	tests := map[string]string{
+		"PAYMENT_PENDING": "Payment pending",
+		"cancelOrder":     "Cancel order",
+		"in-review":       "In review",
+		"PAID":            "Paid",
+		"__ship__":        "Ship",
+		"":                "",
+	}
+	for name, want := range tests {
+		if got := Humanize(name); got != want {
+			t.Errorf("Humanize(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestTranslateFallbackChain(t *testing.T) {
+	definition := NewDefinition("Shipment").
+		State("INITIALIZED", "PAID", "SHIPPED", "PAYMENT_PENDING").
+		Printer(printerOf(language.French, map[string]string{
+			"Shipment:PAID":    "Payée",
+			"Shipment:SHIPPED": "Expédiée",
+			"Shipment:ship":    "Expédier",
+		})).
+		Trigger("ship").From("PAID").To("SHIPPED").
+		Trigger("pay").From("INITIALIZED").To("PAID").
+		MustBuild()
+	german := WithPrinter(context.Background(), printerOf(language.German, map[string]string{
+		"Shipment:SHIPPED": "Versandt",
+	}))
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		kind     string
+		value    string
+		fallback func(string) string
+		want     string
+	}{
+		{name: "printer of the context", ctx: german, kind: KindState, value: "SHIPPED", want: "Versandt"},
+		{name: "printer of the machine", ctx: german, kind: KindState, value: "PAID", want: "Payée"},
+		{name: "context without printer", ctx: context.Background(), kind: KindState, value: "SHIPPED", want: "Expédiée"},
+		{name: "trigger", ctx: german, kind: KindTrigger, value: "ship", want: "Expédier"},
+		{name: "humanized", ctx: german, kind: KindState, value: "PAYMENT_PENDING", want: "Payment pending"},
+		{name: "fallback", ctx: german, kind: KindTrigger, value: "pay", fallback: strings.ToUpper, want: "PAY"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.fallback != nil {
+				SetTranslationFallback(tt.fallback)
+				defer SetTranslationFallback(nil)
+			}
+			if got := definition.Translate(tt.ctx, tt.kind, tt.value); got != tt.want {
+				t.Errorf("Translate(%s %s) = %q, want %q", tt.kind, tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMermaidLabelsFallBack(t *testing.T) {
+	definition := NewDefinition("Shipment").
+		State("INITIALIZED", "PAYMENT_PENDING").
+		Printer(printerOf(language.French, map[string]string{"Shipment:INITIALIZED": "Créée"})).
+		Trigger("pay").From("INITIALIZED").To("PAYMENT_PENDING").
+		MustBuild()
+	mermaid := definition.ToMermaid()
+	for _, label := range []string{"INITIALIZED : Créée", "PAYMENT_PENDING : Payment pending", "PAYMENT_PENDING : Pay"} {
+		if !strings.Contains(mermaid, label) {
+			t.Errorf("ToMermaid has no %q:\n%s", label, mermaid)
+		}
+	}
+}
@@ -0,0 +1,409 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+type CallbackFunc func(ctx context.Context, tx *gorm.DB, args ...interface{}) error
+
+type ConditionFunc func(ctx context.Context, tx *gorm.DB, args ...interface{}) bool
+
+// GuardFunc is a condition explaining its refusals: reason is reported to the
+// caller when ok is false.
+type GuardFunc func(ctx context.Context, tx *gorm.DB, args ...interface{}) (ok bool, reason string, err error)
+
+// DestFunc picks the destination of a trigger at runtime.
+type DestFunc func(ctx context.Context, tx *gorm.DB, args ...interface{}) (string, error)
+
+// AnyState as a source lets a trigger fire from every state not listed in
+// TriggerConfig.Except. The map form spells it "*", or "*!SHIPPED,DELIVERED"
+// with exclusions.
+const AnyState = "*"
+
+// HistoryState as a destination sends the object back to the state it held
+// before entering its current one, e.g. for a "resume" after a "suspend".
+const HistoryState = "HISTORY"
+
+// DefaultBranch is logged when none of the Branches of a trigger matched and
+// its Dest was used.
+const DefaultBranch = "default"
+
+// Branch is one conditional destination of a choice trigger.
+type Branch struct {
+	Name  string
+	Guard ConditionFunc
+	Dest  string
+}
+
+func (b *Branch) name(i int) string {
+	if b.Name != "" {
+		return b.Name
+	}
+	return fmt.Sprintf("#%d", i+1)
+}
+
+// TriggerMetadata describes a trigger to frontends, e.g. to render its button.
+type TriggerMetadata struct {
+	DisplayName    string
+	Description    string
+	Icon           string
+	ConfirmMessage string
+	// Extra holds any other UI hint.
+	Extra map[string]string
+}
+
+func triggerMetadataFromMap(m map[string]interface{}) (TriggerMetadata, error) {
+	var md TriggerMetadata
+	for key, value := range m {
+		s, ok := value.(string)
+		if !ok {
+			return md, fmt.Errorf("metadata %s must be a string, got %T", key, value)
+		}
+		switch key {
+		case "display_name":
+			md.DisplayName = s
+		case "description":
+			md.Description = s
+		case "icon":
+			md.Icon = s
+		case "confirm_message":
+			md.ConfirmMessage = s
+		default:
+			if md.Extra == nil {
+				md.Extra = map[string]string{}
+			}
+			md.Extra[key] = s
+		}
+	}
+	return md, nil
+}
+
+// TriggerConfig is the typed form of a single entry of the Triggers() map.
+type TriggerConfig struct {
+	Source []string
+	Except []string
+	Dest   string
+	// DestFunc, when set, replaces Dest.
+	DestFunc DestFunc
+	// Branches are tried in order, the first whose Guard passes gives the
+	// destination; Dest is the default when none does.
+	Branches  []Branch
+	Before    CallbackFunc
+	After     CallbackFunc
+	Condition ConditionFunc
+	// Guard is checked after Condition.
+	Guard GuardFunc
+	// Internal triggers run their callbacks and are logged, but leave the
+	// state untouched; Dest is ignored.
+	Internal bool
+	// Reentrant allows Dest to equal the current state: the transition is then
+	// performed again in full. Without it such a self-transition is rejected.
+	Reentrant bool
+	// Deferrable triggers fired from a state they can not leave are stored
+	// instead of failing, and fired once the object reaches one of their
+	// sources, after the transition that reached it; their errors are logged
+	// and they stay pending. See StateMachinePendingTrigger.
+	Deferrable bool
+	// Priority orders triggers sharing a source, highest first, in
+	// AvailableTriggers and DoNext. Ties keep declaration order.
+	Priority int
+	Metadata TriggerMetadata
+	// IgnoreIfIn are the states in which the trigger is a logged no-op, e.g.
+	// "pay" received while already PAID. Do then returns nil, see
+	// TransitionResult.AlreadyInState.
+	IgnoreIfIn []string
+	// Aliases are former names of the trigger, still accepted by Do; the
+	// trigger is logged under its own name.
+	Aliases []string
+}
+
+func (tc *TriggerConfig) ignores(states ...string) bool {
+	for _, ignored := range tc.IgnoreIfIn {
+		for _, state := range states {
+			if ignored == state {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (tc *TriggerConfig) isRejectedSelfTransition(state string) bool {
+	return !tc.Internal && !tc.Reentrant && tc.DestFunc == nil && len(tc.Branches) == 0 && tc.Dest == state
+}
+
+// hasSource reports whether the trigger may fire from a state whose ancestry,
+// the state itself first, is states.
+func (tc *TriggerConfig) hasSource(states ...string) bool {
+	for _, state := range states {
+		for _, except := range tc.Except {
+			if except == state {
+				return false
+			}
+		}
+	}
+	for _, src := range tc.Source {
+		if src == AnyState {
+			return true
+		}
+		for _, state := range states {
+			if src == state {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// TriggerConfiger is implemented by staters declaring their triggers with TriggerConfig.
+type TriggerConfiger interface {
+	TriggerConfigs() map[string]*TriggerConfig
+}
+
+// MapTriggerer is implemented by staters still declaring their triggers with the
+// legacy map form, e.g. {"pay": {"source": "INITIALIZED", "dest": "PAID"}}.
+type MapTriggerer interface {
+	Triggers() map[string]map[string]interface{}
+}
+
+func TriggerConfigsFromMap(triggers map[string]map[string]interface{}) (map[string]*TriggerConfig, error) {
+	configs := make(map[string]*TriggerConfig, len(triggers))
+	for trigger, config := range triggers {
+		tc, err := TriggerConfigFromMap(config)
+		if err != nil {
+			return nil, fmt.Errorf("trigger %s: %w", trigger, err)
+		}
+		configs[trigger] = tc
+	}
+	return configs, nil
+}
+
+func TriggerConfigFromMap(config map[string]interface{}) (*TriggerConfig, error) {
+	tc := &TriggerConfig{}
+	for key, value := range config {
+		if value == nil {
+			continue
+		}
+		switch key {
+		case "source":
+			source, except, err := parseSource(value)
+			if err != nil {
+				return nil, err
+			}
+			tc.Source, tc.Except = source, except
+		case "dest", "before", "after", "condition", "guard", "branches":
+			if err := tc.setFromMap(key, value); err != nil {
+				return nil, err
+			}
+		case "internal":
+			internal, ok := value.(bool)
+			if !ok {
+				return nil, fmt.Errorf("internal must be a bool, got %T", value)
+			}
+			tc.Internal = internal
+		case "reentrant":
+			reentrant, ok := value.(bool)
+			if !ok {
+				return nil, fmt.Errorf("reentrant must be a bool, got %T", value)
+			}
+			tc.Reentrant = reentrant
+		case "priority":
+			priority, ok := value.(int)
+			if !ok {
+				return nil, fmt.Errorf("priority must be an int, got %T", value)
+			}
+			tc.Priority = priority
+		case "defer":
+			deferrable, ok := value.(bool)
+			if !ok {
+				return nil, fmt.Errorf("defer must be a bool, got %T", value)
+			}
+			tc.Deferrable = deferrable
+		case "ignore_if_in":
+			states, except, err := parseSource(value)
+			if err != nil || except != nil {
+				return nil, fmt.Errorf("ignore_if_in must list states, got %v", value)
+			}
+			tc.IgnoreIfIn = states
+		case "aliases":
+			switch v := value.(type) {
+			case []string:
+				tc.Aliases = v
+			case string:
+				for _, alias := range strings.Split(v, ",") {
+					if alias = strings.TrimSpace(alias); alias != "" {
+						tc.Aliases = append(tc.Aliases, alias)
+					}
+				}
+			default:
+				return nil, fmt.Errorf("aliases must be a []string or a comma separated string, got %T", value)
+			}
+		case "metadata":
+			switch m := value.(type) {
+			case TriggerMetadata:
+				tc.Metadata = m
+			case map[string]interface{}:
+				md, err := triggerMetadataFromMap(m)
+				if err != nil {
+					return nil, err
+				}
+				tc.Metadata = md
+			case map[string]string:
+				generic := make(map[string]interface{}, len(m))
+				for k, v := range m {
+					generic[k] = v
+				}
+				md, _ := triggerMetadataFromMap(generic)
+				tc.Metadata = md
+			default:
+				return nil, fmt.Errorf("metadata must be a map, got %T", value)
+			}
+		default:
+			return nil, fmt.Errorf("unknown key %q", key)
+		}
+	}
+	if tc.Source == nil {
+		return nil, fmt.Errorf("missing source")
+	}
+	if tc.Dest == "" && tc.DestFunc == nil && len(tc.Branches) == 0 && !tc.Internal {
+		return nil, fmt.Errorf("missing dest")
+	}
+	return tc, nil
+}
+
+// hasFuncs reports whether tc declares funcs, which may be bound to the
+// instance declaring them.
+func (tc *TriggerConfig) hasFuncs() bool {
+	return tc.Before != nil || tc.After != nil || tc.Condition != nil || tc.Guard != nil ||
+		tc.DestFunc != nil || len(tc.Branches) > 0
+}
+
+// bindFuncs sets the funcs of tc to those of from.
+func (tc *TriggerConfig) bindFuncs(from *TriggerConfig) {
+	tc.Before, tc.After, tc.Condition, tc.Guard, tc.DestFunc = from.Before, from.After, from.Condition, from.Guard, from.DestFunc
+	tc.Branches = from.Branches
+}
+
+// mapFuncKeys are the keys of the map form whose values are funcs, which may
+// be bound to the instance declaring them.
+var mapFuncKeys = []string{"dest", "before", "after", "condition", "guard", "branches"}
+
+func (tc *TriggerConfig) setFromMap(key string, value interface{}) error {
+	switch key {
+	case "dest":
+		switch dest := value.(type) {
+		case string:
+			tc.Dest = dest
+		case DestFunc:
+			tc.DestFunc = dest
+		case func(context.Context, *gorm.DB, ...interface{}) (string, error):
+			tc.DestFunc = dest
+		case func(*gorm.DB, ...interface{}) (string, error):
+			tc.DestFunc = func(_ context.Context, tx *gorm.DB, args ...interface{}) (string, error) {
+				return dest(tx, args...)
+			}
+		default:
+			return fmt.Errorf("dest must be a string or a func(context.Context, *gorm.DB, ...interface{}) (string, error), got %T", value)
+		}
+	case "before", "after":
+		var fn CallbackFunc
+		switch f := value.(type) {
+		case string:
+			var ok bool
+			if fn, ok = LookupCallback(f); !ok {
+				return fmt.Errorf("%w: %s %s", ErrUnknownCallback, key, f)
+			}
+		case CallbackFunc:
+			fn = f
+		case func(context.Context, *gorm.DB, ...interface{}) error:
+			fn = f
+		case func(*gorm.DB, ...interface{}) error:
+			fn = func(_ context.Context, tx *gorm.DB, args ...interface{}) error {
+				return f(tx, args...)
+			}
+		default:
+			return fmt.Errorf("%s must be a func(context.Context, *gorm.DB, ...interface{}) error, got %T", key, value)
+		}
+		if key == "before" {
+			tc.Before = fn
+		} else {
+			tc.After = fn
+		}
+	case "condition":
+		switch f := value.(type) {
+		case string:
+			var ok bool
+			if tc.Condition, ok = LookupCondition(f); !ok {
+				return fmt.Errorf("%w: condition %s", ErrUnknownCallback, f)
+			}
+		case ConditionFunc:
+			tc.Condition = f
+		case func(context.Context, *gorm.DB, ...interface{}) bool:
+			tc.Condition = f
+		case func(*gorm.DB, ...interface{}) bool:
+			tc.Condition = func(_ context.Context, tx *gorm.DB, args ...interface{}) bool {
+				return f(tx, args...)
+			}
+		default:
+			return fmt.Errorf("condition must be a func(context.Context, *gorm.DB, ...interface{}) bool, got %T", value)
+		}
+	case "guard":
+		switch f := value.(type) {
+		case string:
+			var ok bool
+			if tc.Guard, ok = LookupGuard(f); !ok {
+				return fmt.Errorf("%w: guard %s", ErrUnknownCallback, f)
+			}
+		case GuardFunc:
+			tc.Guard = f
+		case func(context.Context, *gorm.DB, ...interface{}) (bool, string, error):
+			tc.Guard = f
+		default:
+			return fmt.Errorf("guard must be a func(context.Context, *gorm.DB, ...interface{}) (bool, string, error), got %T", value)
+		}
+	case "branches":
+		branches, ok := value.([]Branch)
+		if !ok {
+			return fmt.Errorf("branches must be a []Branch, got %T", value)
+		}
+		tc.Branches = branches
+	}
+	return nil
+}
+
+// parseSource accepts a []string, or the comma separated string of the legacy
+// map form. A leading "*!" turns the remaining states into exclusions.
+func parseSource(value interface{}) (source, except []string, err error) {
+	var states []string
+	switch v := value.(type) {
+	case []string:
+		states = v
+	case string:
+		states = strings.Split(v, ",")
+	default:
+		return nil, nil, fmt.Errorf("source must be a []string or a comma separated string, got %T", value)
+	}
+	for i, state := range states {
+		state = strings.TrimSpace(state)
+		if i == 0 && strings.HasPrefix(state, AnyState+"!") {
+			source, except = []string{AnyState}, []string{}
+			state = strings.TrimSpace(state[len(AnyState+"!"):])
+		}
+		if state == "" {
+			continue
+		}
+		if except != nil {
+			except = append(except, state)
+		} else {
+			source = append(source, state)
+		}
+	}
+	if len(source) == 0 {
+		return nil, nil, fmt.Errorf("empty source")
+	}
+	return source, except, nil
+}
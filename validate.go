@@ -0,0 +1,240 @@
+package common
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ValidationIssue is one finding of Validate.
+type ValidationIssue struct {
+	Region  string
+	State   string
+	Trigger string
+	Message string
+}
+
+func (i ValidationIssue) String() string {
+	var where []string
+	if i.Region != "" {
+		where = append(where, "region "+i.Region)
+	}
+	if i.Trigger != "" {
+		where = append(where, "trigger "+i.Trigger)
+	}
+	if i.State != "" {
+		where = append(where, "state "+i.State)
+	}
+	if len(where) == 0 {
+		return i.Message
+	}
+	return strings.Join(where, ", ") + ": " + i.Message
+}
+
+// ValidationReport lists the problems of a definition. Errors make some
+// transitions impossible, Warnings are likely mistakes.
+type ValidationReport struct {
+	Name     string
+	Errors   []ValidationIssue
+	Warnings []ValidationIssue
+}
+
+func (r *ValidationReport) OK() bool {
+	return len(r.Errors) == 0
+}
+
+// Err returns the errors of the report as one error matching
+// ErrInvalidDefinition, or nil.
+func (r *ValidationReport) Err() error {
+	if r.OK() {
+		return nil
+	}
+	msgs := make([]string, len(r.Errors))
+	for i, issue := range r.Errors {
+		msgs[i] = issue.String()
+	}
+	return fmt.Errorf("%w %s: %s", ErrInvalidDefinition, r.Name, strings.Join(msgs, "; "))
+}
+
+// Validate cross-checks the machine of model, typically at startup:
+//
+//	if report, err := Validate(&Order{}); err != nil || !report.OK() { ... }
+func Validate(model Stater) (*ValidationReport, error) {
+	definition, err := DefinitionOf(model)
+	if err != nil {
+		return nil, err
+	}
+	return definition.Validate(), nil
+}
+
+// Validate checks that every state used by a trigger is declared, that every
+// state can be reached from the initial one, and warns about non-final states
+// no trigger leaves. Machines not declaring their states are checked against
+// the states their triggers use.
+func (d *Definition) Validate() *ValidationReport {
+	report := &ValidationReport{Name: d.name}
+	for _, r := range d.allRegions() {
+		r.definition.validate(r.name, report)
+	}
+	return report
+}
+
+func (d *Definition) validate(regionName string, report *ValidationReport) {
+	issue := func(state, trigger, format string, args ...interface{}) ValidationIssue {
+		return ValidationIssue{Region: regionName, State: state, Trigger: trigger, Message: fmt.Sprintf(format, args...)}
+	}
+
+	states := d.States()
+	if len(states) == 0 {
+		report.Warnings = append(report.Warnings, issue("", "", "no states declared, using the states of the triggers"))
+		states = d.usedStates()
+	}
+	known := map[string]bool{}
+	for _, state := range states {
+		known[state] = true
+	}
+	unknown := func(trigger, role, state string) {
+		if !known[state] {
+			report.Errors = append(report.Errors, issue(state, trigger, "unknown %s state", role))
+		}
+	}
+
+	if !known[d.InitialState()] {
+		report.Errors = append(report.Errors, issue(d.InitialState(), "", "unknown initial state"))
+	}
+	runtimeDest := false
+	for _, trigger := range d.order {
+		tc := d.triggers[trigger]
+		for _, src := range tc.Source {
+			if src != AnyState {
+				unknown(trigger, "source", src)
+			}
+		}
+		for _, except := range tc.Except {
+			unknown(trigger, "excepted", except)
+		}
+		for _, ignored := range tc.IgnoreIfIn {
+			unknown(trigger, "ignored", ignored)
+		}
+		if tc.Dest != "" && tc.Dest != HistoryState && !tc.Internal {
+			unknown(trigger, "dest", tc.Dest)
+		}
+		for _, branch := range tc.Branches {
+			if branch.Dest != HistoryState {
+				unknown(trigger, "branch dest", branch.Dest)
+			}
+		}
+		if tc.DestFunc != nil {
+			runtimeDest = true
+		}
+	}
+
+	reachable := d.reachable(states)
+	for _, state := range states {
+		if reachable[state] {
+			continue
+		}
+		i := issue(state, "", "unreachable from %s", d.InitialState())
+		if runtimeDest {
+			i.Message += ", unless by a trigger with a DestFunc"
+			report.Warnings = append(report.Warnings, i)
+		} else {
+			report.Errors = append(report.Errors, i)
+		}
+	}
+
+	for _, state := range states {
+		if d.IsFinal(state) || d.isParent(state) {
+			continue
+		}
+		if len(d.outgoing(state)) == 0 {
+			report.Warnings = append(report.Warnings, issue(state, "", "no trigger leaves this non-final state"))
+		}
+	}
+}
+
+// usedStates returns the states named by the triggers, sorted.
+func (d *Definition) usedStates() []string {
+	seen := map[string]bool{d.InitialState(): true}
+	for _, tc := range d.triggers {
+		for _, src := range tc.Source {
+			if src != AnyState {
+				seen[src] = true
+			}
+		}
+		if tc.Dest != "" && tc.Dest != HistoryState && !tc.Internal {
+			seen[tc.Dest] = true
+		}
+		for _, branch := range tc.Branches {
+			if branch.Dest != HistoryState {
+				seen[branch.Dest] = true
+			}
+		}
+	}
+	for _, state := range d.final {
+		seen[state] = true
+	}
+	states := make([]string, 0, len(seen))
+	for state := range seen {
+		states = append(states, state)
+	}
+	sort.Strings(states)
+	return states
+}
+
+// outgoing returns the triggers firing from state, ignoring conditions.
+func (d *Definition) outgoing(state string) []string {
+	var triggers []string
+	for _, trigger := range d.order {
+		tc := d.triggers[trigger]
+		if tc.hasSource(d.Ancestry(state)...) && !tc.Internal && !tc.isRejectedSelfTransition(state) {
+			triggers = append(triggers, trigger)
+		}
+	}
+	return triggers
+}
+
+// reachable returns the states reachable from the initial state with the
+// static destinations of the triggers.
+func (d *Definition) reachable(states []string) map[string]bool {
+	reached := map[string]bool{d.InitialState(): true}
+	queue := []string{d.InitialState()}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		if d.IsFinal(state) {
+			continue
+		}
+		for _, trigger := range d.outgoing(state) {
+			tc := d.triggers[trigger]
+			dests := []string{tc.Dest}
+			for _, branch := range tc.Branches {
+				dests = append(dests, branch.Dest)
+			}
+			for _, dest := range dests {
+				if dest != "" && dest != HistoryState && !reached[dest] {
+					reached[dest] = true
+					queue = append(queue, dest)
+				}
+			}
+		}
+	}
+	// Being in a sub-state is being in its parents.
+	for _, state := range states {
+		if reached[state] {
+			for _, s := range d.Ancestry(state)[1:] {
+				reached[s] = true
+			}
+		}
+	}
+	return reached
+}
+
+func (d *Definition) isParent(state string) bool {
+	for _, parent := range d.parents {
+		if parent == state {
+			return true
+		}
+	}
+	return false
+}
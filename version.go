@@ -0,0 +1,64 @@
+package common
+
+import (
+	"reflect"
+	"sync"
+)
+
+// versionField is the integer field of a model tagged sm:"version", which
+// locks its transitions optimistically: every state UPDATE requires the
+// version read with the row and increments it, so that of two concurrent
+// transitions of an object, the second fails with ErrConcurrentModification.
+//
+//	type Order struct {
+//		gorm.Model
+//		common.StateMachine
+//		Version uint `sm:"version"`
+//	}
+type versionField struct {
+	name string
+}
+
+// versionFields caches the *versionField of each model type, nil if it has
+// none.
+var versionFields sync.Map
+
+func versionFieldOf(stater Stater) *versionField {
+	t := reflect.TypeOf(stater)
+	if f, ok := versionFields.Load(t); ok {
+		return f.(*versionField)
+	}
+	var field *versionField
+	st := t
+	if st.Kind() == reflect.Ptr {
+		st = st.Elem()
+	}
+	if st.Kind() == reflect.Struct {
+		for i := 0; i < st.NumField(); i++ {
+			f := st.Field(i)
+			if f.Tag.Get("sm") != "version" || f.PkgPath != "" {
+				continue
+			}
+			switch f.Type.Kind() {
+			case reflect.Int, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+				field = &versionField{name: f.Name}
+			}
+			break
+		}
+	}
+	versionFields.Store(t, field)
+	return field
+}
+
+func (f *versionField) of(stater Stater) reflect.Value {
+	return reflect.Indirect(reflect.ValueOf(stater)).FieldByName(f.name)
+}
+
+func (f *versionField) increment(stater Stater) {
+	v := f.of(stater)
+	if v.CanInt() {
+		v.SetInt(v.Int() + 1)
+	} else {
+		v.SetUint(v.Uint() + 1)
+	}
+}
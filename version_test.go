@@ -0,0 +1,65 @@
+package common
+
+import (
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestVersionLocksTransitions(t *testing.T) {
+	tests := []struct {
+		name string
+		// behind changes the row of the parcel after it was read.
+		behind  func(db *gorm.DB, p *restoredParcel) error
+		err     error
+		state   string
+		version int64
+		stored  string
+	}{
+		{name: "version read", state: "PAID", version: 1, stored: "PAID"},
+		{
+			name: "transition since read",
+			behind: func(db *gorm.DB, p *restoredParcel) error {
+				return stale(p).Do(db, "pay", 1)
+			},
+			err:    ErrConcurrentModification,
+			state:  "INITIALIZED",
+			stored: "PAID",
+		},
+		{
+			name: "version bumped since read",
+			behind: func(db *gorm.DB, p *restoredParcel) error {
+				return db.Model(&restoredParcel{}).Where("id = ?", p.ID).Update("version", 1).Error
+			},
+			err:    ErrConcurrentModification,
+			state:  "INITIALIZED",
+			stored: "INITIALIZED",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t, &restoredParcel{})
+			p := &restoredParcel{}
+			if err := db.Create(p).Error; err != nil {
+				t.Fatal(err)
+			}
+			if tt.behind != nil {
+				if err := tt.behind(db, p); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := p.Do(db, "pay", 1); !errors.Is(err, tt.err) {
+				t.Fatalf("Do(pay): %v, want %v", err, tt.err)
+			}
+			assertParcel(t, p, tt.state, tt.version)
+			var stored restoredParcel
+			if err := db.First(&stored, p.ID).Error; err != nil {
+				t.Fatal(err)
+			}
+			if stored.State != tt.stored {
+				t.Errorf("stored parcel is %s, want %s", stored.State, tt.stored)
+			}
+		})
+	}
+}
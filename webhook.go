@@ -0,0 +1,258 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Webhook is an endpoint notified of the transitions of a model, see
+// RegisterWebhook.
+type Webhook struct {
+	URL string
+	// Secret signs the payloads: the X-SM-Signature header is "sha256=" and
+	// the hex HMAC-SHA256 of the body, see SignWebhook.
+	Secret string
+	// Triggers restricts the notifications to these triggers, all if empty.
+	Triggers []string
+	// MaxAttempts is the number of deliveries tried before giving up,
+	// DefaultWebhookMaxAttempts if 0.
+	MaxAttempts int
+	// RetryDelay is the delay before the first retry, doubled after each
+	// failure, DefaultWebhookRetryDelay if 0.
+	RetryDelay time.Duration
+}
+
+const (
+	DefaultWebhookMaxAttempts = 8
+	DefaultWebhookRetryDelay  = time.Minute
+)
+
+// Statuses of a StateMachineWebhookDelivery.
+const (
+	WebhookPending   = "pending"
+	WebhookDelivered = "delivered"
+	WebhookFailed    = "failed"
+)
+
+// StateMachineWebhookDelivery is a notification of a webhook, written in the
+// transaction of its transition and sent by DeliverWebhooks once committed.
+type StateMachineWebhookDelivery struct {
+	gorm.Model
+	ObjectId      uint   `gorm:"not null; index"`
+	ObjectStruct  string `gorm:"not null; index; varchar(64)"`
+	Trigger       string `gorm:"not null; varchar(64)"`
+	URL           string `gorm:"not null"`
+	Payload       string `gorm:"not null"`
+	Status        string `gorm:"not null; index; varchar(16)"`
+	Attempts      int    `gorm:"not null; default:0"`
+	LastError     string
+	NextAttemptAt time.Time `gorm:"not null; index"`
+	DeliveredAt   *time.Time
+}
+
+func AutoMigrateStateMachineWebhookDelivery(tx *gorm.DB) {
+	if err := tx.AutoMigrate(&StateMachineWebhookDelivery{}); err != nil {
+		panic(err)
+	}
+}
+
+var (
+	webhooksMu  sync.RWMutex
+	webhooks    = map[string][]Webhook{}
+	webhookHook sync.Once
+)
+
+// RegisterWebhook notifies hook of the transitions of the objects of model's
+// type. Every successful transition, reverts and forced states included,
+// writes a pending StateMachineWebhookDelivery per matching webhook; run
+// DeliverWebhooks or RunWebhooks to send them. Deliveries happen at least
+// once: receivers should ignore the X-SM-Delivery IDs they already handled.
+func RegisterWebhook(model Stater, hook Webhook) {
+	webhookHook.Do(func() {
+		OnAnyTransition(enqueueWebhooks)
+	})
+	webhooksMu.Lock()
+	defer webhooksMu.Unlock()
+	webhooks[StructName(model)] = append(webhooks[StructName(model)], hook)
+}
+
+func (w Webhook) accepts(trigger string) bool {
+	if len(w.Triggers) == 0 {
+		return true
+	}
+	for _, t := range w.Triggers {
+		if t == trigger {
+			return true
+		}
+	}
+	return false
+}
+
+func webhookOf(objectStruct, url string) (Webhook, bool) {
+	webhooksMu.RLock()
+	defer webhooksMu.RUnlock()
+	for _, hook := range webhooks[objectStruct] {
+		if hook.URL == url {
+			return hook, true
+		}
+	}
+	return Webhook{}, false
+}
+
+func enqueueWebhooks(ctx context.Context, tx *gorm.DB, event *TransitionEvent) error {
+	name := StructName(event.Object)
+	webhooksMu.RLock()
+	hooks := webhooks[name]
+	webhooksMu.RUnlock()
+	if len(hooks) == 0 {
+		return nil
+	}
+	p, err := NewEventPayload(*event)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	for _, hook := range hooks {
+		if !hook.accepts(event.Trigger) {
+			continue
+		}
+		if err := tx.Create(&StateMachineWebhookDelivery{
+			ObjectId:      p.ObjectId,
+			ObjectStruct:  name,
+			Trigger:       event.Trigger,
+			URL:           hook.URL,
+			Payload:       string(payload),
+			Status:        WebhookPending,
+			NextAttemptAt: p.Timestamp,
+		}).Error; err != nil {
+			return fmt.Errorf("record webhook of %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// SignWebhook returns the X-SM-Signature of body.
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// DeliverWebhooks sends up to limit (all if limit <= 0) pending deliveries
+// that are due, in order, with client, or a client with a 10s timeout if nil.
+// A delivery succeeds when the endpoint answers 2xx; otherwise it is retried
+// later, and marked failed after the MaxAttempts of its webhook.
+func DeliverWebhooks(ctx context.Context, db *gorm.DB, client *http.Client, limit int) (delivered int, err error) {
+	if client == nil {
+		client = webhookClient
+	}
+	db = db.WithContext(ctx)
+	query := db.Where("status = ? AND next_attempt_at <= ?", WebhookPending, time.Now()).Order("id")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	var deliveries []StateMachineWebhookDelivery
+	if err := query.Find(&deliveries).Error; err != nil {
+		return 0, fmt.Errorf("read webhook deliveries: %w", err)
+	}
+
+	for _, delivery := range deliveries {
+		if err := ctx.Err(); err != nil {
+			return delivered, err
+		}
+		hook, ok := webhookOf(delivery.ObjectStruct, delivery.URL)
+		if !ok {
+			if err := db.Model(&delivery).Updates(map[string]interface{}{
+				"status":     WebhookFailed,
+				"last_error": "webhook no longer registered",
+			}).Error; err != nil {
+				return delivered, fmt.Errorf("record webhook failure: %w", err)
+			}
+			continue
+		}
+
+		updates := map[string]interface{}{"attempts": delivery.Attempts + 1}
+		if err := postWebhook(ctx, client, hook, &delivery); err != nil {
+			maxAttempts, delay := hook.MaxAttempts, hook.RetryDelay
+			if maxAttempts <= 0 {
+				maxAttempts = DefaultWebhookMaxAttempts
+			}
+			if delay <= 0 {
+				delay = DefaultWebhookRetryDelay
+			}
+			if delivery.Attempts < 16 {
+				delay <<= delivery.Attempts
+			} else {
+				delay <<= 16
+			}
+			updates["last_error"] = err.Error()
+			updates["next_attempt_at"] = time.Now().Add(delay)
+			if delivery.Attempts+1 >= maxAttempts {
+				updates["status"] = WebhookFailed
+			}
+		} else {
+			updates["status"] = WebhookDelivered
+			updates["delivered_at"] = time.Now()
+			delivered++
+		}
+		if err := db.Model(&delivery).Updates(updates).Error; err != nil {
+			return delivered, fmt.Errorf("record webhook delivery: %w", err)
+		}
+	}
+	return delivered, nil
+}
+
+func postWebhook(ctx context.Context, client *http.Client, hook Webhook, delivery *StateMachineWebhookDelivery) error {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-SM-Delivery", strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set("X-SM-Signature", SignWebhook(hook.Secret, body))
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s answered %s", hook.URL, resp.Status)
+	}
+	return nil
+}
+
+// RunWebhooks calls DeliverWebhooks every interval until ctx is done. Errors
+// are reported to onError, which may be nil.
+func RunWebhooks(ctx context.Context, db *gorm.DB, client *http.Client, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := DeliverWebhooks(ctx, db, client, 0); err != nil && onError != nil && ctx.Err() == nil {
+			onError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}